@@ -1,6 +1,9 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/signal"
@@ -17,14 +20,22 @@ import (
 
 func main() {
 	if len(os.Args) > 1 && (strings.ToLower(os.Args[1]) == "help" || strings.ToLower(os.Args[1]) == "--help" || strings.ToLower(os.Args[1]) == "-h") {
-		log.Printf("Usage: %s [serial=password] [info] [start[=amps] | stop] [debug]", filepath.Base(os.Args[0]))
+		log.Printf("Usage: %s [serial=password] [info] [compat [json]] [start[=amps] | stop] [capture=file] [diag [json]] [debug]", filepath.Base(os.Args[0]))
+		log.Printf("       %s pcap=file [diag] [json]", filepath.Base(os.Args[0]))
+		log.Printf("       %s dissector[=file]", filepath.Base(os.Args[0]))
 		log.Printf("  serial:   EVSE serial number (optional, prints only basic info otherwise)")
 		log.Printf("  password: EVSE password (optional, prints only basic info otherwise)")
-		log.Printf("  compat:   Print some EVSE compatibility info useful for debugging")
+		log.Printf("  compat:   Print some EVSE compatibility info useful for debugging (as JSON with json)")
 		log.Printf("  start:    Start charging after login")
 		log.Printf("  amps:     Maximum current in amps (default: 6A)")
 		log.Printf("  stop:     Stop charging after login")
+		log.Printf("  capture:  Write all sent/received datagrams to file, with passwords redacted (JSON lines if the")
+		log.Printf("            file name ends with .jsonl, compact binary format otherwise)")
+		log.Printf("  diag:     Print a report of unknown commands and values on exit (or for a pcap/capture file)")
 		log.Printf("  debug:    Enable debug logging (includes sent/received datagrams)")
+		log.Printf("  pcap:     Print a transcript of the EVSEMaster datagrams in a pcap/pcapng file (or a capture file)")
+		log.Printf("  json:     Print the transcript, diagnostics or compatibility report as JSON")
+		log.Printf("  dissector: Write a Wireshark Lua dissector to file (or print it)")
 		return
 	}
 
@@ -35,6 +46,12 @@ func main() {
 	start := false
 	amps := types.Amps(6)
 	stop := false
+	capture := ""
+	pcap := ""
+	jsonOutput := false
+	diag := false
+	dissector := false
+	dissectorFile := ""
 
 	for _, arg := range os.Args[1:] {
 		if strings.Contains(arg, "=") {
@@ -47,6 +64,13 @@ func main() {
 						panic(err)
 					}
 					amps = types.Amps(ampsParsed)
+				} else if parts[0] == "capture" {
+					capture = parts[1]
+				} else if parts[0] == "pcap" {
+					pcap = parts[1]
+				} else if parts[0] == "dissector" {
+					dissector = true
+					dissectorFile = parts[1]
 				} else {
 					serial = types.EmSerial(parts[0])
 					password = types.EmPassword(parts[1])
@@ -60,10 +84,50 @@ func main() {
 			debug = true
 		} else if arg == "compat" || arg == "compatibility" {
 			compat = true
+		} else if arg == "diag" || arg == "diagnostics" {
+			diag = true
+		} else if arg == "json" {
+			jsonOutput = true
+		} else if arg == "dissector" {
+			dissector = true
 		}
 	}
 
-	communicator := emproto4go.CreateCommunicator("emproto4go_test")
+	if dissector {
+		writeDissector(dissectorFile)
+		return
+	}
+
+	if pcap != "" && diag {
+		printCaptureDiagnostics(pcap, jsonOutput)
+		return
+	}
+	if pcap != "" {
+		printTranscript(pcap, jsonOutput)
+		return
+	}
+
+	var options []emproto4go.Option
+	if capture != "" {
+		file, err := os.Create(capture)
+		if err != nil {
+			log.Printf("Cannot create capture file: %v", err)
+			return
+		}
+		defer func() { _ = file.Close() }()
+		format := types.CaptureBinary
+		if strings.HasSuffix(strings.ToLower(capture), ".jsonl") {
+			format = types.CaptureJsonLines
+		}
+		recorder, err := emproto4go.CreateCaptureRecorder(file, format, true)
+		if err != nil {
+			log.Printf("Cannot create capture recorder: %v", err)
+			return
+		}
+		options = append(options, emproto4go.WithRecorder(recorder))
+	}
+
+	communicator := emproto4go.CreateCommunicatorWithOptions("emproto4go_test", options...)
 	if debug {
 		communicator.Logger().SetLevel(logrus.TraceLevel)
 	}
@@ -78,72 +142,172 @@ func main() {
 		_ = communicator.DefineEvse(serial).UsePassword(password)
 	}
 
-	// Create a channel to receive events
-	ch := make(chan types.EmEvent, 10)
-	// Watch all event types (empty slice means all types)
-	watcher := communicator.Watch(nil, []types.EmEventType{}, ch)
-	defer watcher.Stop()
-
 	c := make(chan os.Signal, 1)
 
-	// Goroutine to log received events
-	go func() {
-		for event := range ch {
-			if event.Type == types.EvseInfoUpdated {
-				log.Printf("[%v] Evse=%+v, Info=%+v", event.Type, event.Evse, event.Evse.Info())
-			} else if event.Type == types.EvseStateUpdated {
-				log.Printf("[%v] Evse=%+v, State=%+v", event.Type, event.Evse, event.Evse.State())
-			} else if event.Type == types.EvseChargeUpdated {
-				log.Printf("[%v] Evse=%+v, Charge=%+v", event.Type, event.Evse, event.Evse.Charge())
-			} else if event.Type == types.EvseConfigUpdated {
-				log.Printf("[%v] Evse=%+v, Config=%+v", event.Type, event.Evse, event.Evse.Config())
-			} else {
-				log.Printf("[%v] Evse=%+v", event.Type, event.Evse)
-			}
+	// Log received events (empty filter means all EVSEs and all event types).
+	unsubscribe := communicator.Subscribe(types.EmEventFilter{}, func(event types.EmEvent) {
+		if event.Type == types.EvseInfoUpdated {
+			log.Printf("[%v] Evse=%+v, Info=%+v", event.Type, event.Evse, event.Evse.Info())
+		} else if event.Type == types.EvseStateUpdated {
+			log.Printf("[%v] Evse=%+v, State=%+v", event.Type, event.Evse, event.Evse.State())
+		} else if event.Type == types.EvseChargeUpdated {
+			log.Printf("[%v] Evse=%+v, Charge=%+v", event.Type, event.Evse, event.Evse.Charge())
+		} else if event.Type == types.EvseConfigUpdated {
+			log.Printf("[%v] Evse=%+v, Config=%+v", event.Type, event.Evse, event.Evse.Config())
+		} else {
+			log.Printf("[%v] Evse=%+v", event.Type, event.Evse)
+		}
 
-			if event.Type == types.EvseLoggedIn {
-				if start {
-					go func() {
-						time.Sleep(5 * time.Second)
-						result, err := event.Evse.StartCharge(types.ChargeStartParams{
-							MaxCurrent: amps,
-						})
-						if err != nil {
-							log.Printf("Error starting charge: %v", err)
-						} else {
-							log.Printf("Charge started successfully; result: %+v", result)
-						}
-					}()
-				} else if stop {
-					go func() {
-						time.Sleep(5 * time.Second)
-						result, err := event.Evse.StopCharge(types.ChargeStopParams{})
-						if err != nil {
-							log.Printf("Error stopping charge: %v", err)
-						} else {
-							log.Printf("Charge stopped successfully; result: %+v", result)
-						}
-					}()
-				}
+		if event.Type == types.EvseLoggedIn {
+			if start {
+				go func() {
+					time.Sleep(5 * time.Second)
+					result, err := event.Evse.StartCharge(types.ChargeStartParams{
+						MaxCurrent: amps,
+					})
+					if err != nil {
+						log.Printf("Error starting charge: %v", err)
+					} else {
+						log.Printf("Charge started successfully; result: %+v", result)
+					}
+				}()
+			} else if stop {
+				go func() {
+					time.Sleep(5 * time.Second)
+					result, err := event.Evse.StopCharge(types.ChargeStopParams{})
+					if err != nil {
+						log.Printf("Error stopping charge: %v", err)
+					} else {
+						log.Printf("Charge stopped successfully; result: %+v", result)
+					}
+				}()
+			}
 
-				if compat {
-					log.Printf("Working on it...")
-					// Wait a bit for the version datagrams, then print compatibility info.
-					go func() {
-						time.Sleep(5 * time.Second)
+			if compat {
+				log.Printf("Working on it...")
+				// Wait a bit for the version datagrams, then print compatibility info.
+				go func() {
+					time.Sleep(5 * time.Second)
+					if jsonOutput {
+						data, _ := json.MarshalIndent(event.Evse.CompatReport(), "", "  ")
+						fmt.Println(string(data))
+					} else {
 						printCompatInfo(event.Evse)
-						c <- os.Interrupt
-					}()
-				}
+					}
+					c <- os.Interrupt
+				}()
 			}
 		}
-	}()
+	})
+	defer unsubscribe()
 
 	// Wait for Ctrl+C (SIGINT) or SIGTERM
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	log.Println("Press Ctrl+C to exit.")
 	<-c
 	log.Println("Stopping...")
+	if diag {
+		printDiagnostics(communicator.Diagnostics(), jsonOutput)
+	}
+}
+
+// openCapture opens a pcap/pcapng file or a capture file. The caller must close the returned file.
+func openCapture(path string) (*os.File, types.EmCaptureReader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Try pcap/pcapng first, then our own capture formats.
+	reader, err := emproto4go.CreatePcapReader(file)
+	if err != nil {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			_ = file.Close()
+			return nil, nil, err
+		}
+		if reader, err = emproto4go.CreateCaptureReader(file); err != nil {
+			_ = file.Close()
+			return nil, nil, err
+		}
+	}
+	return file, reader, nil
+}
+
+func printTranscript(path string, jsonOutput bool) {
+	file, reader, err := openCapture(path)
+	if err != nil {
+		log.Printf("Cannot read capture: %v", err)
+		return
+	}
+	defer func() { _ = file.Close() }()
+
+	entries, err := emproto4go.Transcribe(reader)
+	if jsonOutput {
+		if entries == nil {
+			entries = []types.EmTranscriptEntry{}
+		}
+		data, _ := json.MarshalIndent(entries, "", "  ")
+		fmt.Println(string(data))
+	} else {
+		for _, entry := range entries {
+			fmt.Println(entry)
+		}
+	}
+	if err != nil {
+		log.Printf("Error reading capture (transcript is incomplete): %v", err)
+	}
+}
+
+func printCaptureDiagnostics(path string, jsonOutput bool) {
+	file, reader, err := openCapture(path)
+	if err != nil {
+		log.Printf("Cannot read capture: %v", err)
+		return
+	}
+	defer func() { _ = file.Close() }()
+
+	result, err := emproto4go.ReplayCapture(reader, 0)
+	if result.Communicator != nil {
+		printDiagnostics(result.Communicator.Diagnostics(), jsonOutput)
+	}
+	if err != nil {
+		log.Printf("Error replaying capture (report is incomplete): %v", err)
+	}
+}
+
+func printDiagnostics(report types.EmDiagnostics, jsonOutput bool) {
+	if jsonOutput {
+		data, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Println(string(data))
+		return
+	}
+	fmt.Printf("Unknown commands since %s:\n", report.Since.Format(time.RFC3339))
+	for _, command := range report.UnknownCommands {
+		fmt.Printf("  %v\n", command)
+	}
+	if len(report.UnknownCommands) == 0 {
+		fmt.Println("  (none)")
+	}
+	fmt.Printf("Unknown values since %s:\n", report.Since.Format(time.RFC3339))
+	for _, value := range report.UnknownValues {
+		fmt.Printf("  %v\n", value)
+	}
+	if len(report.UnknownValues) == 0 {
+		fmt.Println("  (none)")
+	}
+}
+
+func writeDissector(path string) {
+	lua := emproto4go.GenerateWiresharkDissector()
+	if path == "" {
+		fmt.Print(lua)
+		return
+	}
+	if err := os.WriteFile(path, []byte(lua), 0o644); err != nil {
+		log.Printf("Cannot write dissector: %v", err)
+		return
+	}
+	log.Printf("Wrote Wireshark dissector to %s", path)
 }
 
 func printCompatInfo(evse types.EmEvse) {
@@ -159,6 +323,7 @@ func printCompatInfo(evse types.EmEvse) {
 	log.Printf("║ EVSE Type:            %16d ║", info.EvseType())
 	log.Printf("║ Serial Number:        %16s ║", info.Serial())
 	log.Printf("║ Meta State:           %16s ║", evse.MetaState())
+	log.Printf("║ Compatibility:        %16s ║", evse.CompatReport().Status)
 	log.Printf("║ Hardware Version:     %16s ║", info.HardwareVersion())
 	log.Printf("║ Software Version:     %16s ║", info.SoftwareVersion())
 	log.Printf("║ Phases:               %16d ║", info.Phases())
@@ -168,6 +333,10 @@ func printCompatInfo(evse types.EmEvse) {
 	log.Printf("║ Supported Features:         0x%08X ║", info.Feature())
 	log.Printf("║ Supported New:              0x%08X ║", info.SupportNew())
 	log.Printf("║ Byte70:                           0x%02X ║", info.Byte70())
+	log.Printf("║ Capabilities:                          ║")
+	for _, name := range evse.Capabilities().Names() {
+		log.Printf("║   %-36s ║", name)
+	}
 	log.Printf("╠═════════════════CONFIG═════════════════╣")
 	log.Printf("║ Configured Name:      %16s ║", config.Name())
 	log.Printf("║ Language:             %16d ║", config.Language())
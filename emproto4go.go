@@ -1,8 +1,11 @@
 package emproto4go
 
 import (
+	"io"
+	"net"
+
 	"github.com/johnwoo-nl/emproto4go/internal"
-	_ "github.com/johnwoo-nl/emproto4go/internal/handlers" // for side effects (handlers self-register via init)
+	"github.com/johnwoo-nl/emproto4go/internal/handlers"
 	"github.com/johnwoo-nl/emproto4go/types"
 )
 
@@ -12,5 +15,163 @@ import (
 //lint:ignore U1000 exported API
 //goland:noinspection GoUnusedExportedFunction
 func CreateCommunicator(appName types.UserId) types.EmCommunicator {
-	return internal.CreateCommunicator(appName)
+	return CreateCommunicatorWithOptions(appName)
+}
+
+// CreateCommunicatorWithOptions creates a new communicator instance configured by the given options (see the With*
+// functions). Options not given keep their defaults, as do durations that are out of range (negative, or zero where
+// zero has no meaning), which are logged as a warning.
+//
+//lint:ignore U1000 exported API
+//goland:noinspection GoUnusedExportedFunction
+func CreateCommunicatorWithOptions(appName types.UserId, options ...Option) types.EmCommunicator {
+	communicatorOptions := internal.DefaultCommunicatorOptions()
+	for _, option := range options {
+		option(&communicatorOptions)
+	}
+	communicator := internal.CreateCommunicator(appName, communicatorOptions)
+	handlers.RegisterBuiltin(communicator.Handlers())
+	return communicator
+}
+
+// CreateCommunicatorWithTransport creates a new communicator instance that uses the given transport instead of the
+// default UDP socket on port 28376. This is the same as CreateCommunicatorWithOptions with WithTransport.
+//
+//lint:ignore U1000 exported API
+//goland:noinspection GoUnusedExportedFunction
+func CreateCommunicatorWithTransport(appName types.UserId, transport types.EmTransport) types.EmCommunicator {
+	return CreateCommunicatorWithOptions(appName, WithTransport(transport))
+}
+
+// CreateUdpTransport creates a UDP transport that will listen on the given address when opened. This is the
+// transport that communicators use by default (listening on 0.0.0.0:28376).
+//
+//lint:ignore U1000 exported API
+//goland:noinspection GoUnusedExportedFunction
+func CreateUdpTransport(addr *net.UDPAddr) types.EmTransport {
+	return internal.CreateUdpTransport(addr)
+}
+
+// CreatePipeNetwork creates an in-memory network. Use its Endpoint method to create transports for communicators
+// (and simulated EVSEs) that exchange datagrams without binding real ports.
+//
+//lint:ignore U1000 exported API
+//goland:noinspection GoUnusedExportedFunction
+func CreatePipeNetwork() types.EmPipeNetwork {
+	return internal.CreatePipeNetwork()
+}
+
+// CreateJsonFileStore creates a store that keeps EVSE records in a JSON file at the given path. The file is created
+// when the first record is saved.
+//
+//lint:ignore U1000 exported API
+//goland:noinspection GoUnusedExportedFunction
+func CreateJsonFileStore(path string) types.EmStore {
+	return internal.CreateJsonFileStore(path)
+}
+
+// CreateEnvCredentialProvider creates a read-only credential provider that reads EVSE passwords from environment
+// variables named prefix + serial (in upper case). If prefix is empty, EMPROTO4GO_PASSWORD_ is used, e.g.
+// EMPROTO4GO_PASSWORD_1234567890ABCDEF=123456.
+//
+//lint:ignore U1000 exported API
+//goland:noinspection GoUnusedExportedFunction
+func CreateEnvCredentialProvider(prefix string) types.EmCredentialProvider {
+	return internal.CreateEnvCredentialProvider(prefix)
+}
+
+// CreateEncryptedFileCredentialProvider creates a credential provider that keeps EVSE passwords in a file at the
+// given path, encrypted with a key derived from the passphrase. Passwords are added to the file after logging in
+// with them. Returns an error if the file exists but cannot be decrypted with the passphrase.
+//
+//lint:ignore U1000 exported API
+//goland:noinspection GoUnusedExportedFunction
+func CreateEncryptedFileCredentialProvider(path string, passphrase string) (types.EmCredentialProvider, error) {
+	provider, err := internal.CreateEncryptedFileCredentialProvider(path, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return provider, nil
+}
+
+// CreateCaptureRecorder creates a recorder (see WithRecorder) that writes datagrams to writer in the given format. If
+// redactPasswords is true, the password bytes of each datagram are zeroed (and its checksum recomputed), so the capture
+// can be shared safely.
+//
+//lint:ignore U1000 exported API
+//goland:noinspection GoUnusedExportedFunction
+func CreateCaptureRecorder(writer io.Writer, format types.EmCaptureFormat, redactPasswords bool) (types.EmRecorder, error) {
+	recorder, err := internal.CreateCaptureRecorder(writer, format, redactPasswords)
+	if err != nil {
+		return nil, err
+	}
+	return recorder, nil
+}
+
+// CreateCaptureReader creates a reader for a capture written by a recorder created with CreateCaptureRecorder. The
+// format is detected automatically.
+//
+//lint:ignore U1000 exported API
+//goland:noinspection GoUnusedExportedFunction
+func CreateCaptureReader(reader io.Reader) (types.EmCaptureReader, error) {
+	captureReader, err := internal.CreateCaptureReader(reader)
+	if err != nil {
+		return nil, err
+	}
+	return captureReader, nil
+}
+
+// CreatePcapReader creates a capture reader for a pcap or pcapng file (e.g. from Wireshark or tcpdump), which returns
+// the payloads of UDP packets to or from port 28376. Such a reader can be used with Transcribe or ReplayCapture.
+//
+//lint:ignore U1000 exported API
+//goland:noinspection GoUnusedExportedFunction
+func CreatePcapReader(reader io.Reader) (types.EmCaptureReader, error) {
+	pcapReader, err := internal.CreatePcapReader(reader)
+	if err != nil {
+		return nil, err
+	}
+	return pcapReader, nil
+}
+
+// Transcribe reads all datagrams from a capture and decodes their headers, for analysis. The entries can be printed
+// (one line per datagram) or marshalled to JSON. If reading the capture fails halfway, the entries read so far are
+// returned with the error.
+//
+//lint:ignore U1000 exported API
+//goland:noinspection GoUnusedExportedFunction
+func Transcribe(reader types.EmCaptureReader) ([]types.EmTranscriptEntry, error) {
+	return internal.Transcribe(reader)
+}
+
+// GenerateWiresharkDissector returns the source of a Wireshark Lua dissector for the EVSEMaster protocol, which
+// decodes the header of datagrams on UDP port 28376 and the payloads of the known commands (status, charging, login,
+// version and charge start).
+//
+//lint:ignore U1000 exported API
+//goland:noinspection GoUnusedExportedFunction
+func GenerateWiresharkDissector() string {
+	return internal.GenerateDissector()
+}
+
+// CreateHandler creates a handler for EmCommunicator.RegisterHandler that calls handle for datagrams with the given
+// commands.
+//
+//lint:ignore U1000 exported API
+//goland:noinspection GoUnusedExportedFunction
+func CreateHandler(handle func(evse types.EmEvse, command uint16, payload []byte), commands ...uint16) types.EmHandler {
+	return handlerFunc{handle: handle, commands: commands}
+}
+
+type handlerFunc struct {
+	handle   func(evse types.EmEvse, command uint16, payload []byte)
+	commands []uint16
+}
+
+func (handler handlerFunc) Commands() []uint16 {
+	return handler.commands
+}
+
+func (handler handlerFunc) Handle(evse types.EmEvse, command uint16, payload []byte) {
+	handler.handle(evse, command, payload)
 }
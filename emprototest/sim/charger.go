@@ -0,0 +1,538 @@
+package sim
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/johnwoo-nl/emproto4go/protocol"
+	"github.com/johnwoo-nl/emproto4go/types"
+)
+
+// Charger is a single simulated EVSE. It broadcasts CmdLogin until an app logs in, then sends CmdHeading,
+// CmdSingleACStatus and CmdSingleACChargingPublicAuto datagrams periodically, and answers requests like a real
+// charger would.
+type Charger struct {
+	simulator *Simulator
+	config    ChargerConfig
+
+	mutex     sync.Mutex
+	transport types.EmTransport
+	started   bool
+	stopChan  chan struct{}
+	appAddr   *net.UDPAddr
+
+	loggedIn     bool
+	lastAppSeen  time.Time
+	lastEvolved  time.Time
+	pluggedIn    bool
+	errors       uint32
+	innerTemp    float32
+	voltage      float32
+	current      float32
+	energyTotal  types.KWh
+	currentState types.EmCurrentState
+	clockOffset  time.Duration
+
+	// Current, planned or last charging session.
+	session chargeSession
+}
+
+type chargeSession struct {
+	active          bool
+	reserved        bool
+	lineId          types.LineId
+	chargeId        types.ChargeId
+	userId          types.UserId
+	startType       byte
+	chargeType      byte
+	maxDuration     *time.Duration // nil means no limit.
+	maxEnergy       *types.KWh     // nil means no limit.
+	maxCurrent      types.Amps
+	reservationTime *time.Time
+	startTime       *time.Time
+	duration        time.Duration
+	startEnergy     types.KWh
+	currentEnergy   types.KWh
+}
+
+func newCharger(simulator *Simulator, config ChargerConfig, transport types.EmTransport) *Charger {
+	charger := &Charger{
+		simulator:   simulator,
+		config:      config,
+		transport:   transport,
+		pluggedIn:   config.PluggedIn,
+		innerTemp:   25,
+		voltage:     230,
+		energyTotal: config.EnergyCounter,
+		clockOffset: config.ClockOffset,
+	}
+	charger.currentState = charger.idleState()
+	return charger
+}
+
+// Serial returns the serial of the simulated charger.
+func (charger *Charger) Serial() types.EmSerial {
+	return charger.config.Serial
+}
+
+// Addr returns the local address the charger sends from, or nil if it is not started.
+func (charger *Charger) Addr() net.Addr {
+	return charger.transport.LocalAddr()
+}
+
+// IsLoggedIn returns whether an app is currently logged in to the charger.
+func (charger *Charger) IsLoggedIn() bool {
+	charger.mutex.Lock()
+	defer charger.mutex.Unlock()
+
+	return charger.loggedIn
+}
+
+// IsCharging returns whether the charger is currently charging.
+func (charger *Charger) IsCharging() bool {
+	charger.mutex.Lock()
+	defer charger.mutex.Unlock()
+
+	return charger.session.active && !charger.session.reserved
+}
+
+// Password returns the password the charger currently accepts.
+func (charger *Charger) Password() types.EmPassword {
+	charger.mutex.Lock()
+	defer charger.mutex.Unlock()
+
+	return charger.config.Password
+}
+
+// ClockOffset returns how far the charger's clock is ahead of real time (negative if behind).
+func (charger *Charger) ClockOffset() time.Duration {
+	charger.mutex.Lock()
+	defer charger.mutex.Unlock()
+
+	return charger.clockOffset
+}
+
+// PlugIn simulates a car being plugged in.
+func (charger *Charger) PlugIn() {
+	charger.mutex.Lock()
+	defer charger.mutex.Unlock()
+
+	charger.pluggedIn = true
+	if !charger.session.active {
+		charger.currentState = charger.idleState()
+	}
+}
+
+// Unplug simulates the car being unplugged. Any ongoing or planned charging session is ended.
+func (charger *Charger) Unplug() {
+	charger.mutex.Lock()
+	defer charger.mutex.Unlock()
+
+	charger.pluggedIn = false
+	charger.endSession()
+	charger.currentState = charger.idleState()
+}
+
+// SetErrors sets the error bitfield the charger reports in its status datagrams (bit N is EmError N).
+func (charger *Charger) SetErrors(errors uint32) {
+	charger.mutex.Lock()
+	defer charger.mutex.Unlock()
+
+	charger.errors = errors
+}
+
+// SetPassword changes the password the charger accepts. Any current login session is ended.
+func (charger *Charger) SetPassword(password types.EmPassword) {
+	charger.mutex.Lock()
+	defer charger.mutex.Unlock()
+
+	charger.config.Password = password
+	charger.loggedIn = false
+}
+
+func (charger *Charger) start() error {
+	if err := charger.transport.Open(); err != nil {
+		return err
+	}
+
+	charger.mutex.Lock()
+	charger.started = true
+	charger.stopChan = make(chan struct{})
+	charger.loggedIn = false
+	charger.lastEvolved = time.Now()
+	stopChan := charger.stopChan
+	charger.mutex.Unlock()
+
+	go charger.receiveLoop()
+	go charger.sendLoop(stopChan)
+	return nil
+}
+
+func (charger *Charger) stop() {
+	charger.mutex.Lock()
+	defer charger.mutex.Unlock()
+
+	if !charger.started {
+		return
+	}
+	close(charger.stopChan)
+	_ = charger.transport.Close()
+	charger.started = false
+	charger.loggedIn = false
+}
+
+func (charger *Charger) sendLoop(stopChan chan struct{}) {
+	ticker := time.NewTicker(charger.simulator.Interval)
+	defer ticker.Stop()
+
+	charger.tick()
+	for {
+		select {
+		case <-stopChan:
+			return
+		case <-ticker.C:
+			charger.tick()
+		}
+	}
+}
+
+func (charger *Charger) tick() {
+	charger.mutex.Lock()
+	charger.evolve()
+	if charger.loggedIn && time.Since(charger.lastAppSeen) > charger.simulator.SessionTimeout {
+		charger.simulator.Logger.Debugf("[sim] Charger %s: login session expired", charger.config.Serial)
+		charger.loggedIn = false
+	}
+	loggedIn := charger.loggedIn
+	charger.mutex.Unlock()
+
+	if !loggedIn {
+		charger.send(nil, protocol.CmdLogin, charger.loginPayload())
+		return
+	}
+	charger.send(nil, protocol.CmdHeading, []byte{0})
+	charger.send(nil, protocol.CmdSingleACStatus, charger.statusPayload())
+	charger.send(nil, protocol.CmdSingleACChargingPublicAuto, charger.chargingPayload())
+}
+
+func (charger *Charger) receiveLoop() {
+	buf := make([]byte, 4096)
+	for {
+		n, addr, err := charger.transport.ReadFrom(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				charger.simulator.Logger.Warnf("[sim] Charger %s: read error: %v", charger.config.Serial, err)
+			}
+			return
+		}
+		datagram, err := protocol.Decode(buf[:n])
+		if err != nil {
+			charger.simulator.Logger.Warnf("[sim] Charger %s: failed to decode datagram from %v: %v", charger.config.Serial, addr, err)
+			continue
+		}
+		if datagram == nil || datagram.Serial != charger.config.Serial {
+			continue
+		}
+		udpAddr, ok := addr.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+		// Copy the payload, since buf is reused for the next datagram.
+		datagram.Payload = append([]byte(nil), datagram.Payload...)
+		charger.handle(datagram, udpAddr)
+	}
+}
+
+func (charger *Charger) handle(datagram *protocol.Datagram, addr *net.UDPAddr) {
+	charger.simulator.Logger.Tracef("[sim] Charger %s <- RECV %v from %v", charger.config.Serial, datagram, addr)
+
+	charger.mutex.Lock()
+	charger.appAddr = addr
+	passwordOk := datagram.Password == charger.config.Password
+	if passwordOk {
+		charger.lastAppSeen = time.Now()
+	}
+	loggedIn := charger.loggedIn
+	charger.mutex.Unlock()
+
+	switch datagram.Command {
+	case protocol.CmdRequestLogin:
+		if passwordOk {
+			charger.send(addr, protocol.CmdLoginResponse, charger.loginPayload())
+		} else {
+			charger.send(addr, protocol.CmdPasswordErrorResponse, []byte{0})
+		}
+		return
+	case protocol.CmdLoginConfirm:
+		if passwordOk {
+			charger.mutex.Lock()
+			charger.loggedIn = true
+			charger.mutex.Unlock()
+			charger.send(addr, protocol.CmdSingleACStatus, charger.statusPayload())
+		} else {
+			charger.send(addr, protocol.CmdPasswordErrorResponse, []byte{0})
+		}
+		return
+	}
+
+	if !passwordOk || !loggedIn {
+		charger.send(addr, protocol.CmdPasswordErrorResponse, []byte{0})
+		return
+	}
+
+	switch datagram.Command {
+	case protocol.CmdHeadingResponse, protocol.CmdSingleACStatusAck, protocol.CmdSingleACChargingAck:
+		// Only keeps the session alive (done above).
+	case protocol.CmdGetVersion:
+		charger.send(addr, protocol.CmdGetVersionResponse, charger.versionPayload())
+	case protocol.CmdRequestSingleACCharging:
+		charger.send(addr, protocol.CmdSingleACChargingStatusResponse, charger.chargingPayload())
+	case protocol.CmdSetAndGetName, protocol.CmdSetAndGetLanguage, protocol.CmdSetAndGetTemperatureUnit,
+		protocol.CmdSetAndGetOfflineCharge, protocol.CmdSetAndGetMaxCurrent:
+		charger.send(addr, datagram.Command-0x8000, charger.handleConfig(datagram))
+	case protocol.CmdSetAndGetSystemTime:
+		charger.send(addr, protocol.CmdSetAndGetSystemTimeResponse, charger.handleSystemTime(datagram.Payload))
+	case protocol.CmdSetPassword:
+		charger.send(addr, protocol.CmdSetPasswordResponse, charger.handleSetPassword(datagram.Payload))
+	case protocol.CmdChargeStart:
+		charger.send(addr, protocol.CmdChargeStartResponse, charger.handleChargeStart(datagram.Payload))
+	case protocol.CmdChargeStop:
+		charger.send(addr, protocol.CmdChargeStopResponse, charger.handleChargeStop(datagram.Payload))
+	default:
+		charger.simulator.Logger.Debugf("[sim] Charger %s: ignoring unsupported command %v", charger.config.Serial, datagram.Command)
+	}
+}
+
+func (charger *Charger) handleConfig(datagram *protocol.Datagram) []byte {
+	charger.mutex.Lock()
+	defer charger.mutex.Unlock()
+
+	set := len(datagram.Payload) >= 2 && datagram.Payload[0] == 0x01
+	var value []byte
+	switch datagram.Command {
+	case protocol.CmdSetAndGetName:
+		if set {
+			charger.config.Name = strings.TrimPrefix(protocol.ReadString(datagram.Payload[1:]), "ACP#")
+		}
+		value = make([]byte, 32)
+		copy(value, "ACP#"+charger.config.Name)
+	case protocol.CmdSetAndGetLanguage:
+		if set {
+			charger.config.Language = types.EmLanguage(datagram.Payload[1])
+		}
+		value = []byte{byte(charger.config.Language)}
+	case protocol.CmdSetAndGetTemperatureUnit:
+		if set {
+			charger.config.TemperatureUnit = types.EmTemperatureUnit(datagram.Payload[1])
+		}
+		value = []byte{byte(charger.config.TemperatureUnit)}
+	case protocol.CmdSetAndGetOfflineCharge:
+		if set {
+			charger.config.OfflineCharge = datagram.Payload[1] == 0
+		}
+		// Note the inverted logic: 0 means offline charging is enabled.
+		value = []byte{1}
+		if charger.config.OfflineCharge {
+			value = []byte{0}
+		}
+	case protocol.CmdSetAndGetMaxCurrent:
+		if set {
+			charger.config.ConfiguredMaxCurrent = types.Amps(datagram.Payload[1])
+		}
+		value = []byte{byte(charger.config.ConfiguredMaxCurrent)}
+	}
+
+	action := byte(0x02)
+	if set {
+		action = 0x01
+	}
+	return append([]byte{action}, value...)
+}
+
+func (charger *Charger) handleSystemTime(data []byte) []byte {
+	charger.mutex.Lock()
+	defer charger.mutex.Unlock()
+
+	request, err := protocol.DecodeSystemTimePayload(data)
+	if err == nil && request.Action == protocol.ConfigSet && request.Time != nil {
+		charger.clockOffset = time.Until(*request.Time).Round(time.Second)
+	}
+	now := charger.clockNow()
+	return protocol.SystemTimePayload{Action: request.Action, Time: &now}.Encode()
+}
+
+// handleSetPassword changes the password if the new one is valid, and ends the login session like SetPassword. The
+// response has the password the charger accepts from now on.
+func (charger *Charger) handleSetPassword(data []byte) []byte {
+	charger.mutex.Lock()
+	defer charger.mutex.Unlock()
+
+	request, err := protocol.DecodePasswordPayload(data)
+	if err == nil && request.Action == protocol.ConfigSet && request.Password.IsValid() && !charger.config.FixedPassword {
+		charger.config.Password = request.Password
+		charger.loggedIn = false
+	}
+	return protocol.PasswordPayload{Action: request.Action, Password: charger.config.Password}.Encode()
+}
+
+func (charger *Charger) handleChargeStart(data []byte) []byte {
+	charger.mutex.Lock()
+	defer charger.mutex.Unlock()
+
+	request, err := protocol.DecodeChargeStartPayload(data)
+	if err != nil {
+		return protocol.ChargeStartResponsePayload{ErrorReason: types.ChargeStartErrorSystemError}.Encode()
+	}
+	response := protocol.ChargeStartResponsePayload{LineId: request.LineId}
+
+	switch {
+	case !charger.pluggedIn:
+		response.ErrorReason = types.ChargeStartErrorPlugNotProperlyInserted
+	case charger.errors != 0:
+		response.ErrorReason = types.ChargeStartErrorSystemError
+	case charger.session.active && charger.session.reserved:
+		response.ErrorReason = types.ChargeStartErrorAlreadyReserved
+	case charger.session.active:
+		response.ErrorReason = types.ChargeStartErrorAlreadyCharging
+	default:
+		now := charger.clockNow()
+		maxCurrent := min(request.MaxCurrent, charger.config.MaxCurrent)
+		charger.session = chargeSession{
+			active:        true,
+			reserved:      request.IsReservation,
+			lineId:        request.LineId,
+			userId:        request.UserId,
+			chargeId:      request.ChargeId,
+			startType:     request.StartType,
+			chargeType:    request.ChargeType,
+			maxDuration:   request.MaxDuration,
+			maxEnergy:     request.MaxEnergy,
+			maxCurrent:    maxCurrent,
+			startTime:     &now,
+			startEnergy:   charger.energyTotal,
+			currentEnergy: charger.energyTotal,
+		}
+		if charger.session.reserved {
+			charger.session.reservationTime = request.StartTime
+			charger.currentState = types.ChargingReservation
+		} else {
+			charger.currentState = types.Charging
+		}
+		response.Current = maxCurrent
+	}
+	return response.Encode()
+}
+
+func (charger *Charger) handleChargeStop(data []byte) []byte {
+	charger.mutex.Lock()
+	defer charger.mutex.Unlock()
+
+	response := protocol.ChargeStopResponsePayload{}
+	if len(data) > 0 {
+		response.LineId = types.LineId(data[0])
+	}
+	// No error codes are known for ChargeStop besides 0 (no error), so stopping always succeeds.
+	charger.endSession()
+	return response.Encode()
+}
+
+// endSession ends the current or planned session, if any. Must be called with the mutex held.
+func (charger *Charger) endSession() {
+	if !charger.session.active {
+		return
+	}
+	charger.session.active = false
+	charger.session.reserved = false
+	charger.current = 0
+	if charger.pluggedIn {
+		charger.currentState = types.Completed
+	} else {
+		charger.currentState = charger.idleState()
+	}
+}
+
+// clockNow returns the time of the charger's clock. Must be called with the mutex held.
+func (charger *Charger) clockNow() time.Time {
+	return time.Now().Add(charger.clockOffset)
+}
+
+// idleState returns the current state when no session is active. Must be called with the mutex held.
+func (charger *Charger) idleState() types.EmCurrentState {
+	if charger.pluggedIn {
+		return types.ReadyToCharge
+	}
+	return types.NotConnected
+}
+
+// evolve advances the simulated physics: session start for reservations, current, energy, temperature and
+// session limits. Must be called with the mutex held.
+func (charger *Charger) evolve() {
+	now := time.Now()
+	elapsed := now.Sub(charger.lastEvolved)
+	charger.lastEvolved = now
+
+	charger.voltage = 228 + rand.Float32()*4
+
+	// Reservations start according to the charger's own clock, which may drift from real time.
+	clockNow := charger.clockNow()
+	session := &charger.session
+	if session.active && session.reserved && session.reservationTime != nil && !clockNow.Before(*session.reservationTime) {
+		session.reserved = false
+		session.startTime = &clockNow
+		session.startEnergy = charger.energyTotal
+		session.currentEnergy = charger.energyTotal
+		charger.currentState = types.Charging
+	}
+
+	if !session.active || session.reserved {
+		charger.current = 0
+		charger.innerTemp = max(25, charger.innerTemp-0.1*float32(elapsed.Seconds()))
+		return
+	}
+
+	// Ramp up to the session's maximum current, with some jitter like a real car.
+	target := float32(session.maxCurrent)
+	charger.current = min(target, charger.current+target/4) - rand.Float32()*0.2
+	charger.innerTemp = min(45, charger.innerTemp+0.05*float32(elapsed.Seconds()))
+	power := charger.voltage * charger.current * float32(charger.config.phases())
+	charger.energyTotal += types.KWh(float64(power) * elapsed.Hours() / 1000)
+	session.duration += elapsed
+	session.currentEnergy = charger.energyTotal
+
+	charged := session.currentEnergy - session.startEnergy
+	if (session.maxEnergy != nil && charged >= *session.maxEnergy) ||
+		(session.maxDuration != nil && session.duration >= *session.maxDuration) {
+		charger.endSession()
+		charger.currentState = types.CompletedFullCharge
+	}
+}
+
+func (charger *Charger) send(addr *net.UDPAddr, command protocol.EmCommand, payload []byte) {
+	charger.mutex.Lock()
+	started := charger.started
+	if addr == nil {
+		addr = charger.appAddr
+		if addr == nil || !charger.loggedIn {
+			addr = charger.simulator.Target
+		}
+	}
+	charger.mutex.Unlock()
+
+	if !started {
+		return
+	}
+	datagram := &protocol.Datagram{Serial: charger.config.Serial, Command: command, Payload: payload}
+	data, err := datagram.Encode()
+	if err != nil {
+		charger.simulator.Logger.Warnf("[sim] Charger %s: failed to encode datagram: %v", charger.config.Serial, err)
+		return
+	}
+	charger.simulator.Logger.Tracef("[sim] Charger %s -> SEND %v to %v", charger.config.Serial, datagram, addr)
+	if _, err := charger.transport.WriteTo(data, addr); err != nil {
+		charger.simulator.Logger.Debugf("[sim] Charger %s: failed to send datagram: %v", charger.config.Serial, err)
+	}
+}
@@ -0,0 +1,109 @@
+package sim
+
+import (
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/johnwoo-nl/emproto4go/types"
+)
+
+// ChargerConfig describes a simulated charger. Only Serial and Password are required; all other fields default to
+// values resembling a Telestar EC311S.
+type ChargerConfig struct {
+	Serial   types.EmSerial
+	Password types.EmPassword
+
+	Brand           string
+	Model           string
+	HardwareVersion string
+	SoftwareVersion string
+	EvseType        byte
+	MaxPower        types.Watts
+	MaxCurrent      types.Amps
+	Feature         uint32
+	SupportNew      byte
+	Byte70          byte
+
+	// NewProtocol makes the charger send the longer SingleACStatus and SingleACCharging datagrams.
+	NewProtocol bool
+
+	// Initial configuration values.
+	Name                 string
+	Language             types.EmLanguage
+	TemperatureUnit      types.EmTemperatureUnit
+	OfflineCharge        bool
+	ConfiguredMaxCurrent types.Amps
+
+	// PluggedIn indicates whether a car is plugged in initially.
+	PluggedIn bool
+
+	// Initial value of the EVSE's energy counter.
+	EnergyCounter types.KWh
+
+	// ClockOffset is how far the charger's clock is initially ahead of real time (negative if behind). The charger's
+	// clock determines when a reservation starts; it can be set with CmdSetAndGetSystemTime.
+	ClockOffset time.Duration
+
+	// FixedPassword makes the charger respond to CmdSetPassword without changing its password.
+	FixedPassword bool
+}
+
+func (config ChargerConfig) validate() error {
+	if len(config.Serial) != 16 {
+		return fmt.Errorf("serial must be 16 hex characters, got %d", len(config.Serial))
+	}
+	if _, err := hex.DecodeString(string(config.Serial)); err != nil {
+		return fmt.Errorf("serial must be 16 hex characters: %v", err)
+	}
+	if len(config.Password) != 6 {
+		return fmt.Errorf("password must be 6 digits, got %d characters", len(config.Password))
+	}
+	return nil
+}
+
+func (config ChargerConfig) withDefaults() ChargerConfig {
+	// Serials in received datagrams are decoded in lowercase, so the charger's serial must be lowercase to match them.
+	config.Serial = config.Serial.Normalized()
+	if config.Brand == "" {
+		config.Brand = "Telestar"
+	}
+	if config.Model == "" {
+		config.Model = "EC311S"
+	}
+	if config.HardwareVersion == "" {
+		config.HardwareVersion = "SIM-HW-1.0"
+	}
+	if config.SoftwareVersion == "" {
+		config.SoftwareVersion = "SIM-SW-1.0"
+	}
+	if config.EvseType == 0 {
+		config.EvseType = 25
+	}
+	if config.MaxCurrent == 0 {
+		config.MaxCurrent = 16
+	}
+	if config.MaxPower == 0 {
+		config.MaxPower = types.Watts(float32(config.MaxCurrent) * 230 * float32(config.phases()))
+	}
+	if config.Language == types.UnknownLanguage {
+		config.Language = types.English
+	}
+	if config.TemperatureUnit == types.UnknownTempUnit {
+		config.TemperatureUnit = types.Celsius
+	}
+	if config.ConfiguredMaxCurrent == 0 {
+		config.ConfiguredMaxCurrent = config.MaxCurrent
+	}
+	return config
+}
+
+// phases returns the number of phases for the charger's EVSE type, using the same mapping as the library.
+func (config ChargerConfig) phases() int {
+	switch config.EvseType {
+	case 10, 11, 12, 13, 14, 15, 22, 23, 24, 25:
+		return 3
+	default:
+		return 1
+	}
+}
@@ -0,0 +1,119 @@
+package sim
+
+import (
+	"github.com/johnwoo-nl/emproto4go/protocol"
+	"github.com/johnwoo-nl/emproto4go/types"
+)
+
+// loginPayload builds the payload for CmdLogin and CmdLoginResponse.
+func (charger *Charger) loginPayload() []byte {
+	charger.mutex.Lock()
+	defer charger.mutex.Unlock()
+
+	config := charger.config
+	login := protocol.LoginPayload{
+		EvseType:        config.EvseType,
+		Brand:           config.Brand,
+		Model:           config.Model,
+		HardwareVersion: config.HardwareVersion,
+		MaxPower:        config.MaxPower,
+		MaxCurrent:      config.MaxCurrent,
+		Byte70:          config.Byte70,
+	}
+	if config.NewProtocol {
+		login.Length = 119
+	}
+	return login.Encode()
+}
+
+// versionPayload builds the payload for CmdGetVersionResponse.
+func (charger *Charger) versionPayload() []byte {
+	charger.mutex.Lock()
+	defer charger.mutex.Unlock()
+
+	return protocol.VersionPayload{
+		HardwareVersion: charger.config.HardwareVersion,
+		SoftwareVersion: charger.config.SoftwareVersion,
+		Feature:         charger.config.Feature,
+		SupportNew:      charger.config.SupportNew,
+		Length:          37,
+	}.Encode()
+}
+
+// statusPayload builds the payload for CmdSingleACStatus.
+func (charger *Charger) statusPayload() []byte {
+	charger.mutex.Lock()
+	defer charger.mutex.Unlock()
+
+	temp := types.TempCelsius(charger.innerTemp)
+	status := protocol.StatusPayload{
+		LineId:            1,
+		L1Voltage:         types.Volts(charger.voltage),
+		L1Current:         types.Amps(charger.current),
+		Power:             types.Watts(charger.voltage * charger.current * float32(charger.config.phases())),
+		EnergyCounter:     charger.energyTotal,
+		InnerTemp:         &temp,
+		OuterTemp:         &temp,
+		EmergencyBtnState: types.EmergencyButton0,
+		GunState:          types.GunNotConnected,
+		OutputState:       types.OutputStateIdle,
+		CurrentState:      charger.currentState,
+		Errors:            charger.errors,
+	}
+	if charger.session.active {
+		status.LineId = charger.session.lineId
+	}
+	if charger.config.phases() == 3 {
+		status.L2Voltage, status.L3Voltage = status.L1Voltage, status.L1Voltage
+		status.L2Current, status.L3Current = status.L1Current, status.L1Current
+		status.Length = 33
+	}
+	if charger.config.NewProtocol {
+		status.NewProtocolState = charger.currentState
+		status.Length = 35
+	}
+	if charger.pluggedIn {
+		status.GunState = types.GunConnectedUnlocked
+		if charger.session.active {
+			status.GunState = types.GunConnectedLocked
+		}
+	}
+	if charger.session.active && !charger.session.reserved {
+		status.OutputState = types.OutputStateCharging
+	}
+	return status.Encode()
+}
+
+// chargingPayload builds the payload for CmdSingleACChargingPublicAuto and CmdSingleACChargingStatusResponse.
+func (charger *Charger) chargingPayload() []byte {
+	charger.mutex.Lock()
+	defer charger.mutex.Unlock()
+
+	session := charger.session
+	chargedEnergy := session.currentEnergy - session.startEnergy
+	charging := protocol.ChargingPayload{
+		Port:                 1,
+		ChargeState:          charger.currentState,
+		ChargeId:             session.chargeId,
+		StartType:            session.startType,
+		ChargeType:           session.chargeType,
+		ReservationTime:      session.reservationTime,
+		UserId:               session.userId,
+		MaxCurrent:           session.maxCurrent,
+		StartTime:            session.startTime,
+		Duration:             session.duration,
+		StartEnergyCounter:   &session.startEnergy,
+		CurrentEnergyCounter: &session.currentEnergy,
+		ChargedEnergy:        &chargedEnergy,
+	}
+	// Limits are as requested, and not set when there is no session.
+	if session.chargeId != "" {
+		charging.MaxDuration = session.maxDuration
+		charging.MaxEnergy = session.maxEnergy
+	}
+	if charger.config.NewProtocol {
+		charging.NewProtocolState = charger.currentState
+		charging.Length = 75
+	}
+	return charging.Encode()
+}
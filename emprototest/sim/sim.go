@@ -0,0 +1,150 @@
+// Package sim provides an in-process simulator for EVSEs speaking the EVSEMaster protocol. It can impersonate one
+// or more chargers on the network, so apps (and the library itself) can be exercised end to end without a physical
+// charger on the LAN.
+package sim
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/johnwoo-nl/emproto4go/internal"
+	"github.com/johnwoo-nl/emproto4go/types"
+)
+
+// Simulator manages a set of simulated chargers that all send their datagrams to the same target address (normally
+// the address a communicator is listening on).
+type Simulator struct {
+	// Target is the address that chargers send their unsolicited datagrams (login broadcasts, status updates) to.
+	Target *net.UDPAddr
+
+	// Interval is the interval at which chargers send unsolicited datagrams. Defaults to 2 seconds.
+	Interval time.Duration
+
+	// SessionTimeout is the time after which a charger considers a login session expired when it hasn't heard
+	// from the app. Defaults to 15 seconds.
+	SessionTimeout time.Duration
+
+	// Logger is used for logging by the simulator and its chargers. Defaults to a logger on WarnLevel.
+	Logger *logrus.Logger
+
+	// Network is the in-memory network chargers attach to, or nil if chargers use UDP sockets.
+	Network types.EmPipeNetwork
+
+	chargers      []*Charger
+	chargersMutex sync.Mutex
+	started       bool
+}
+
+// CreateSimulator creates a new simulator whose chargers will send their datagrams to the given target address.
+// If target is nil, the chargers send to 127.0.0.1:28376, which is where a communicator on the same host listens.
+func CreateSimulator(target *net.UDPAddr) *Simulator {
+	if target == nil {
+		target = &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 28376}
+	}
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+	return &Simulator{
+		Target:         target,
+		Interval:       2 * time.Second,
+		SessionTimeout: 15 * time.Second,
+		Logger:         logger,
+	}
+}
+
+// CreatePipeSimulator creates a new simulator whose chargers attach to the given in-memory network instead of using
+// UDP sockets. Each charger gets its own address on the network (10.28.0.1, 10.28.0.2, ...). If target is nil, the
+// chargers broadcast to 255.255.255.255:28376, so a communicator with an endpoint on port 28376 will discover them.
+func CreatePipeSimulator(network types.EmPipeNetwork, target *net.UDPAddr) *Simulator {
+	if target == nil {
+		target = &net.UDPAddr{IP: net.IPv4bcast, Port: 28376}
+	}
+	simulator := CreateSimulator(target)
+	simulator.Network = network
+	return simulator
+}
+
+// AddCharger adds a simulated charger with the given configuration. If the simulator is already started, the
+// charger is started immediately as well.
+func (simulator *Simulator) AddCharger(config ChargerConfig) (*Charger, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	config = config.withDefaults()
+	simulator.chargersMutex.Lock()
+	defer simulator.chargersMutex.Unlock()
+
+	for _, existing := range simulator.chargers {
+		if existing.config.Serial == config.Serial {
+			return nil, fmt.Errorf("charger with serial %s already exists", config.Serial)
+		}
+	}
+
+	charger := newCharger(simulator, config, simulator.createTransport(len(simulator.chargers)))
+	if simulator.started {
+		if err := charger.start(); err != nil {
+			return nil, err
+		}
+	}
+	simulator.chargers = append(simulator.chargers, charger)
+	return charger, nil
+}
+
+// Chargers returns all chargers of this simulator.
+func (simulator *Simulator) Chargers() []*Charger {
+	simulator.chargersMutex.Lock()
+	defer simulator.chargersMutex.Unlock()
+
+	chargers := make([]*Charger, len(simulator.chargers))
+	copy(chargers, simulator.chargers)
+	return chargers
+}
+
+// Start starts all chargers; they will begin broadcasting their presence to the target address.
+func (simulator *Simulator) Start() error {
+	simulator.chargersMutex.Lock()
+	defer simulator.chargersMutex.Unlock()
+
+	if simulator.started {
+		return fmt.Errorf("simulator already started")
+	}
+	for i, charger := range simulator.chargers {
+		if err := charger.start(); err != nil {
+			for _, started := range simulator.chargers[:i] {
+				started.stop()
+			}
+			return err
+		}
+	}
+	simulator.started = true
+	return nil
+}
+
+// Stop stops all chargers. They will go silent, so a communicator will consider them offline after a while.
+func (simulator *Simulator) Stop() {
+	simulator.chargersMutex.Lock()
+	defer simulator.chargersMutex.Unlock()
+
+	if !simulator.started {
+		return
+	}
+	for _, charger := range simulator.chargers {
+		charger.stop()
+	}
+	simulator.started = false
+}
+
+// createTransport creates the transport for the charger with the given index. Must be called with chargersMutex held.
+func (simulator *Simulator) createTransport(index int) types.EmTransport {
+	if simulator.Network != nil {
+		return simulator.Network.Endpoint(&net.UDPAddr{IP: net.IPv4(10, 28, byte((index+1)/256), byte((index+1)%256)), Port: 28376})
+	}
+	// Bind to the target IP if it's local, so the charger appears to come from the same host as the communicator.
+	if simulator.Target.IP.IsLoopback() {
+		return internal.CreateUdpTransport(&net.UDPAddr{IP: simulator.Target.IP})
+	}
+	return internal.CreateUdpTransport(&net.UDPAddr{})
+}
@@ -0,0 +1,85 @@
+package sim_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/johnwoo-nl/emproto4go/emprototest/sim"
+	"github.com/johnwoo-nl/emproto4go/internal"
+	"github.com/johnwoo-nl/emproto4go/protocol"
+	"github.com/johnwoo-nl/emproto4go/types"
+)
+
+func TestAddChargerDuplicateSerial(t *testing.T) {
+	simulator := sim.CreatePipeSimulator(internal.CreatePipeNetwork(), nil)
+	if _, err := simulator.AddCharger(sim.ChargerConfig{Serial: "0123456789abcdef", Password: "123456"}); err != nil {
+		t.Fatalf("AddCharger: %v", err)
+	}
+	// Serials are compared normalized, so an uppercase serial is the same charger.
+	if _, err := simulator.AddCharger(sim.ChargerConfig{Serial: "0123456789ABCDEF", Password: "654321"}); err == nil {
+		t.Errorf("adding a charger with a duplicate serial succeeded")
+	}
+	second, err := simulator.AddCharger(sim.ChargerConfig{Serial: "fedcba9876543210", Password: "123456"})
+	if err != nil {
+		t.Fatalf("AddCharger: %v", err)
+	}
+	if count := len(simulator.Chargers()); count != 2 {
+		t.Errorf("simulator has %d chargers, expected 2", count)
+	}
+
+	if err := simulator.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer simulator.Stop()
+	// The rejected charger did not take an address.
+	if addr := second.Addr().String(); addr != "10.28.0.2:28376" {
+		t.Errorf("second charger has address %s, expected 10.28.0.2:28376", addr)
+	}
+}
+
+// TestStart checks that started chargers broadcast their login, including chargers added after Start.
+func TestStart(t *testing.T) {
+	network := internal.CreatePipeNetwork()
+	listener := network.Endpoint(&net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 28376})
+	if err := listener.Open(); err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer func() { _ = listener.Close() }()
+
+	simulator := sim.CreatePipeSimulator(network, nil)
+	simulator.Interval = 20 * time.Millisecond
+	if _, err := simulator.AddCharger(sim.ChargerConfig{Serial: "0123456789abcdef", Password: "123456"}); err != nil {
+		t.Fatalf("AddCharger: %v", err)
+	}
+	if err := simulator.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer simulator.Stop()
+	if err := simulator.Start(); err == nil {
+		t.Errorf("starting the simulator twice succeeded")
+	}
+	if _, err := simulator.AddCharger(sim.ChargerConfig{Serial: "fedcba9876543210", Password: "123456"}); err != nil {
+		t.Fatalf("AddCharger: %v", err)
+	}
+
+	seen := make(map[types.EmSerial]bool)
+	buf := make([]byte, 1024)
+	deadline := time.Now().Add(5 * time.Second)
+	for len(seen) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for logins, got %v", seen)
+		}
+		n, _, err := listener.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("ReadFrom: %v", err)
+		}
+		datagram, err := protocol.Decode(buf[:n])
+		if err != nil || datagram == nil {
+			t.Fatalf("invalid datagram: %v", err)
+		}
+		if datagram.Command == protocol.CmdLogin {
+			seen[datagram.Serial] = true
+		}
+	}
+}
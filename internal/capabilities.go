@@ -0,0 +1,105 @@
+package internal
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/johnwoo-nl/emproto4go/types"
+)
+
+// capabilitiesJson is the table that determines the capabilities of EVSEs. To support a new model or firmware, add a
+// rule to it rather than code.
+//
+//go:embed capabilities.json
+var capabilitiesJson []byte
+
+// capabilityRules are the rules from capabilitiesJson, in order.
+var capabilityRules = mustParseCapabilityRules(capabilitiesJson)
+
+// capabilityRule grants and/or revokes capabilities of EVSEs matching all of its conditions. Rules are applied in
+// order, so a later rule can revoke a capability granted by an earlier one, e.g. for a specific firmware.
+type capabilityRule struct {
+	Note   string               `json:"note"`
+	Match  profileCondition     `json:"match"`
+	Grant  types.EmCapabilities `json:"grant"`
+	Revoke types.EmCapabilities `json:"revoke"`
+}
+
+// profileCondition holds the conditions of a capability rule or compatibility database entry, which match an EVSE's
+// profile: its type, feature flags, firmware etc. Conditions that are not set match any EVSE.
+type profileCondition struct {
+	// EvseTypes matches EVSEs with one of these types.
+	EvseTypes []byte `json:"evseTypes"`
+	// Byte70Max matches EVSEs whose byte 70 of the login datagram is at most this value.
+	Byte70Max *byte `json:"byte70Max"`
+	// FeatureBits matches EVSEs that have all these bits set in their feature flags.
+	FeatureBits uint32 `json:"featureBits"`
+	// SupportNewBits matches EVSEs that have all these bits set in their new feature flags.
+	SupportNewBits uint32 `json:"supportNewBits"`
+	// Brands, Models and SoftwareVersions match EVSEs whose brand, model or software version starts with one of these.
+	Brands           []string `json:"brands"`
+	Models           []string `json:"models"`
+	SoftwareVersions []string `json:"softwareVersions"`
+	// NewProtocol matches EVSEs that do (true) or don't (false) send the longer status datagram.
+	NewProtocol *bool `json:"newProtocol"`
+}
+
+func mustParseCapabilityRules(data []byte) []capabilityRule {
+	var table struct {
+		Rules []capabilityRule `json:"rules"`
+	}
+	if err := json.Unmarshal(data, &table); err != nil {
+		panic(fmt.Sprintf("invalid capability table: %v", err))
+	}
+	return table.Rules
+}
+
+func (condition profileCondition) matches(info EvseInfo, newProtocol bool) bool {
+	if condition.EvseTypes != nil && !slices.Contains(condition.EvseTypes, info.EvseType_) {
+		return false
+	}
+	if condition.Byte70Max != nil && info.Byte70_ > *condition.Byte70Max {
+		return false
+	}
+	if info.Feature_&condition.FeatureBits != condition.FeatureBits {
+		return false
+	}
+	if info.SupportNew_&condition.SupportNewBits != condition.SupportNewBits {
+		return false
+	}
+	if !matchesPrefix(condition.Brands, info.Brand_) || !matchesPrefix(condition.Models, info.Model_) ||
+		!matchesPrefix(condition.SoftwareVersions, info.SoftwareVersion_) {
+		return false
+	}
+	if condition.NewProtocol != nil && *condition.NewProtocol != newProtocol {
+		return false
+	}
+	return true
+}
+
+// matchesPrefix returns true if there are no prefixes, or if value starts with one of them.
+func matchesPrefix(prefixes []string, value string) bool {
+	return prefixes == nil || slices.ContainsFunc(prefixes, func(prefix string) bool {
+		return strings.HasPrefix(value, prefix)
+	})
+}
+
+// CapabilitiesOf applies the capability rules to an EVSE's info and whether it uses the new protocol.
+func CapabilitiesOf(info EvseInfo, newProtocol bool) types.EmCapabilities {
+	capabilities := types.EmCapabilities(0)
+	for _, rule := range capabilityRules {
+		if rule.Match.matches(info, newProtocol) {
+			capabilities = capabilities&^rule.Revoke | rule.Grant
+		}
+	}
+	return capabilities
+}
+
+func (evse *Evse) Capabilities() types.EmCapabilities {
+	evse.mutex.RLock()
+	defer evse.mutex.RUnlock()
+	return CapabilitiesOf(*evse.info, evse.state.NewProtocol_)
+}
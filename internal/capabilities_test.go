@@ -0,0 +1,30 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/johnwoo-nl/emproto4go/types"
+)
+
+func TestCapabilitiesOf(t *testing.T) {
+	tests := []struct {
+		name        string
+		info        EvseInfo
+		newProtocol bool
+		expected    types.EmCapabilities
+	}{
+		{"single phase", EvseInfo{EvseType_: 1}, false, 0},
+		{"single phase, new protocol", EvseInfo{EvseType_: 1}, true, types.CapabilityNewProtocol},
+		{"three phase", EvseInfo{EvseType_: 10}, false, types.CapabilityThreePhase},
+		{"three phase, can force single phase", EvseInfo{EvseType_: 22, Byte70_: 10}, false,
+			types.CapabilityThreePhase | types.CapabilityForceSinglePhase},
+		{"three phase, byte 70 too high", EvseInfo{EvseType_: 22, Byte70_: 11}, false, types.CapabilityThreePhase},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if capabilities := CapabilitiesOf(test.info, test.newProtocol); capabilities != test.expected {
+				t.Errorf("capabilities are %v, expected %v", capabilities, test.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,232 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/johnwoo-nl/emproto4go/protocol"
+	"github.com/johnwoo-nl/emproto4go/types"
+)
+
+// Binary capture format: the header (magic + version), followed by one record per datagram:
+//
+//	int64  timestamp (Unix nanoseconds)
+//	byte   direction (0 = received, 1 = sent)
+//	byte   IP length (0, 4 or 16), followed by the IP
+//	uint16 port
+//	uint16 data length, followed by the data
+//
+// All integers are big-endian.
+var captureBinaryMagic = []byte("EMCAP\x01")
+
+const (
+	captureBinaryReceived byte = 0
+	captureBinarySent     byte = 1
+)
+
+// captureJsonLine is a datagram in the JSON lines format.
+type captureJsonLine struct {
+	Timestamp time.Time                `json:"ts"`
+	Direction types.EmCaptureDirection `json:"dir"`
+	Addr      string                   `json:"addr,omitempty"`
+	Data      string                   `json:"data"`
+}
+
+// CaptureRecorder is a types.EmRecorder that writes datagrams to a writer, in JSON lines or binary format. Each
+// datagram is written with a single Write call, so a buffered writer is not needed.
+type CaptureRecorder struct {
+	writer          io.Writer
+	format          types.EmCaptureFormat
+	redactPasswords bool
+
+	mutex         sync.Mutex
+	headerWritten bool
+}
+
+func CreateCaptureRecorder(writer io.Writer, format types.EmCaptureFormat, redactPasswords bool) (*CaptureRecorder, error) {
+	if format != types.CaptureJsonLines && format != types.CaptureBinary {
+		return nil, fmt.Errorf("unsupported capture format %q", format)
+	}
+	return &CaptureRecorder{writer: writer, format: format, redactPasswords: redactPasswords}, nil
+}
+
+func (recorder *CaptureRecorder) Record(datagram types.EmCapturedDatagram) error {
+	if recorder.redactPasswords {
+		datagram.Data = protocol.RedactPassword(datagram.Data)
+	}
+
+	var record []byte
+	var err error
+	if recorder.format == types.CaptureBinary {
+		record, err = encodeCaptureBinary(datagram)
+	} else {
+		record, err = encodeCaptureJsonLine(datagram)
+	}
+	if err != nil {
+		return err
+	}
+
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+
+	if recorder.format == types.CaptureBinary && !recorder.headerWritten {
+		record = append(bytes.Clone(captureBinaryMagic), record...)
+	}
+	if _, err := recorder.writer.Write(record); err != nil {
+		return err
+	}
+	recorder.headerWritten = true
+	return nil
+}
+
+func encodeCaptureJsonLine(datagram types.EmCapturedDatagram) ([]byte, error) {
+	line := captureJsonLine{
+		Timestamp: datagram.Timestamp,
+		Direction: datagram.Direction,
+		Data:      hex.EncodeToString(datagram.Data),
+	}
+	if datagram.Addr != nil {
+		line.Addr = datagram.Addr.String()
+	}
+	data, err := json.Marshal(line)
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+func encodeCaptureBinary(datagram types.EmCapturedDatagram) ([]byte, error) {
+	if len(datagram.Data) > 0xffff {
+		return nil, fmt.Errorf("datagram too large to capture (%d bytes)", len(datagram.Data))
+	}
+	var ip net.IP
+	port := 0
+	if datagram.Addr != nil {
+		ip, port = datagram.Addr.IP, datagram.Addr.Port
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+	}
+
+	record := make([]byte, 0, 14+len(ip)+len(datagram.Data))
+	record = binary.BigEndian.AppendUint64(record, uint64(datagram.Timestamp.UnixNano()))
+	if datagram.Direction == types.CaptureSent {
+		record = append(record, captureBinarySent)
+	} else {
+		record = append(record, captureBinaryReceived)
+	}
+	record = append(record, byte(len(ip)))
+	record = append(record, ip...)
+	record = binary.BigEndian.AppendUint16(record, uint16(port))
+	record = binary.BigEndian.AppendUint16(record, uint16(len(datagram.Data)))
+	record = append(record, datagram.Data...)
+	return record, nil
+}
+
+// CaptureReader is a types.EmCaptureReader for captures written by CaptureRecorder. The format is detected from the
+// first bytes of the capture.
+type CaptureReader struct {
+	reader *bufio.Reader
+	format types.EmCaptureFormat
+	line   int
+}
+
+func CreateCaptureReader(reader io.Reader) (*CaptureReader, error) {
+	captureReader := &CaptureReader{reader: bufio.NewReader(reader), format: types.CaptureJsonLines}
+	header, err := captureReader.reader.Peek(len(captureBinaryMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if bytes.Equal(header, captureBinaryMagic) {
+		captureReader.format = types.CaptureBinary
+		_, _ = captureReader.reader.Discard(len(captureBinaryMagic))
+	}
+	return captureReader, nil
+}
+
+// Format returns the detected format of the capture.
+func (reader *CaptureReader) Format() types.EmCaptureFormat {
+	return reader.format
+}
+
+func (reader *CaptureReader) Next() (types.EmCapturedDatagram, error) {
+	if reader.format == types.CaptureBinary {
+		return reader.nextBinary()
+	}
+	return reader.nextJsonLine()
+}
+
+func (reader *CaptureReader) nextJsonLine() (types.EmCapturedDatagram, error) {
+	for {
+		data, err := reader.reader.ReadBytes('\n')
+		if len(data) == 0 && err != nil {
+			return types.EmCapturedDatagram{}, err
+		}
+		reader.line++
+		data = bytes.TrimSpace(data)
+		if len(data) == 0 {
+			continue
+		}
+
+		var line captureJsonLine
+		if err := json.Unmarshal(data, &line); err != nil {
+			return types.EmCapturedDatagram{}, fmt.Errorf("invalid capture line %d: %w", reader.line, err)
+		}
+		datagram := types.EmCapturedDatagram{Timestamp: line.Timestamp, Direction: line.Direction}
+		if datagram.Data, err = hex.DecodeString(line.Data); err != nil {
+			return types.EmCapturedDatagram{}, fmt.Errorf("invalid data in capture line %d: %w", reader.line, err)
+		}
+		if line.Addr != "" {
+			if datagram.Addr, err = net.ResolveUDPAddr("udp", line.Addr); err != nil {
+				return types.EmCapturedDatagram{}, fmt.Errorf("invalid address in capture line %d: %w", reader.line, err)
+			}
+		}
+		return datagram, nil
+	}
+}
+
+func (reader *CaptureReader) nextBinary() (types.EmCapturedDatagram, error) {
+	var head [10]byte
+	if _, err := io.ReadFull(reader.reader, head[:]); err != nil {
+		// A clean end of the capture is io.EOF; a truncated record is io.ErrUnexpectedEOF.
+		return types.EmCapturedDatagram{}, err
+	}
+	datagram := types.EmCapturedDatagram{
+		Timestamp: time.Unix(0, int64(binary.BigEndian.Uint64(head[0:8]))),
+		Direction: types.CaptureReceived,
+	}
+	if head[8] == captureBinarySent {
+		datagram.Direction = types.CaptureSent
+	}
+
+	rest := make([]byte, int(head[9])+4)
+	if _, err := io.ReadFull(reader.reader, rest); err != nil {
+		return types.EmCapturedDatagram{}, truncatedCapture(err)
+	}
+	ipLen := int(head[9])
+	port := int(binary.BigEndian.Uint16(rest[ipLen : ipLen+2]))
+	if ipLen > 0 {
+		datagram.Addr = &net.UDPAddr{IP: net.IP(rest[:ipLen]), Port: port}
+	}
+
+	datagram.Data = make([]byte, binary.BigEndian.Uint16(rest[ipLen+2:ipLen+4]))
+	if _, err := io.ReadFull(reader.reader, datagram.Data); err != nil {
+		return types.EmCapturedDatagram{}, truncatedCapture(err)
+	}
+	return datagram, nil
+}
+
+func truncatedCapture(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
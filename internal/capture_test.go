@@ -0,0 +1,170 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/johnwoo-nl/emproto4go/protocol"
+	"github.com/johnwoo-nl/emproto4go/types"
+)
+
+func testCaptureDatagrams(t *testing.T) []types.EmCapturedDatagram {
+	login, err := (&protocol.Datagram{Key: 1, Serial: "0123456789abcdef", Password: "135790", Command: protocol.CmdLogin,
+		Payload: protocol.LoginPayload{EvseType: 1, Brand: "Besen", Model: "BS20", Length: 54}.Encode()}).Encode()
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	timestamp := time.Date(2026, 3, 1, 12, 0, 0, 123456789, time.UTC)
+	return []types.EmCapturedDatagram{
+		{Timestamp: timestamp, Direction: types.CaptureReceived, Data: login,
+			Addr: &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2).To4(), Port: 28376}},
+		{Timestamp: timestamp.Add(time.Second), Direction: types.CaptureSent, Data: []byte{1, 2, 3},
+			Addr: &net.UDPAddr{IP: net.ParseIP("fe80::1"), Port: 28376}},
+		{Timestamp: timestamp.Add(2 * time.Second), Direction: types.CaptureReceived, Data: []byte{}},
+	}
+}
+
+// TestCaptureRoundTrip records datagrams in each format and checks that reading them back yields the same datagrams.
+func TestCaptureRoundTrip(t *testing.T) {
+	for _, format := range []types.EmCaptureFormat{types.CaptureJsonLines, types.CaptureBinary} {
+		t.Run(string(format), func(t *testing.T) {
+			datagrams := testCaptureDatagrams(t)
+			capture := &bytes.Buffer{}
+			recorder, err := CreateCaptureRecorder(capture, format, false)
+			if err != nil {
+				t.Fatalf("CreateCaptureRecorder: %v", err)
+			}
+			for _, datagram := range datagrams {
+				if err := recorder.Record(datagram); err != nil {
+					t.Fatalf("Record: %v", err)
+				}
+			}
+
+			reader, err := CreateCaptureReader(capture)
+			if err != nil {
+				t.Fatalf("CreateCaptureReader: %v", err)
+			}
+			if reader.Format() != format {
+				t.Errorf("format detected as %q, expected %q", reader.Format(), format)
+			}
+			for i, expected := range datagrams {
+				datagram, err := reader.Next()
+				if err != nil {
+					t.Fatalf("Next %d: %v", i, err)
+				}
+				if !datagram.Timestamp.Equal(expected.Timestamp) || datagram.Direction != expected.Direction ||
+					!bytes.Equal(datagram.Data, expected.Data) || addrString(datagram.Addr) != addrString(expected.Addr) {
+					t.Errorf("datagram %d is %+v, expected %+v", i, datagram, expected)
+				}
+			}
+			if _, err := reader.Next(); err != io.EOF {
+				t.Errorf("Next at the end returned %v, expected io.EOF", err)
+			}
+		})
+	}
+}
+
+// TestCaptureRedactsPasswords checks that a redacted capture contains neither the login password nor the new password
+// of a password change, in any format.
+func TestCaptureRedactsPasswords(t *testing.T) {
+	setPassword, err := (&protocol.Datagram{Key: 1, Serial: "0123456789abcdef", Password: "135790",
+		Command: protocol.CmdSetPassword,
+		Payload: protocol.PasswordPayload{Action: protocol.ConfigSet, Password: "246801"}.Encode()}).Encode()
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	datagrams := append(testCaptureDatagrams(t), types.EmCapturedDatagram{Timestamp: time.Now(),
+		Direction: types.CaptureSent, Data: setPassword})
+
+	for _, format := range []types.EmCaptureFormat{types.CaptureJsonLines, types.CaptureBinary} {
+		t.Run(string(format), func(t *testing.T) {
+			capture := &bytes.Buffer{}
+			recorder, err := CreateCaptureRecorder(capture, format, true)
+			if err != nil {
+				t.Fatalf("CreateCaptureRecorder: %v", err)
+			}
+			for _, datagram := range datagrams {
+				if err := recorder.Record(datagram); err != nil {
+					t.Fatalf("Record: %v", err)
+				}
+			}
+			for _, password := range []string{"135790", "246801"} {
+				if bytes.Contains(capture.Bytes(), []byte(password)) ||
+					bytes.Contains(capture.Bytes(), []byte(hex.EncodeToString([]byte(password)))) {
+					t.Errorf("redacted capture contains password %s", password)
+				}
+			}
+
+			// The redacted datagrams still decode, so the checksums were updated.
+			reader, err := CreateCaptureReader(capture)
+			if err != nil {
+				t.Fatalf("CreateCaptureReader: %v", err)
+			}
+			for {
+				datagram, err := reader.Next()
+				if err == io.EOF {
+					break
+				} else if err != nil {
+					t.Fatalf("Next: %v", err)
+				}
+				if _, err := protocol.Decode(datagram.Data); err != nil {
+					t.Errorf("redacted datagram does not decode: %v", err)
+				}
+			}
+		})
+	}
+}
+
+// TestCaptureTruncated checks that a binary capture cut off in the middle of a record reports io.ErrUnexpectedEOF
+// rather than a clean end of the capture.
+func TestCaptureTruncated(t *testing.T) {
+	capture := &bytes.Buffer{}
+	recorder, err := CreateCaptureRecorder(capture, types.CaptureBinary, false)
+	if err != nil {
+		t.Fatalf("CreateCaptureRecorder: %v", err)
+	}
+	datagram := testCaptureDatagrams(t)[0]
+	if err := recorder.Record(datagram); err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+
+	data := capture.Bytes()
+	for length := len(captureBinaryMagic) + 1; length < len(data); length++ {
+		reader, err := CreateCaptureReader(bytes.NewReader(data[:length]))
+		if err != nil {
+			t.Fatalf("CreateCaptureReader: %v", err)
+		}
+		if _, err := reader.Next(); !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("capture truncated to %d bytes returned %v, expected io.ErrUnexpectedEOF", length, err)
+		}
+	}
+
+	// A JSON lines capture cut off in the middle of a line is invalid.
+	capture.Reset()
+	recorder, err = CreateCaptureRecorder(capture, types.CaptureJsonLines, false)
+	if err != nil {
+		t.Fatalf("CreateCaptureRecorder: %v", err)
+	}
+	if err := recorder.Record(datagram); err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+	reader, err := CreateCaptureReader(bytes.NewReader(capture.Bytes()[:capture.Len()/2]))
+	if err != nil {
+		t.Fatalf("CreateCaptureReader: %v", err)
+	}
+	if _, err := reader.Next(); err == nil || err == io.EOF {
+		t.Errorf("truncated line returned %v, expected an error", err)
+	}
+}
+
+func addrString(addr *net.UDPAddr) string {
+	if addr == nil {
+		return ""
+	}
+	return addr.String()
+}
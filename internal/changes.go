@@ -0,0 +1,52 @@
+package internal
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/johnwoo-nl/emproto4go/types"
+)
+
+// diffFields compares two values of the same data struct (EvseInfo, EvseState etc.) and returns the changes of their
+// data fields, i.e. the exported fields with a trailing underscore. Changes are named after the field's getter, which
+// is the field name without the underscore unless a `getter` tag says otherwise.
+func diffFields[T any](before *T, after *T) []types.EmFieldChange {
+	beforeValue := reflect.ValueOf(before).Elem()
+	afterValue := reflect.ValueOf(after).Elem()
+	structType := beforeValue.Type()
+
+	var changes []types.EmFieldChange
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		if !field.IsExported() || !strings.HasSuffix(field.Name, "_") {
+			continue
+		}
+		oldValue := beforeValue.Field(i).Interface()
+		newValue := afterValue.Field(i).Interface()
+		if reflect.DeepEqual(oldValue, newValue) {
+			continue
+		}
+		name := field.Tag.Get("getter")
+		if name == "" {
+			name = strings.TrimSuffix(field.Name, "_")
+		}
+		changes = append(changes, types.EmFieldChange{Field: name, Old: oldValue, New: newValue})
+	}
+	return changes
+}
+
+// mergeChanges merges the changes of a newer event into those of an older one (for debouncing). Each field keeps its
+// oldest Old and newest New value; fields that ended up at their old value are dropped.
+func mergeChanges(older []types.EmFieldChange, newer []types.EmFieldChange) []types.EmFieldChange {
+	merged := slices.Clone(older)
+	for _, change := range newer {
+		i := slices.IndexFunc(merged, func(c types.EmFieldChange) bool { return c.Field == change.Field })
+		if i < 0 {
+			merged = append(merged, change)
+		} else {
+			merged[i].New = change.New
+		}
+	}
+	return slices.DeleteFunc(merged, func(c types.EmFieldChange) bool { return reflect.DeepEqual(c.Old, c.New) })
+}
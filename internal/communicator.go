@@ -1,49 +1,94 @@
 package internal
 
 import (
+	"cmp"
+	"errors"
 	"fmt"
+	"maps"
 	"net"
+	"slices"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/sirupsen/logrus"
 
+	"github.com/johnwoo-nl/emproto4go/protocol"
 	"github.com/johnwoo-nl/emproto4go/types"
 )
 
 type Communicator struct {
 	AppName_ types.UserId
 	Logger_  *logrus.Logger
-	started  bool
+	options  CommunicatorOptions
+
+	// started and done are guarded by lifecycleMutex. done is closed when the communicator is stopped.
+	started        bool
+	done           chan struct{}
+	lifecycleMutex sync.RWMutex
 
 	evses      map[types.EmSerial]*Evse
 	evsesMutex sync.RWMutex
 
-	udpConn      *net.UDPConn
-	udpConnMutex sync.Mutex
+	transport types.EmTransport
 
-	watchers      []EventWatcher
+	// Watchers by ID. IDs increase, so dispatching in ID order notifies watchers in the order they were added.
+	watchers      map[uint64]*EventWatcher
+	nextWatcherId uint64
 	watchersMutex sync.RWMutex
 
-	debouncedEvents      map[string]types.EmEvent
+	// Serializes building and saving records to options.Store, and guards savedRecords: the record last saved per
+	// EVSE, so unchanged records aren't saved again.
+	storeMutex   sync.Mutex
+	savedRecords map[types.EmSerial]types.EmEvseRecord
+	// Timers of delayed saves (see persistEvseLater) by EVSE.
+	pendingSaves      map[types.EmSerial]*time.Timer
+	pendingSavesMutex sync.Mutex
+
+	// Handlers for received datagrams.
+	handlers HandlerRegistry
+
+	// Unknown commands and values received from EVSEs.
+	diagnostics Diagnostics
+
+	debouncedEvents      map[string]*debouncedEvent
 	debouncedEventsMutex sync.Mutex
 	debounceTimers       map[string]*time.Timer
 	debounceTimersMutex  sync.Mutex
+}
 
-	tickerStopChan chan struct{}
-
-	tickerRunning bool
+// debouncedEvent is an event waiting for its debounce window to pass, with the time its first occurrence was queued.
+type debouncedEvent struct {
+	event       types.EmEvent
+	firstQueued time.Time
 }
 
-func CreateCommunicator(appName types.UserId) *Communicator {
-	return &Communicator{
+// closedChan is returned by Done when the communicator is not started.
+var closedChan = func() chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}()
+
+func CreateCommunicator(appName types.UserId, options CommunicatorOptions) *Communicator {
+	options, replaced := options.withValidDurations()
+	communicator := &Communicator{
 		AppName_:        appName,
+		options:         options,
+		transport:       options.transport(),
 		Logger_:         logrus.New(), // Default on InfoLevel.
 		evses:           make(map[types.EmSerial]*Evse),
-		debouncedEvents: make(map[string]types.EmEvent),
+		watchers:        make(map[uint64]*EventWatcher),
+		debouncedEvents: make(map[string]*debouncedEvent),
 		debounceTimers:  make(map[string]*time.Timer),
+		savedRecords:    make(map[types.EmSerial]types.EmEvseRecord),
+		pendingSaves:    make(map[types.EmSerial]*time.Timer),
 	}
+	communicator.diagnostics.Reset()
+	for _, name := range replaced {
+		communicator.Logger_.Warnf("[emproto4go] Invalid %s option, using the default.", name)
+	}
+	return communicator
 }
 
 func (communicator *Communicator) AppName() types.UserId {
@@ -59,7 +104,7 @@ func (communicator *Communicator) SetLogger(delegate func(string, string)) {
 }
 
 func (communicator *Communicator) GetEvse(serial types.EmSerial) types.EmEvse {
-	return communicator.getEvseImpl(serial)
+	return communicator.getEvseImpl(serial.Normalized())
 }
 
 func (communicator *Communicator) getEvseImpl(serial types.EmSerial) *Evse {
@@ -81,7 +126,7 @@ func (communicator *Communicator) GetEvses() []types.EmEvse {
 }
 
 func (communicator *Communicator) DefineEvse(serial types.EmSerial) types.EmEvse {
-	return communicator.defineEvseImpl(serial)
+	return communicator.defineEvseImpl(serial.Normalized())
 }
 
 func (communicator *Communicator) defineEvseImpl(serial types.EmSerial) *Evse {
@@ -90,23 +135,39 @@ func (communicator *Communicator) defineEvseImpl(serial types.EmSerial) *Evse {
 		return existing
 	}
 
-	evse := Evse{
+	evse := communicator.newEvse(serial)
+	communicator.lookupPassword(evse)
+	return communicator.addEvse(evse)
+}
+
+// newEvse creates an EVSE instance, without adding it to the communicator.
+func (communicator *Communicator) newEvse(serial types.EmSerial) *Evse {
+	evse := &Evse{
 		communicator: communicator,
 		info:         &EvseInfo{serial: serial},
 		state:        &EvseState{},
 		charge:       &EvseCharge{},
 		config:       &EvseConfig{},
 	}
-	evse.info.evse = &evse
-	evse.charge.evse = &evse
-	evse.config.evse = &evse
+	evse.info.evse = evse
+	evse.charge.evse = evse
+	evse.config.evse = evse
+	return evse
+}
 
+// addEvse adds the EVSE to the communicator and queues an EvseAdded event. If the communicator already has an EVSE
+// with the same serial (e.g. defined by another goroutine meanwhile), that one is returned instead.
+func (communicator *Communicator) addEvse(evse *Evse) *Evse {
 	communicator.evsesMutex.Lock()
-	defer communicator.evsesMutex.Unlock()
+	if existing, ok := communicator.evses[evse.Serial()]; ok {
+		communicator.evsesMutex.Unlock()
+		return existing
+	}
+	communicator.evses[evse.Serial()] = evse
+	communicator.evsesMutex.Unlock()
 
-	communicator.evses[serial] = &evse
-	communicator.QueueEvent(&evse, types.EvseAdded)
-	return &evse
+	communicator.QueueEvent(evse, types.EvseAdded)
+	return evse
 }
 
 func (communicator *Communicator) RemoveEvse(evse types.EmEvse) error {
@@ -116,13 +177,13 @@ func (communicator *Communicator) RemoveEvse(evse types.EmEvse) error {
 	}
 
 	communicator.evsesMutex.Lock()
-	defer communicator.evsesMutex.Unlock()
+	delete(communicator.evses, evse.Serial())
+	communicator.evsesMutex.Unlock()
+	communicator.unpersistEvse(evse.Serial())
 
 	// Clear any queued events for this EVSE and add one single EvseRemoved event.
 	communicator.clearQueuedEvents(evse)
 	communicator.QueueEvent(evse.(*Evse), types.EvseRemoved)
-
-	delete(communicator.evses, evse.Serial())
 	return nil
 }
 
@@ -140,134 +201,160 @@ func (communicator *Communicator) Tick() {
 }
 
 func (communicator *Communicator) Start() error {
+	communicator.lifecycleMutex.Lock()
+	defer communicator.lifecycleMutex.Unlock()
+
 	if communicator.started {
 		return fmt.Errorf("communicator already started")
 	}
-
-	addr := net.UDPAddr{
-		Port: 28376,
-		IP:   net.ParseIP("0.0.0.0"),
-	}
-	conn, err := net.ListenUDP("udp", &addr)
-	if err != nil {
+	communicator.restoreEvses()
+	if err := communicator.listen(); err != nil {
 		return err
 	}
-	communicator.udpConnMutex.Lock()
-	communicator.udpConn = conn
 	communicator.started = true
-	communicator.udpConnMutex.Unlock()
+	communicator.done = make(chan struct{})
+	go communicator.tickLoop(communicator.done)
 
-	communicator.Logger_.Infof("[emproto4go] Communicator started, UDP listener on port %v", addr.Port)
+	communicator.Logger_.Infof("[emproto4go] Communicator started, listening on %v", communicator.transport.LocalAddr())
+	return nil
+}
 
-	// Start ticker goroutine if not already running
-	if !communicator.tickerRunning {
-		communicator.tickerStopChan = make(chan struct{})
-		communicator.tickerRunning = true
-		go func() {
-			ticker := time.NewTicker(5 * time.Second)
-			defer ticker.Stop()
-			for {
-				select {
-				case <-communicator.tickerStopChan:
-					return
-				case <-ticker.C:
-					if communicator.started {
-						communicator.Tick()
-					}
-				}
-			}
-		}()
+// Done returns a channel that is closed when the communicator is stopped. If the communicator is not started, the
+// returned channel is already closed.
+func (communicator *Communicator) Done() <-chan struct{} {
+	communicator.lifecycleMutex.RLock()
+	defer communicator.lifecycleMutex.RUnlock()
+
+	if !communicator.started {
+		return closedChan
 	}
+	return communicator.done
+}
 
-	// UDP receiver loop.
-	go func() {
-		defer communicator.stopped()
-		buf := make([]byte, 4096)
-		for {
-			if !communicator.started {
-				return
-			}
-			n, remoteAddr, err := conn.ReadFrom(buf)
-			if n > 0 {
-				communicator.packetReceived(buf[:n], &remoteAddr)
-			} else if err != nil {
-				if !strings.Contains(err.Error(), "use of closed network connection") {
-					communicator.Logger_.Warnf("[emproto4go] UDP read error: %v", err)
-				}
-				return
+func (communicator *Communicator) isStarted() bool {
+	communicator.lifecycleMutex.RLock()
+	defer communicator.lifecycleMutex.RUnlock()
+
+	return communicator.started
+}
+
+// listen opens the transport and starts the receiver loop.
+func (communicator *Communicator) listen() error {
+	transport := communicator.transport
+	if err := transport.Open(); err != nil {
+		return err
+	}
+	go communicator.receiveLoop(transport)
+	return nil
+}
+
+func (communicator *Communicator) receiveLoop(transport types.EmTransport) {
+	defer communicator.receiveLoopStopped()
+
+	buf := make([]byte, 4096)
+	for {
+		n, remoteAddr, err := transport.ReadFrom(buf)
+		if n > 0 {
+			// Copy the data, since buf is reused while datagram payloads may still be read by waiters.
+			data := make([]byte, n)
+			copy(data, buf[:n])
+			communicator.packetReceived(data, &remoteAddr)
+		} else if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				communicator.Logger_.Warnf("[emproto4go] Read error: %v", err)
 			}
+			return
 		}
-	}()
+	}
+}
 
-	return nil
+func (communicator *Communicator) tickLoop(done <-chan struct{}) {
+	ticker := time.NewTicker(communicator.options.TickInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			communicator.Tick()
+		}
+	}
 }
 
 func (communicator *Communicator) Stop() {
+	communicator.lifecycleMutex.Lock()
 	if !communicator.started {
+		communicator.lifecycleMutex.Unlock()
 		return
 	}
-	communicator.udpConnMutex.Lock()
 	communicator.started = false
-	communicator.udpConnMutex.Unlock()
+	close(communicator.done)
+	_ = communicator.transport.Close()
+	communicator.lifecycleMutex.Unlock()
 
-	// Stop ticker goroutine
-	if communicator.tickerRunning && communicator.tickerStopChan != nil {
-		close(communicator.tickerStopChan)
-		communicator.tickerRunning = false
-	}
-	communicator.stopped()
-}
+	communicator.Logger_.Info("[emproto4go] Communicator stopped")
 
-func (communicator *Communicator) stopped() {
-	communicator.udpConnMutex.Lock()
-	if communicator.udpConn != nil {
-		_ = communicator.udpConn.Close()
-		communicator.udpConn = nil
+	// Send OFFLINE events for all EVSEs that were online, and clear the last seen timestamps so we can get ONLINE
+	// events again if we'd be started again quickly.
+	communicator.evsesMutex.RLock()
+	for _, evse := range communicator.evses {
+		communicator.clearQueuedEvents(evse)
+		wasLoggedIn, wasOnline := evse.clearPresence()
+		if wasLoggedIn {
+			communicator.QueueEvent(evse, types.EvseLoggedOut)
+		}
+		if wasOnline {
+			communicator.QueueEvent(evse, types.EvseOffline)
+		}
 	}
-	communicator.udpConnMutex.Unlock()
+	communicator.evsesMutex.RUnlock()
 
-	if communicator.started {
-		communicator.Logger_.Debug("[emproto4go] Communicator stopped unexpectedly; will restart")
+	// Deliver those events right away (instead of after the debounce window) and save what is still pending, then
+	// close all watchers' channels.
+	communicator.flushQueuedEvents()
+	communicator.flushPendingSaves()
+	for _, watcher := range communicator.currentWatchers() {
+		watcher.Stop()
+	}
+}
 
-		// If it was stopped unexpectedly, try to restart after a delay. Don't send OFFLINE events, because we may
-		// restart quickly (time-based OFFLINE events could anyway be sent if restart takes too long).
-		communicator.restart()
-	} else {
-		communicator.Logger_.Info("[emproto4go] Communicator stopped")
-		// If we were actually asked to stop, then send OFFLINE events for all EVSEs that were online, and clear
-		// the LastSeen timestamps so we can get ONLINE events again if we'd be started again quickly.
-		communicator.evsesMutex.RLock()
-		for _, evse := range communicator.evses {
-			communicator.clearQueuedEvents(evse)
-			if evse.IsLoggedIn() {
-				communicator.QueueEvent(evse, types.EvseLoggedOut)
-				evse.LastActiveLogin = nil
-			}
-			if evse.IsOnline() {
-				communicator.QueueEvent(evse, types.EvseOffline)
-				evse.LastSeen = nil
-			}
-		}
+// receiveLoopStopped is called when the receiver loop exits. If the communicator is still started, the transport
+// failed unexpectedly and will be reopened.
+func (communicator *Communicator) receiveLoopStopped() {
+	if !communicator.isStarted() {
+		return
 	}
+	communicator.Logger_.Debug("[emproto4go] Communicator stopped unexpectedly; will restart")
+	_ = communicator.transport.Close()
+
+	// Don't send OFFLINE events, because we may restart quickly (time-based OFFLINE events could anyway be sent if
+	// restart takes too long).
+	communicator.restart()
 }
 
 func (communicator *Communicator) restart() {
-	if !communicator.started {
+	done := communicator.Done()
+	if !communicator.isStarted() {
 		return
 	}
-	err := communicator.Start()
+	err := communicator.listen()
 	if err != nil {
 		communicator.Logger_.Warnf("[emproto4go] Failed to restart communicator (will retry): %v", err)
 		go func() {
-			time.Sleep(10 * time.Second)
-			communicator.restart()
+			timer := time.NewTimer(10 * time.Second)
+			defer timer.Stop()
+			select {
+			case <-done:
+			case <-timer.C:
+				communicator.restart()
+			}
 		}()
-		return
 	}
 }
 
 func (communicator *Communicator) packetReceived(data []byte, addr *net.Addr) {
-	datagram, err := Decode(data)
+	communicator.recordDatagram(types.CaptureReceived, data, *addr)
+	datagram, err := protocol.Decode(data)
 
 	if err != nil {
 		communicator.Logger_.Warnf("[emproto4go] Failed to parse datagram from %v: %v\nDatagram bytes: %v", addr, err, data)
@@ -283,36 +370,34 @@ func (communicator *Communicator) packetReceived(data []byte, addr *net.Addr) {
 	evse.DatagramReceived(datagram, addr)
 }
 
-func (communicator *Communicator) SendDatagram(evse *Evse, datagram *Datagram) error {
+func (communicator *Communicator) SendDatagram(evse *Evse, datagram *protocol.Datagram) error {
 	if !evse.IsOnline() {
 		return types.EvseOfflineError{Evse: evse}
 	}
-	if !evse.IsLoggedIn() && datagram.Command != CmdRequestLogin && datagram.Command != CmdLoginConfirm {
+	if !evse.IsLoggedIn() && datagram.Command != protocol.CmdRequestLogin && datagram.Command != protocol.CmdLoginConfirm {
 		return types.EvseNotLoggedInError{Evse: evse}
 	}
 
 	datagram.Serial = evse.Serial()
-	if (datagram.Password == "") && (evse.password != "") {
-		datagram.Password = evse.password
+	if datagram.Password == "" {
+		datagram.Password = evse.currentPassword()
 	}
 
 	data, err := datagram.Encode()
 	if err != nil {
 		return err
 	}
-	communicator.udpConnMutex.Lock()
-	defer communicator.udpConnMutex.Unlock()
-
-	if communicator.udpConn == nil {
+	if communicator.transport.LocalAddr() == nil {
 		return types.EvseOfflineError{Evse: evse}
 	}
 	addr := &net.UDPAddr{IP: evse.IP(), Port: evse.Port()}
 	communicator.Logger_.Tracef("[emproto4go] -> SEND %+v to %s", datagram, addr)
 
-	n, err := communicator.udpConn.WriteTo(data, addr)
+	n, err := communicator.transport.WriteTo(data, addr)
 	if err != nil {
 		return err
 	}
+	communicator.recordDatagram(types.CaptureSent, data[:n], addr)
 	if n != len(data) {
 		return DatagramSendError{
 			Evse:    evse,
@@ -323,62 +408,97 @@ func (communicator *Communicator) SendDatagram(evse *Evse, datagram *Datagram) e
 	return nil
 }
 
+// recordDatagram passes a received or sent datagram to the recorder, if there is one.
+func (communicator *Communicator) recordDatagram(direction types.EmCaptureDirection, data []byte, addr net.Addr) {
+	recorder := communicator.options.Recorder
+	if recorder == nil {
+		return
+	}
+	datagram := types.EmCapturedDatagram{Timestamp: time.Now(), Direction: direction, Data: data}
+	if udpAddr, ok := addr.(*net.UDPAddr); ok {
+		datagram.Addr = udpAddr
+	} else if addr != nil {
+		datagram.Addr, _ = net.ResolveUDPAddr("udp", addr.String())
+	}
+	if err := recorder.Record(datagram); err != nil {
+		communicator.Logger_.Warnf("[emproto4go] Failed to record %s datagram: %v", direction, err)
+	}
+}
+
 func (communicator *Communicator) Watch(evse types.EmEvse, eventTypes []types.EmEventType, channel chan<- types.EmEvent) types.EmEventWatcher {
+	return communicator.WatchWithOptions(evse, eventTypes, channel, types.EmWatchOptions{})
+}
+
+func (communicator *Communicator) WatchWithOptions(evse types.EmEvse, eventTypes []types.EmEventType, channel chan<- types.EmEvent, options types.EmWatchOptions) types.EmEventWatcher {
 	if evse == nil {
-		return communicator.WatchImpl(nil, eventTypes, channel)
+		return communicator.WatchImpl(nil, eventTypes, channel, options)
 	} else {
 		evseInstance := communicator.getEvseImpl(evse.Serial())
-		return communicator.WatchImpl(evseInstance, eventTypes, channel)
+		return communicator.WatchImpl(evseInstance, eventTypes, channel, options)
 	}
 }
 
-func (communicator *Communicator) WatchImpl(evse *Evse, eventTypes []types.EmEventType, channel chan<- types.EmEvent) *EventWatcher {
-	watcher := &EventWatcher{
-		communicator: communicator,
-		evse:         evse,
-		eventTypes:   eventTypes,
-		channel:      channel,
-	}
+func (communicator *Communicator) WatchImpl(evse *Evse, eventTypes []types.EmEventType, channel chan<- types.EmEvent, options types.EmWatchOptions) *EventWatcher {
 	communicator.watchersMutex.Lock()
 	defer communicator.watchersMutex.Unlock()
-	communicator.watchers = append(communicator.watchers, *watcher)
+
+	communicator.nextWatcherId++
+	watcher := createEventWatcher(communicator, communicator.nextWatcherId, evse, eventTypes, channel, options)
+	communicator.watchers[watcher.id] = watcher
 	return watcher
 }
 
+// RemoveWatcher removes the watcher from the communicator, so it won't be notified anymore. Called by
+// EventWatcher.Stop(); use that to stop a watcher.
 func (communicator *Communicator) RemoveWatcher(watcher *EventWatcher) {
 	communicator.watchersMutex.Lock()
 	defer communicator.watchersMutex.Unlock()
 
-	for i, w := range communicator.watchers {
-		if &w == watcher {
-			// Remove watcher from slice
-			communicator.watchers = append(communicator.watchers[:i], communicator.watchers[i+1:]...)
-			return
-		}
-	}
+	delete(communicator.watchers, watcher.id)
 }
 
-func (communicator *Communicator) QueueEvent(evse *Evse, eventType types.EmEventType) {
+// currentWatchers returns the registered watchers in the order they were added.
+func (communicator *Communicator) currentWatchers() []*EventWatcher {
+	communicator.watchersMutex.RLock()
+	defer communicator.watchersMutex.RUnlock()
+
+	watchers := slices.Collect(maps.Values(communicator.watchers))
+	slices.SortFunc(watchers, func(a, b *EventWatcher) int { return cmp.Compare(a.id, b.id) })
+	return watchers
+}
+
+// QueueEvent queues an event for the EVSE, with the given field changes (for update events). Events of the same type
+// for the same EVSE are debounced into a single event, merging their changes.
+func (communicator *Communicator) QueueEvent(evse *Evse, eventType types.EmEventType, changes ...types.EmFieldChange) {
 	if evse == nil {
 		return
 	}
 
 	key := string(evse.Serial()) + ":" + string(eventType)
-	eventInstance := types.EmEvent{Evse: evse, Type: eventType, Timestamp: time.Now()}
+	now := time.Now()
+	eventInstance := types.EmEvent{Evse: evse, Type: eventType, Timestamp: now, Changes: changes}
+
+	if communicator.options.DebounceWindow <= 0 {
+		communicator.dispatchEvent(eventInstance)
+		communicator.queueImpliedEvents(evse, eventType)
+		return
+	}
 
 	communicator.debouncedEventsMutex.Lock()
-	communicator.debouncedEvents[key] = eventInstance
+	firstQueued := now
+	if pending, exists := communicator.debouncedEvents[key]; exists {
+		eventInstance.Changes = mergeChanges(pending.event.Changes, changes)
+		firstQueued = pending.firstQueued
+	}
+	communicator.debouncedEvents[key] = &debouncedEvent{event: eventInstance, firstQueued: firstQueued}
 	communicator.debouncedEventsMutex.Unlock()
 
 	communicator.debounceTimersMutex.Lock()
 	timer, exists := communicator.debounceTimers[key]
 	if exists {
-		// If timer exists, check if eventType has been in queue for >2000ms
-		communicator.debouncedEventsMutex.Lock()
-		firstQueued := communicator.debouncedEvents[key].Timestamp
-		communicator.debouncedEventsMutex.Unlock()
+		// If timer exists, check if eventType has been in queue for longer than the max delay.
 		elapsed := time.Since(firstQueued)
-		if elapsed >= 2000*time.Millisecond {
+		if elapsed >= communicator.options.DebounceMaxDelay {
 			timer.Stop()
 			delete(communicator.debounceTimers, key)
 			communicator.debounceTimersMutex.Unlock()
@@ -388,17 +508,29 @@ func (communicator *Communicator) QueueEvent(evse *Evse, eventType types.EmEvent
 			timer.Stop()
 		}
 	}
-	// Start a new timer for 400ms debounce
-	timer = time.AfterFunc(400*time.Millisecond, func() {
+	// Start a new timer for the debounce window
+	timer = time.AfterFunc(communicator.options.DebounceWindow, func() {
 		communicator.dispatchDebouncedEvent(key)
 	})
 	communicator.debounceTimers[key] = timer
 	communicator.debounceTimersMutex.Unlock()
 
+	communicator.queueImpliedEvents(evse, eventType)
+}
+
+// queueImpliedEvents queues the events implied by the given event type:
+func (communicator *Communicator) queueImpliedEvents(evse *Evse, eventType types.EmEventType) {
 	// - Online/Offline events will also result in an InfoUpdated event due to IsOnline changing;
 	// - LoggedIn/LoggedOut events will also result in an InfoUpdated event due to IsLoggedIn changing;
-	if eventType == types.EvseOnline || eventType == types.EvseOffline || eventType == types.EvseLoggedIn || eventType == types.EvseLoggedOut {
-		evse.communicator.QueueEvent(evse, types.EvseInfoUpdated)
+	switch eventType {
+	case types.EvseOnline:
+		evse.communicator.QueueEvent(evse, types.EvseInfoUpdated, types.EmFieldChange{Field: "IsOnline", Old: false, New: true})
+	case types.EvseOffline:
+		evse.communicator.QueueEvent(evse, types.EvseInfoUpdated, types.EmFieldChange{Field: "IsOnline", Old: true, New: false})
+	case types.EvseLoggedIn:
+		evse.communicator.QueueEvent(evse, types.EvseInfoUpdated, types.EmFieldChange{Field: "IsLoggedIn", Old: false, New: true})
+	case types.EvseLoggedOut:
+		evse.communicator.QueueEvent(evse, types.EvseInfoUpdated, types.EmFieldChange{Field: "IsLoggedIn", Old: true, New: false})
 	}
 	// - ChargeStarted/ChargeStopped events will also result in a StateUpdated event due to CurrentState changing.
 	if eventType == types.EvseChargeStarted || eventType == types.EvseChargeStopped {
@@ -408,7 +540,7 @@ func (communicator *Communicator) QueueEvent(evse *Evse, eventType types.EmEvent
 
 func (communicator *Communicator) dispatchDebouncedEvent(key string) {
 	communicator.debouncedEventsMutex.Lock()
-	event, exists := communicator.debouncedEvents[key]
+	pending, exists := communicator.debouncedEvents[key]
 	if !exists {
 		communicator.debouncedEventsMutex.Unlock()
 		return
@@ -424,16 +556,35 @@ func (communicator *Communicator) dispatchDebouncedEvent(key string) {
 	}
 	communicator.debounceTimersMutex.Unlock()
 
-	communicator.dispatchEvent(event)
+	communicator.dispatchEvent(pending.event)
 }
 
 func (communicator *Communicator) dispatchEvent(event types.EmEvent) {
-	communicator.watchersMutex.RLock()
-	defer communicator.watchersMutex.RUnlock()
-
-	for _, watcher := range communicator.watchers {
+	// Notify a copy of the watchers list, so watchers can be added and removed (also by Notify itself) meanwhile.
+	// Watchers that are stopped during dispatch just ignore the event.
+	for _, watcher := range communicator.currentWatchers() {
 		watcher.Notify(event)
 	}
+	communicator.persistOnEvent(event)
+}
+
+// FlushEvents dispatches all debounced events immediately, e.g. to collect all events of a replay before stopping.
+func (communicator *Communicator) FlushEvents() {
+	communicator.flushQueuedEvents()
+}
+
+// flushQueuedEvents dispatches all debounced events immediately, in the order they were first queued.
+func (communicator *Communicator) flushQueuedEvents() {
+	communicator.debouncedEventsMutex.Lock()
+	keys := slices.Collect(maps.Keys(communicator.debouncedEvents))
+	slices.SortFunc(keys, func(a, b string) int {
+		return communicator.debouncedEvents[a].firstQueued.Compare(communicator.debouncedEvents[b].firstQueued)
+	})
+	communicator.debouncedEventsMutex.Unlock()
+
+	for _, key := range keys {
+		communicator.dispatchDebouncedEvent(key)
+	}
 }
 
 func (communicator *Communicator) clearQueuedEvents(evse types.EmEvse) {
@@ -0,0 +1,128 @@
+package internal
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/johnwoo-nl/emproto4go/protocol"
+	"github.com/johnwoo-nl/emproto4go/types"
+)
+
+// compatJson is the compatibility database: which EVSE models and firmware the library is known to work with, or to
+// deviate from what the library expects. To add a model or firmware, add an entry to it.
+//
+//go:embed compat.json
+var compatJson []byte
+
+// compatEntries are the entries from compatJson, in order.
+var compatEntries = mustParseCompatEntries(compatJson)
+
+// maxPayloadLengths limits the number of different payload lengths recorded per command.
+const maxPayloadLengths = 16
+
+// compatEntry gives the compatibility status of EVSEs matching all of its conditions. The first matching entry
+// applies, so more specific entries (e.g. for a firmware version) go before more general ones.
+type compatEntry struct {
+	Note   string               `json:"note"`
+	Match  profileCondition     `json:"match"`
+	Status types.EmCompatStatus `json:"status"`
+}
+
+func mustParseCompatEntries(data []byte) []compatEntry {
+	var database struct {
+		Entries []compatEntry `json:"entries"`
+	}
+	if err := json.Unmarshal(data, &database); err != nil {
+		panic(fmt.Sprintf("invalid compatibility database: %v", err))
+	}
+	return database.Entries
+}
+
+// compatStatusOf looks up an EVSE's profile in the compatibility database.
+func compatStatusOf(info EvseInfo, newProtocol bool) (types.EmCompatStatus, []string) {
+	for _, entry := range compatEntries {
+		if entry.Match.matches(info, newProtocol) {
+			var notes []string
+			if entry.Note != "" {
+				notes = append(notes, entry.Note)
+			}
+			return entry.Status, notes
+		}
+	}
+	return types.CompatUnverified, nil
+}
+
+// recordPayloadLengthLocked records the payload length of a received datagram.
+func (evse *Evse) recordPayloadLengthLocked(datagram *protocol.Datagram) {
+	if evse.payloadLengths == nil {
+		evse.payloadLengths = make(map[protocol.EmCommand][]int)
+	}
+	lengths := evse.payloadLengths[datagram.Command]
+	if len(lengths) < maxPayloadLengths && !slices.Contains(lengths, len(datagram.Payload)) {
+		evse.payloadLengths[datagram.Command] = append(lengths, len(datagram.Payload))
+	}
+}
+
+func (evse *Evse) CompatReport() types.EmCompatReport {
+	evse.mutex.RLock()
+	defer evse.mutex.RUnlock()
+
+	info := *evse.info
+	newProtocol := evse.state.NewProtocol_
+	status, notes := compatStatusOf(info, newProtocol)
+	report := types.EmCompatReport{
+		Timestamp:       time.Now(),
+		Brand:           info.Brand_,
+		Model:           info.Model_,
+		EvseType:        info.EvseType_,
+		HardwareVersion: info.HardwareVersion_,
+		SoftwareVersion: info.SoftwareVersion_,
+		Phases:          info.Phases_,
+		MaxPower:        info.MaxPower_,
+		MaxCurrent:      info.MaxCurrent_,
+		Feature:         info.Feature_,
+		SupportNew:      info.SupportNew_,
+		Byte70:          info.Byte70_,
+		NewProtocol:     newProtocol,
+		Capabilities:    CapabilitiesOf(info, newProtocol),
+		PayloadLengths:  make([]types.EmPayloadLengths, 0, len(evse.payloadLengths)),
+		Status:          status,
+		Notes:           notes,
+	}
+	for _, command := range slices.Sorted(maps.Keys(evse.payloadLengths)) {
+		report.PayloadLengths = append(report.PayloadLengths, types.EmPayloadLengths{
+			Command:     uint16(command),
+			CommandName: command.Name(),
+			Lengths:     slices.Sorted(slices.Values(evse.payloadLengths[command])),
+		})
+	}
+	return report
+}
+
+// checkCompat logs a warning if the EVSE's profile is not verified in the compatibility database. This is done once
+// per EVSE, after the first login.
+func (evse *Evse) checkCompat() {
+	evse.mutex.Lock()
+	if evse.compatChecked || evse.info.SoftwareVersion_ == "" {
+		evse.mutex.Unlock()
+		return
+	}
+	evse.compatChecked = true
+	info := *evse.info
+	status, notes := compatStatusOf(info, evse.state.NewProtocol_)
+	evse.mutex.Unlock()
+
+	switch status {
+	case types.CompatDeviates:
+		evse.communicator.Logger_.Warnf("[emproto4go] EVSE %s (%s %s, software %s) is known to deviate from what this library expects: %s",
+			evse.Serial(), info.Brand_, info.Model_, info.SoftwareVersion_, strings.Join(notes, " "))
+	case types.CompatUnverified:
+		evse.communicator.Logger_.Warnf("[emproto4go] EVSE %s (%s %s, software %s) is not verified to work with this library; please report whether it works, with the output of EmEvse.CompatReport.",
+			evse.Serial(), info.Brand_, info.Model_, info.SoftwareVersion_)
+	}
+}
@@ -0,0 +1,163 @@
+package internal
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/johnwoo-nl/emproto4go/types"
+)
+
+const DefaultPasswordEnvPrefix = "EMPROTO4GO_PASSWORD_"
+
+// EnvCredentialProvider is a read-only types.EmCredentialProvider that reads passwords from environment variables
+// named after the EVSE's serial, e.g. EMPROTO4GO_PASSWORD_1234567890ABCDEF.
+type EnvCredentialProvider struct {
+	prefix string
+}
+
+func CreateEnvCredentialProvider(prefix string) *EnvCredentialProvider {
+	if prefix == "" {
+		prefix = DefaultPasswordEnvPrefix
+	}
+	return &EnvCredentialProvider{prefix: prefix}
+}
+
+func (provider *EnvCredentialProvider) Password(serial types.EmSerial) (types.EmPassword, error) {
+	return types.EmPassword(os.Getenv(provider.prefix + strings.ToUpper(string(serial)))), nil
+}
+
+func (provider *EnvCredentialProvider) SetPassword(types.EmSerial, types.EmPassword) error {
+	// Read-only; passwords must be set in the environment.
+	return nil
+}
+
+const (
+	credentialsFileVersion = 1
+	credentialsKeyIter     = 600_000
+)
+
+// EncryptedFileCredentialProvider is a types.EmCredentialProvider that keeps passwords in a file encrypted with
+// AES-256-GCM, using a key derived from a passphrase with PBKDF2-SHA256. The file is created when the first password
+// is set.
+type EncryptedFileCredentialProvider struct {
+	path string
+
+	mutex     sync.Mutex
+	salt      []byte
+	key       []byte
+	passwords map[types.EmSerial]types.EmPassword
+}
+
+type credentialsFileContent struct {
+	Version    int    `json:"version"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// CreateEncryptedFileCredentialProvider opens (or prepares to create) the encrypted credentials file at path. Returns
+// an error if the file exists but cannot be decrypted with the passphrase.
+func CreateEncryptedFileCredentialProvider(path string, passphrase string) (*EncryptedFileCredentialProvider, error) {
+	if passphrase == "" {
+		return nil, fmt.Errorf("passphrase for credentials file must not be empty")
+	}
+	provider := &EncryptedFileCredentialProvider{path: path, passwords: make(map[types.EmSerial]types.EmPassword)}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		provider.salt = make([]byte, 16)
+		_, _ = rand.Read(provider.salt)
+		provider.key, err = deriveCredentialsKey(passphrase, provider.salt)
+		return provider, err
+	} else if err != nil {
+		return nil, err
+	}
+
+	var content credentialsFileContent
+	if err := json.Unmarshal(data, &content); err != nil {
+		return nil, fmt.Errorf("invalid credentials file %s: %w", path, err)
+	}
+	if content.Version != credentialsFileVersion {
+		return nil, fmt.Errorf("unsupported credentials file version %d", content.Version)
+	}
+	provider.salt = content.Salt
+	if provider.key, err = deriveCredentialsKey(passphrase, content.Salt); err != nil {
+		return nil, err
+	}
+	aead, err := provider.aead()
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, content.Nonce, content.Ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decrypt credentials file %s (wrong passphrase?)", path)
+	}
+	if err := json.Unmarshal(plaintext, &provider.passwords); err != nil {
+		return nil, fmt.Errorf("invalid credentials in %s: %w", path, err)
+	}
+	return provider, nil
+}
+
+func (provider *EncryptedFileCredentialProvider) Password(serial types.EmSerial) (types.EmPassword, error) {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+	return provider.passwords[serial], nil
+}
+
+func (provider *EncryptedFileCredentialProvider) SetPassword(serial types.EmSerial, password types.EmPassword) error {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+
+	if provider.passwords[serial] == password {
+		return nil
+	}
+	provider.passwords[serial] = password
+	return provider.write()
+}
+
+// write encrypts the passwords with a fresh nonce and writes them to the file. Must be called with mutex held.
+func (provider *EncryptedFileCredentialProvider) write() error {
+	plaintext, err := json.Marshal(provider.passwords)
+	if err != nil {
+		return err
+	}
+	aead, err := provider.aead()
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	_, _ = rand.Read(nonce)
+	content := credentialsFileContent{
+		Version:    credentialsFileVersion,
+		Salt:       provider.salt,
+		Nonce:      nonce,
+		Ciphertext: aead.Seal(nil, nonce, plaintext, nil),
+	}
+	data, err := json.Marshal(content)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(provider.path, data)
+}
+
+func (provider *EncryptedFileCredentialProvider) aead() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(provider.key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func deriveCredentialsKey(passphrase string, salt []byte) ([]byte, error) {
+	return pbkdf2.Key(sha256.New, passphrase, salt, credentialsKeyIter, 32)
+}
@@ -0,0 +1,205 @@
+package internal
+
+import (
+	"bytes"
+	"cmp"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/johnwoo-nl/emproto4go/protocol"
+	"github.com/johnwoo-nl/emproto4go/types"
+)
+
+const (
+	// maxDiagnosticsEntries limits the number of unknown commands and values each, so an EVSE sending garbage cannot
+	// make the diagnostics grow without bounds. Further commands and values are not recorded.
+	maxDiagnosticsEntries = 1024
+	// maxDiagnosticsSamples is the number of distinct sample payloads kept per unknown command and payload length.
+	maxDiagnosticsSamples = 5
+)
+
+// knownValues are the values of enum fields (and the error bits) that have a known meaning. Other values are recorded
+// by Diagnostics.ObserveValue.
+var knownValues = map[string][]uint32{
+	"current_state":      knownCurrentStates,
+	"new_protocol_state": knownNewProtocolStates,
+	"charge_state":       knownCurrentStates,
+	"gun_state": {
+		uint32(types.GunNotConnected), uint32(types.GunConnectedUnlocked), uint32(types.GunConnectedLocked),
+	},
+	"output_state": {
+		uint32(types.OutputStateCharging), uint32(types.OutputStateIdle),
+	},
+	"error_bit": {
+		uint32(types.RelayStickErrorL1), uint32(types.RelayStickErrorL2), uint32(types.RelayStickErrorL3),
+		uint32(types.Offline), uint32(types.CCError), uint32(types.CPError), uint32(types.EmergencyStop),
+		uint32(types.OverTemperatureInner), uint32(types.OverTemperatureOuter), uint32(types.LeakageProtection),
+		uint32(types.ShortCircuit), uint32(types.OverCurrent), uint32(types.Ungrounded), uint32(types.OverVoltage),
+		uint32(types.LowVoltage), uint32(types.InputPowerError), uint32(types.MainsOverload),
+		uint32(types.DiodeShortCircuit), uint32(types.RTCFailure), uint32(types.FlashMemoryFailure),
+		uint32(types.EEPROMFailure), uint32(types.MeteringModuleFailure),
+	},
+}
+
+var knownCurrentStates = []uint32{
+	uint32(types.EvseFault), uint32(types.ChargingFault2), uint32(types.ChargingFault3),
+	uint32(types.WaitingForSwipe), uint32(types.WaitingForButton), uint32(types.NotConnected),
+	uint32(types.ReadyToCharge), uint32(types.Charging), uint32(types.Completed), uint32(types.CompletedFullCharge),
+	uint32(types.ChargingReservation),
+}
+
+// knownNewProtocolStates are the values of the state byte that only the longer status and charging datagrams have. It
+// is 0 or mirrors the current state, except for 18 and 19, which only occur there and are the only values the
+// handlers use.
+var knownNewProtocolStates = append([]uint32{
+	uint32(types.CurrentStateUnknown0), uint32(types.CurrentStateUnknown18), uint32(types.CurrentStateUnknown19),
+}, knownCurrentStates...)
+
+// Diagnostics collects the unknown commands and values a communicator receives. See types.EmDiagnostics.
+type Diagnostics struct {
+	mutex    sync.Mutex
+	since    time.Time
+	commands map[unknownCommandKey]*unknownCommandStats
+	values   map[unknownValueKey]*unknownValueStats
+}
+
+type unknownCommandKey struct {
+	command       protocol.EmCommand
+	payloadLength int
+}
+
+type unknownCommandStats struct {
+	count     uint64
+	serials   map[types.EmSerial]struct{}
+	firstSeen time.Time
+	lastSeen  time.Time
+	samples   [][]byte
+}
+
+type unknownValueKey struct {
+	field string
+	value uint32
+}
+
+type unknownValueStats struct {
+	count     uint64
+	serials   map[types.EmSerial]struct{}
+	firstSeen time.Time
+	lastSeen  time.Time
+}
+
+// Reset discards everything collected so far.
+func (diagnostics *Diagnostics) Reset() {
+	diagnostics.mutex.Lock()
+	defer diagnostics.mutex.Unlock()
+
+	diagnostics.since = time.Now()
+	diagnostics.commands = make(map[unknownCommandKey]*unknownCommandStats)
+	diagnostics.values = make(map[unknownValueKey]*unknownValueStats)
+}
+
+// ObserveDatagram records a datagram that no handler recognized.
+func (diagnostics *Diagnostics) ObserveDatagram(serial types.EmSerial, datagram *protocol.Datagram) {
+	diagnostics.mutex.Lock()
+	defer diagnostics.mutex.Unlock()
+
+	key := unknownCommandKey{command: datagram.Command, payloadLength: len(datagram.Payload)}
+	stats := diagnostics.commands[key]
+	if stats == nil {
+		if len(diagnostics.commands) >= maxDiagnosticsEntries {
+			return
+		}
+		stats = &unknownCommandStats{serials: make(map[types.EmSerial]struct{}), firstSeen: time.Now()}
+		diagnostics.commands[key] = stats
+	}
+	stats.count++
+	stats.serials[serial] = struct{}{}
+	stats.lastSeen = time.Now()
+	if len(stats.samples) < maxDiagnosticsSamples && !slices.ContainsFunc(stats.samples, func(sample []byte) bool {
+		return bytes.Equal(sample, datagram.Payload)
+	}) {
+		stats.samples = append(stats.samples, bytes.Clone(datagram.Payload))
+	}
+}
+
+// ObserveValue records the value of an enum field (see knownValues for the field names) if it has no known meaning.
+func (diagnostics *Diagnostics) ObserveValue(serial types.EmSerial, field string, value uint32) {
+	if slices.Contains(knownValues[field], value) {
+		return
+	}
+
+	diagnostics.mutex.Lock()
+	defer diagnostics.mutex.Unlock()
+
+	key := unknownValueKey{field: field, value: value}
+	stats := diagnostics.values[key]
+	if stats == nil {
+		if len(diagnostics.values) >= maxDiagnosticsEntries {
+			return
+		}
+		stats = &unknownValueStats{serials: make(map[types.EmSerial]struct{}), firstSeen: time.Now()}
+		diagnostics.values[key] = stats
+	}
+	stats.count++
+	stats.serials[serial] = struct{}{}
+	stats.lastSeen = time.Now()
+}
+
+// Report returns a copy of everything collected so far.
+func (diagnostics *Diagnostics) Report() types.EmDiagnostics {
+	diagnostics.mutex.Lock()
+	defer diagnostics.mutex.Unlock()
+
+	report := types.EmDiagnostics{
+		Since:           diagnostics.since,
+		UnknownCommands: make([]types.EmUnknownCommand, 0, len(diagnostics.commands)),
+		UnknownValues:   make([]types.EmUnknownValue, 0, len(diagnostics.values)),
+	}
+	for key, stats := range diagnostics.commands {
+		samples := make([]string, len(stats.samples))
+		for i, sample := range stats.samples {
+			samples[i] = hexBytes(sample)
+		}
+		report.UnknownCommands = append(report.UnknownCommands, types.EmUnknownCommand{
+			Command:       uint16(key.command),
+			CommandName:   key.command.Name(),
+			PayloadLength: key.payloadLength,
+			Count:         stats.count,
+			Evses:         len(stats.serials),
+			FirstSeen:     stats.firstSeen,
+			LastSeen:      stats.lastSeen,
+			Samples:       samples,
+		})
+	}
+	for key, stats := range diagnostics.values {
+		report.UnknownValues = append(report.UnknownValues, types.EmUnknownValue{
+			Field:     key.field,
+			Value:     key.value,
+			Count:     stats.count,
+			Evses:     len(stats.serials),
+			FirstSeen: stats.firstSeen,
+			LastSeen:  stats.lastSeen,
+		})
+	}
+	slices.SortFunc(report.UnknownCommands, func(a, b types.EmUnknownCommand) int {
+		return cmp.Or(cmp.Compare(a.Command, b.Command), cmp.Compare(a.PayloadLength, b.PayloadLength))
+	})
+	slices.SortFunc(report.UnknownValues, func(a, b types.EmUnknownValue) int {
+		return cmp.Or(cmp.Compare(a.Field, b.Field), cmp.Compare(a.Value, b.Value))
+	})
+	return report
+}
+
+func (communicator *Communicator) Diagnostics() types.EmDiagnostics {
+	return communicator.diagnostics.Report()
+}
+
+func (communicator *Communicator) ResetDiagnostics() {
+	communicator.diagnostics.Reset()
+}
+
+// ObserveValue records the value of an enum field received from the EVSE if it has no known meaning.
+func (evse *Evse) ObserveValue(field string, value uint32) {
+	evse.communicator.diagnostics.ObserveValue(evse.Serial(), field, value)
+}
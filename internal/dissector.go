@@ -0,0 +1,185 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/johnwoo-nl/emproto4go/protocol"
+)
+
+// GenerateDissector returns the source of a Wireshark Lua dissector for the EVSEMaster protocol. It decodes the header
+// of every datagram on UDP port 28376, and the payload fields of the commands in protocol.PayloadSchemas.
+func GenerateDissector() string {
+	var lua strings.Builder
+	w := func(format string, args ...any) {
+		_, _ = fmt.Fprintf(&lua, format, args...)
+		lua.WriteByte('\n')
+	}
+
+	w("-- Wireshark dissector for the EVSEMaster protocol (UDP port 28376), generated by emproto4go.")
+	w("-- Do not edit; regenerate with: clitest dissector=evsemaster.lua")
+	w("-- To install, copy this file to Wireshark's personal Lua plugins folder (see Help > About Wireshark > Folders).")
+	w("")
+	w("local evsemaster = Proto(\"evsemaster\", \"EVSEMaster Protocol\")")
+	w("")
+
+	// Command names, sorted by code.
+	w("local commands = {")
+	for _, code := range protocol.KnownCommands() {
+		w("\t[0x%04x] = %q,", uint16(code), code.Name())
+	}
+	w("}")
+	w("")
+
+	w("local f = evsemaster.fields")
+	w("f.magic = ProtoField.uint16(\"evsemaster.magic\", \"Magic\", base.HEX)")
+	w("f.length = ProtoField.uint16(\"evsemaster.length\", \"Length\", base.DEC)")
+	w("f.key = ProtoField.uint8(\"evsemaster.key\", \"Key\", base.DEC)")
+	w("f.serial = ProtoField.bytes(\"evsemaster.serial\", \"Serial\", base.NONE)")
+	w("f.password = ProtoField.string(\"evsemaster.password\", \"Password\")")
+	w("f.command = ProtoField.uint16(\"evsemaster.command\", \"Command\", base.HEX, commands)")
+	w("f.payload = ProtoField.bytes(\"evsemaster.payload\", \"protocol.Payload\")")
+	w("f.checksum = ProtoField.uint16(\"evsemaster.checksum\", \"Checksum\", base.HEX)")
+	w("f.tail = ProtoField.uint16(\"evsemaster.tail\", \"Tail\", base.HEX)")
+	for _, schema := range protocol.PayloadSchemas {
+		for _, field := range schema.Fields {
+			w("f.%s_%s = %s", schema.Name, field.Name, protoField(schema, field))
+		}
+	}
+	w("")
+
+	w("-- Appends the scaled value, or \"not set\" for sentinel values, to a payload field item.")
+	w("local function append_value(item, raw, sentinel, bias, scale, unit)")
+	w("\tif sentinel ~= nil and raw == sentinel then")
+	w("\t\titem:append_text(\" (not set)\")")
+	w("\telseif scale ~= nil then")
+	w("\t\titem:append_text(string.format(\" (%%.2f%%s)\", (raw + bias) * scale, unit))")
+	w("\telseif unit ~= \"\" then")
+	w("\t\titem:append_text(unit)")
+	w("\tend")
+	w("end")
+	w("")
+	w("-- Appends an EVSEMaster timestamp (the EVSE's local time, as if it were in UTC+8) to a payload field item.")
+	w("local function append_timestamp(item, raw)")
+	w("\tif raw == 0 or raw == 0xffffffff then")
+	w("\t\titem:append_text(\" (not set)\")")
+	w("\telse")
+	w("\t\titem:append_text(\" (\" .. os.date(\"!%%Y-%%m-%%d %%H:%%M:%%S\", raw + 8 * 3600) .. \" EVSE local time)\")")
+	w("\tend")
+	w("end")
+	w("")
+
+	w("local layouts = {}")
+	for _, schema := range protocol.PayloadSchemas {
+		w("")
+		w("local function dissect_%s(buf, tree)", schema.Name)
+		w("\tlocal len = buf:len()")
+		w("\tlocal range, item")
+		for _, field := range schema.Fields {
+			writeDissect(w, schema, field)
+		}
+		w("end")
+		for _, command := range schema.Commands {
+			w("layouts[0x%04x] = dissect_%s", uint16(command), schema.Name)
+		}
+	}
+	w("")
+
+	w("function evsemaster.dissector(buf, pinfo, tree)")
+	w("\tlocal len = buf:len()")
+	w("\tif len < 25 or buf(0, 2):uint() ~= 0x0601 then")
+	w("\t\treturn 0")
+	w("\tend")
+	w("\tpinfo.cols.protocol = \"EVSEMaster\"")
+	w("")
+	w("\tlocal command = buf(19, 2):uint()")
+	w("\tlocal name = commands[command] or string.format(\"Unknown command 0x%%04x\", command)")
+	w("\tlocal serial = buf(5, 8):bytes():tohex(true)")
+	w("\tpinfo.cols.info = name .. \" serial=\" .. serial")
+	w("\tlocal subtree = tree:add(evsemaster, buf(), \"EVSEMaster Protocol, \" .. name)")
+	w("")
+	w("\tsubtree:add(f.magic, buf(0, 2))")
+	w("\tlocal length_item = subtree:add(f.length, buf(2, 2))")
+	w("\tif buf(2, 2):uint() ~= len then")
+	w("\t\tlength_item:append_text(\" [does not match datagram length \" .. len .. \"]\")")
+	w("\tend")
+	w("\tsubtree:add(f.key, buf(4, 1))")
+	w("\tsubtree:add(f.serial, buf(5, 8))")
+	w("\tlocal password = buf(13, 6)")
+	w("\tif password:bytes():tohex() == \"000000000000\" then")
+	w("\t\tsubtree:add(f.password, password, \"\"):append_text(\"(not set)\")")
+	w("\telse")
+	w("\t\tsubtree:add(f.password, password, password:stringz())")
+	w("\tend")
+	w("\tsubtree:add(f.command, buf(19, 2))")
+	w("")
+	w("\tif len > 25 then")
+	w("\t\tlocal payload = buf(21, len - 25)")
+	w("\t\tlocal payload_tree = subtree:add(f.payload, payload)")
+	w("\t\tlocal dissect = layouts[command]")
+	w("\t\tif dissect ~= nil then")
+	w("\t\t\tdissect(payload:tvb(), payload_tree)")
+	w("\t\tend")
+	w("\tend")
+	w("")
+	w("\tlocal sum = 0")
+	w("\tfor i = 0, len - 5 do")
+	w("\t\tsum = (sum + buf(i, 1):uint()) %% 0xffff")
+	w("\tend")
+	w("\tlocal checksum_item = subtree:add(f.checksum, buf(len - 4, 2))")
+	w("\tif buf(len - 4, 2):uint() == sum then")
+	w("\t\tchecksum_item:append_text(\" [correct]\")")
+	w("\telse")
+	w("\t\tchecksum_item:append_text(string.format(\" [incorrect, should be 0x%%04x]\", sum))")
+	w("\tend")
+	w("\tsubtree:add(f.tail, buf(len - 2, 2))")
+	w("\treturn len")
+	w("end")
+	w("")
+	w("DissectorTable.get(\"udp.port\"):add(28376, evsemaster)")
+	return lua.String()
+}
+
+// protoField returns the Lua ProtoField constructor for the field.
+func protoField(schema *protocol.PayloadSchema, field protocol.SchemaField) string {
+	abbrev := fmt.Sprintf("evsemaster.%s.%s", schema.Name, field.Name)
+	if field.Kind == protocol.FieldString {
+		return fmt.Sprintf("ProtoField.string(%q, %q)", abbrev, field.Label)
+	}
+	base := "base.DEC"
+	if field.Hex {
+		base = "base.HEX"
+	}
+	return fmt.Sprintf("ProtoField.uint%d(%q, %q, %s)", field.Width*8, abbrev, field.Label, base)
+}
+
+// writeDissect writes the Lua statements that add the field to the payload tree, if it is present in the payload.
+func writeDissect(w func(string, ...any), schema *protocol.PayloadSchema, field protocol.SchemaField) {
+	w("\tif len >= %d then", field.RequiredLength())
+	w("\t\trange = buf(%d, %d)", field.Offset, field.Width)
+	switch {
+	case field.Kind == protocol.FieldString:
+		w("\t\ttree:add(f.%s_%s, range, range:stringz())", schema.Name, field.Name)
+	case field.Kind == protocol.FieldTimestamp:
+		w("\t\titem = tree:add(f.%s_%s, range)", schema.Name, field.Name)
+		w("\t\tappend_timestamp(item, range:uint())")
+	case field.Scale != 0 || field.HasSentinel || field.Unit != "":
+		sentinel := "nil"
+		if field.HasSentinel {
+			sentinel = fmt.Sprintf("0x%x", field.Sentinel)
+		}
+		scale := "nil"
+		if field.Scale != 0 {
+			scale = fmt.Sprintf("%g", field.Scale)
+		}
+		unit := field.Unit
+		if unit != "" {
+			unit = " " + unit
+		}
+		w("\t\titem = tree:add(f.%s_%s, range)", schema.Name, field.Name)
+		w("\t\tappend_value(item, range:uint(), %s, %d, %s, %q)", sentinel, field.Bias, scale, unit)
+	default:
+		w("\t\ttree:add(f.%s_%s, range)", schema.Name, field.Name)
+	}
+	w("\tend")
+}
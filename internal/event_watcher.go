@@ -1,10 +1,22 @@
 package internal
 
 import (
+	"sync"
+	"sync/atomic"
+	"time"
+
 	"github.com/johnwoo-nl/emproto4go/types"
 )
 
+const (
+	defaultWatchBlockTimeout = 1 * time.Second
+	defaultWatchBufferSize   = 64
+)
+
 type EventWatcher struct {
+	// Identifies the watcher in the communicator's registry.
+	id uint64
+
 	// Communicator that created this watcher. Needed to remove from the watchers list when Stop() is called.
 	communicator *Communicator
 
@@ -17,18 +29,72 @@ type EventWatcher struct {
 	// Channel on which events are sent (send-only for internal use).
 	channel chan<- types.EmEvent
 
+	// How events are delivered to the channel.
+	options types.EmWatchOptions
+
+	// Guards stopped and sending to the channel, so the channel is never closed during a send.
+	mutex sync.Mutex
+
 	// Whether the watcher has been stopped (Stop() has been called or the watcher stopped itself due to
 	// an issue sending events to the channel).
 	stopped bool
+
+	// Closed when Stop() is called, to abort blocked sends and end the pump goroutine.
+	stopCh   chan struct{}
+	stopOnce sync.Once
+
+	// Number of events that could not be delivered.
+	dropped atomic.Uint64
+
+	// With DeliveryDropOldest: events waiting to be sent by the pump goroutine, which is signaled via bufferSignal
+	// and closes pumpDone when it exits.
+	buffer       []types.EmEvent
+	bufferMutex  sync.Mutex
+	bufferSignal chan struct{}
+	pumpDone     chan struct{}
+}
+
+func createEventWatcher(communicator *Communicator, id uint64, evse *Evse, eventTypes []types.EmEventType, channel chan<- types.EmEvent, options types.EmWatchOptions) *EventWatcher {
+	if options.BlockTimeout <= 0 {
+		options.BlockTimeout = defaultWatchBlockTimeout
+	}
+	if options.BufferSize <= 0 {
+		options.BufferSize = defaultWatchBufferSize
+	}
+	watcher := &EventWatcher{
+		id:           id,
+		communicator: communicator,
+		evse:         evse,
+		eventTypes:   eventTypes,
+		channel:      channel,
+		options:      options,
+		stopCh:       make(chan struct{}),
+	}
+	if options.Policy == types.DeliveryDropOldest {
+		watcher.bufferSignal = make(chan struct{}, 1)
+		watcher.pumpDone = make(chan struct{})
+		go watcher.pump()
+	}
+	return watcher
 }
 
 func (watcher *EventWatcher) Stop() {
-	watcher.stopped = true
-	close(watcher.channel)
-	watcher.communicator.RemoveWatcher(watcher)
+	watcher.stopOnce.Do(func() {
+		close(watcher.stopCh)
+		if watcher.pumpDone != nil {
+			<-watcher.pumpDone
+		}
+		watcher.mutex.Lock()
+		watcher.stopped = true
+		close(watcher.channel)
+		watcher.mutex.Unlock()
+		watcher.communicator.RemoveWatcher(watcher)
+	})
 }
 
 func (watcher *EventWatcher) IsStopped() bool {
+	watcher.mutex.Lock()
+	defer watcher.mutex.Unlock()
 	return watcher.stopped
 }
 
@@ -40,37 +106,151 @@ func (watcher *EventWatcher) EventTypes() []types.EmEventType {
 	return watcher.eventTypes
 }
 
+func (watcher *EventWatcher) Dropped() uint64 {
+	return watcher.dropped.Load()
+}
+
 func (watcher *EventWatcher) Notify(event types.EmEvent) {
+	if !watcher.matches(event) {
+		return
+	}
+
+	if watcher.options.Policy == types.DeliveryDropOldest {
+		watcher.enqueue(event)
+		return
+	}
+
+	watcher.mutex.Lock()
 	if watcher.stopped {
-		watcher.communicator.RemoveWatcher(watcher)
+		watcher.mutex.Unlock()
 		return
 	}
+	delivered := watcher.send(event)
+	watcher.mutex.Unlock()
+
+	if !delivered {
+		watcher.dropped.Add(1)
+		if watcher.options.Policy == types.DeliveryStop {
+			watcher.communicator.Logger_.Warnf("[emproto4go] Event channel of watcher is full; stopping the watcher")
+			watcher.Stop()
+		}
+	}
+}
 
+func (watcher *EventWatcher) matches(event types.EmEvent) bool {
 	// Check if event matches the watcher EVSE.
 	if watcher.evse != nil && event.Evse.Serial() != watcher.evse.Serial() {
-		return
+		return false
 	}
 
 	// Check if event matches the watcher event types.
 	if len(watcher.eventTypes) > 0 {
-		found := false
 		for _, t := range watcher.eventTypes {
 			if t == event.Type {
-				found = true
-				break
+				return true
 			}
 		}
-		if !found {
-			return
+		return false
+	}
+	return true
+}
+
+// send sends the event to the channel according to the watcher's policy, returning whether it was delivered. Must be
+// called with mutex held.
+func (watcher *EventWatcher) send(event types.EmEvent) bool {
+	if watcher.options.Policy == types.DeliveryBlock {
+		timer := time.NewTimer(watcher.options.BlockTimeout)
+		defer timer.Stop()
+		select {
+		case watcher.channel <- event:
+			return true
+		case <-timer.C:
+			return false
+		case <-watcher.stopCh:
+			return false
 		}
 	}
 
-	// Send event to channel (non-blocking).
 	select {
 	case watcher.channel <- event:
-		// sent successfully
+		return true
+	default:
+		// channel full or not ready
+		return false
+	}
+}
+
+// enqueue adds the event to the ring buffer for the pump goroutine, dropping the oldest buffered event if full.
+func (watcher *EventWatcher) enqueue(event types.EmEvent) {
+	watcher.bufferMutex.Lock()
+	if len(watcher.buffer) >= watcher.options.BufferSize {
+		watcher.buffer = watcher.buffer[1:]
+		watcher.dropped.Add(1)
+	}
+	watcher.buffer = append(watcher.buffer, event)
+	watcher.bufferMutex.Unlock()
+
+	select {
+	case watcher.bufferSignal <- struct{}{}:
 	default:
-		// channel full, not ready or closed
-		watcher.Stop()
+		// Pump was already signaled.
+	}
+}
+
+func (watcher *EventWatcher) dequeue() (types.EmEvent, bool) {
+	watcher.bufferMutex.Lock()
+	defer watcher.bufferMutex.Unlock()
+
+	if len(watcher.buffer) == 0 {
+		return types.EmEvent{}, false
+	}
+	event := watcher.buffer[0]
+	watcher.buffer = watcher.buffer[1:]
+	return event, true
+}
+
+// pump sends buffered events to the channel (DeliveryDropOldest), waiting for room as long as needed. When the
+// watcher is stopped, it delivers the remaining buffered events for as far as they fit in the channel.
+func (watcher *EventWatcher) pump() {
+	defer close(watcher.pumpDone)
+	defer watcher.drain()
+	for {
+		select {
+		case <-watcher.stopCh:
+			return
+		case <-watcher.bufferSignal:
+		}
+		for {
+			event, ok := watcher.dequeue()
+			if !ok {
+				break
+			}
+			select {
+			case watcher.channel <- event:
+			case <-watcher.stopCh:
+				watcher.requeue(event)
+				return
+			}
+		}
+	}
+}
+
+func (watcher *EventWatcher) requeue(event types.EmEvent) {
+	watcher.bufferMutex.Lock()
+	defer watcher.bufferMutex.Unlock()
+	watcher.buffer = append([]types.EmEvent{event}, watcher.buffer...)
+}
+
+func (watcher *EventWatcher) drain() {
+	for {
+		event, ok := watcher.dequeue()
+		if !ok {
+			return
+		}
+		select {
+		case watcher.channel <- event:
+		default:
+			watcher.dropped.Add(1)
+		}
 	}
 }
@@ -0,0 +1,161 @@
+package internal
+
+import (
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/johnwoo-nl/emproto4go/types"
+)
+
+// createTestCommunicator creates a started communicator on a pipe network without any EVSEs on it, which dispatches
+// events without debouncing.
+func createTestCommunicator(t *testing.T) *Communicator {
+	t.Helper()
+	options := DefaultCommunicatorOptions()
+	options.DebounceWindow = 0
+	options.Transport = CreatePipeNetwork().Endpoint(&net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 28376})
+	communicator := CreateCommunicator("test", options)
+	communicator.Logger_.SetOutput(io.Discard)
+	if err := communicator.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	t.Cleanup(communicator.Stop)
+	return communicator
+}
+
+// expectClosed fails the test if the channel is not closed within a second, discarding any events still in it.
+func expectClosed(t *testing.T, channel <-chan types.EmEvent) {
+	t.Helper()
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case _, ok := <-channel:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("channel not closed")
+		}
+	}
+}
+
+func TestWatcherStopTwice(t *testing.T) {
+	communicator := createTestCommunicator(t)
+	for _, policy := range []types.EmDeliveryPolicy{types.DeliveryStop, types.DeliveryBlock, types.DeliveryDropNewest, types.DeliveryDropOldest} {
+		channel := make(chan types.EmEvent, 1)
+		watcher := communicator.WatchWithOptions(nil, nil, channel, types.EmWatchOptions{Policy: policy})
+
+		watcher.Stop()
+		watcher.Stop()
+
+		if !watcher.IsStopped() {
+			t.Errorf("policy %v: watcher not stopped", policy)
+		}
+		expectClosed(t, channel)
+	}
+}
+
+func TestRemoveWatcher(t *testing.T) {
+	communicator := createTestCommunicator(t)
+	evse := communicator.defineEvseImpl("0123456789abcdef")
+	kept := make(chan types.EmEvent, 4)
+	removed := make(chan types.EmEvent, 4)
+	keptWatcher := communicator.WatchImpl(nil, nil, kept, types.EmWatchOptions{})
+	removedWatcher := communicator.WatchImpl(nil, nil, removed, types.EmWatchOptions{})
+
+	removedWatcher.Stop()
+
+	watchers := communicator.currentWatchers()
+	if len(watchers) != 1 || watchers[0] != keptWatcher {
+		t.Fatalf("expected only the kept watcher to be registered, got %d watchers", len(watchers))
+	}
+
+	communicator.QueueEvent(evse, types.EvseInfoUpdated)
+	if len(kept) != 1 {
+		t.Errorf("kept watcher got %d events, expected 1", len(kept))
+	}
+	expectClosed(t, removed)
+
+	// Removing a watcher that is not registered (anymore) is harmless.
+	communicator.RemoveWatcher(removedWatcher)
+	if len(communicator.currentWatchers()) != 1 {
+		t.Errorf("removing an unregistered watcher changed the registry")
+	}
+}
+
+// TestStopWatcherDuringDispatch stops watchers while events are dispatched to them concurrently; run with -race.
+func TestStopWatcherDuringDispatch(t *testing.T) {
+	communicator := createTestCommunicator(t)
+	evse := communicator.defineEvseImpl("0123456789abcdef")
+	policies := []types.EmDeliveryPolicy{types.DeliveryStop, types.DeliveryBlock, types.DeliveryDropNewest, types.DeliveryDropOldest}
+
+	for round := 0; round < 20; round++ {
+		var watchers []*EventWatcher
+		var readers sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			channel := make(chan types.EmEvent, 2)
+			options := types.EmWatchOptions{Policy: policies[i%len(policies)], BlockTimeout: time.Millisecond, BufferSize: 2}
+			watchers = append(watchers, communicator.WatchImpl(nil, nil, channel, options))
+			readers.Add(1)
+			go func() {
+				defer readers.Done()
+				for range channel {
+				}
+			}()
+		}
+
+		var dispatchers sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			dispatchers.Add(1)
+			go func() {
+				defer dispatchers.Done()
+				for j := 0; j < 50; j++ {
+					communicator.QueueEvent(evse, types.EvseStateUpdated)
+				}
+			}()
+		}
+		for _, watcher := range watchers {
+			go watcher.Stop()
+		}
+		dispatchers.Wait()
+		for _, watcher := range watchers {
+			watcher.Stop()
+		}
+		readers.Wait() // All channels closed.
+	}
+
+	if count := len(communicator.currentWatchers()); count != 0 {
+		t.Errorf("%d stopped watchers still registered", count)
+	}
+}
+
+func TestCommunicatorStopClosesWatchers(t *testing.T) {
+	communicator := createTestCommunicator(t)
+	evse := communicator.defineEvseImpl("0123456789abcdef")
+
+	var channels []chan types.EmEvent
+	var watchers []types.EmEventWatcher
+	for _, policy := range []types.EmDeliveryPolicy{types.DeliveryStop, types.DeliveryBlock, types.DeliveryDropNewest, types.DeliveryDropOldest} {
+		channel := make(chan types.EmEvent, 4)
+		channels = append(channels, channel)
+		watchers = append(watchers, communicator.WatchWithOptions(nil, nil, channel, types.EmWatchOptions{Policy: policy}))
+	}
+	channel := make(chan types.EmEvent, 4)
+	channels = append(channels, channel)
+	watchers = append(watchers, evse.Watch(nil, channel))
+
+	communicator.Stop()
+
+	for i, watcher := range watchers {
+		if !watcher.IsStopped() {
+			t.Errorf("watcher %d not stopped", i)
+		}
+		expectClosed(t, channels[i])
+	}
+	if count := len(communicator.currentWatchers()); count != 0 {
+		t.Errorf("%d watchers still registered after Stop", count)
+	}
+}
@@ -1,30 +1,46 @@
 package internal
 
 import (
-	"encoding/binary"
+	"context"
 	"fmt"
 	"net"
+	"slices"
 	"sync"
 	"time"
 
+	"github.com/johnwoo-nl/emproto4go/protocol"
 	"github.com/johnwoo-nl/emproto4go/types"
 )
 
 type Evse struct {
 	communicator *Communicator
-	info         *EvseInfo
-	state        *EvseState
-	charge       *EvseCharge
-	config       *EvseConfig
+
+	// mutex guards info, state, charge, config, ip, port, lastSeen, lastActiveLogin, password, clock, clockDrift,
+	// payloadLengths and compatChecked. Readers get copies of the data structs; handlers update them in place through UpdateInfo,
+	// UpdateState etc.
+	mutex  sync.RWMutex
+	info   *EvseInfo
+	state  *EvseState
+	charge *EvseCharge
+	config *EvseConfig
 
 	ip              net.IP
 	port            int
-	LastSeen        *time.Time
-	LastActiveLogin *time.Time
+	lastSeen        *time.Time
+	lastActiveLogin *time.Time
 	password        types.EmPassword
+	// The clock set for this EVSE; timezones that are not set default to the communicator's.
+	clock types.EmClock
+	// The drift of the EVSE's clock from the host's, as last measured; nil if not measured yet.
+	clockDrift *time.Duration
+
+	// Payload lengths seen per received command, for compatibility reports.
+	payloadLengths map[protocol.EmCommand][]int
+	// Whether the EVSE's profile was checked against the compatibility database since the communicator was created.
+	compatChecked bool
 
 	waitersMutex sync.Mutex
-	waiters      map[EmCommand][]chan *Datagram
+	waiters      map[protocol.EmCommand][]chan *protocol.Datagram
 }
 
 func (evse *Evse) Communicator() *Communicator {
@@ -32,110 +48,315 @@ func (evse *Evse) Communicator() *Communicator {
 }
 
 func (evse *Evse) IsOnline() bool {
-	if evse.LastSeen == nil {
+	evse.mutex.RLock()
+	defer evse.mutex.RUnlock()
+	return evse.isOnlineLocked()
+}
+
+func (evse *Evse) isOnlineLocked() bool {
+	if evse.lastSeen == nil {
 		return false
 	}
-	return evse.LastSeen.After(time.Now().Add(-11 * time.Second))
+	return evse.lastSeen.After(time.Now().Add(-evse.communicator.options.OnlineTimeout))
 }
 
 func (evse *Evse) IsLoggedIn() bool {
-	if !evse.IsOnline() || evse.LastActiveLogin == nil {
+	evse.mutex.RLock()
+	defer evse.mutex.RUnlock()
+	return evse.isLoggedInLocked()
+}
+
+func (evse *Evse) isLoggedInLocked() bool {
+	if !evse.isOnlineLocked() || evse.lastActiveLogin == nil {
 		return false
 	}
-	return evse.LastActiveLogin.After(time.Now().Add(-15 * time.Second))
+	return evse.lastActiveLogin.After(time.Now().Add(-evse.communicator.options.LoginTimeout))
 }
 
 func (evse *Evse) MetaState() types.EmMetaState {
-	if !evse.IsOnline() {
+	evse.mutex.RLock()
+	defer evse.mutex.RUnlock()
+	return evse.metaStateLocked()
+}
+
+func (evse *Evse) metaStateLocked() types.EmMetaState {
+	if !evse.isOnlineLocked() {
 		return types.MetaStateOffline
-	} else if !evse.IsLoggedIn() {
+	} else if !evse.isLoggedInLocked() {
 		return types.MetaStateNotLoggedIn
-	} else if len(evse.State().Errors()) > 0 {
+	} else if len(evse.state.Errors_) > 0 {
 		return types.MetaStateError
-	} else if evse.State().OutputState() == types.OutputStateCharging {
+	} else if evse.state.OutputState_ == types.OutputStateCharging {
 		return types.MetaStateCharging
-	} else if evse.State().GunState() > types.GunNotConnected {
+	} else if evse.state.GunState_ > types.GunNotConnected {
 		return types.MetaStatePluggedIn
 	} else {
 		return types.MetaStateIdle
 	}
 }
 
-// Info implements types.EmEvse interface.
+// Info implements types.EmEvse interface. Returns a copy, which will not change when new data comes in.
 func (evse *Evse) Info() types.EmEvseInfo {
-	return evse.info
+	evse.mutex.RLock()
+	defer evse.mutex.RUnlock()
+	return *evse.info
 }
 
-// MutableInfo not exposed to library users, only internally for updating.
-func (evse *Evse) MutableInfo() *EvseInfo {
-	return evse.info
+// State implements types.EmEvse interface. Returns a copy, which will not change when new data comes in.
+func (evse *Evse) State() types.EmEvseState {
+	evse.mutex.RLock()
+	defer evse.mutex.RUnlock()
+	return evse.state.clone()
 }
 
-// State implements types.EmEvse interface.
-func (evse *Evse) State() types.EmEvseState {
-	return evse.state
+// Charge implements types.EmEvse interface. Returns a copy, which will not change when new data comes in.
+func (evse *Evse) Charge() types.EmEvseCharge {
+	evse.mutex.RLock()
+	defer evse.mutex.RUnlock()
+	return *evse.charge
 }
 
-// MutableState not exposed to library users, only internally for updating.
-func (evse *Evse) MutableState() *EvseState {
-	return evse.state
+// Config implements types.EmEvse interface. Returns a copy, which will not change when new data comes in (but whose
+// setters still update the EVSE).
+func (evse *Evse) Config() types.EmEvseConfig {
+	evse.mutex.RLock()
+	defer evse.mutex.RUnlock()
+	config := *evse.config
+	return &config
 }
 
-// Charge implements types.EmEvse interface.
-func (evse *Evse) Charge() types.EmEvseCharge {
-	return evse.charge
+// Snapshot implements types.EmEvse interface.
+func (evse *Evse) Snapshot() types.EmEvseSnapshot {
+	evse.mutex.RLock()
+	defer evse.mutex.RUnlock()
+
+	config := *evse.config
+	return types.EmEvseSnapshot{
+		Timestamp:    time.Now(),
+		Serial:       evse.info.serial,
+		Label:        evse.labelLocked(),
+		IP:           slices.Clone(evse.ip),
+		Port:         evse.port,
+		Online:       evse.isOnlineLocked(),
+		LoggedIn:     evse.isLoggedInLocked(),
+		MetaState:    evse.metaStateLocked(),
+		Capabilities: CapabilitiesOf(*evse.info, evse.state.NewProtocol_),
+		Info:         *evse.info,
+		State:        evse.state.clone(),
+		Charge:       *evse.charge,
+		Config:       &config,
+	}
 }
 
-// MutableCharge not exposed to library users, only internally for updating.
-func (evse *Evse) MutableCharge() *EvseCharge {
-	return evse.charge
+// UpdateInfo calls update with the EVSE's info locked for writing. The update function must return whether it may
+// have changed anything; if so, the info is compared with its previous value and an EvseInfoUpdated event is queued
+// with the changed fields. Returns whether any field changed. Not exposed to library users, only used by handlers.
+func (evse *Evse) UpdateInfo(update func(info *EvseInfo) bool) bool {
+	return updateData(evse, evse.info, types.EvseInfoUpdated, update)
 }
 
-// Config implements types.EmEvse interface.
-func (evse *Evse) Config() types.EmEvseConfig {
-	return evse.config
+// UpdateState is like UpdateInfo, but for the EVSE's state (queueing an EvseStateUpdated event).
+func (evse *Evse) UpdateState(update func(state *EvseState) bool) bool {
+	return updateData(evse, evse.state, types.EvseStateUpdated, update)
+}
+
+// UpdateCharge is like UpdateInfo, but for the EVSE's charge data (queueing an EvseChargeUpdated event).
+func (evse *Evse) UpdateCharge(update func(charge *EvseCharge) bool) bool {
+	return updateData(evse, evse.charge, types.EvseChargeUpdated, update)
 }
 
-// MutableConfig not exposed to library users, only internally for updating.
-func (evse *Evse) MutableConfig() *EvseConfig {
-	return evse.config
+// UpdateConfig is like UpdateInfo, but for the EVSE's config (queueing an EvseConfigUpdated event).
+func (evse *Evse) UpdateConfig(update func(config *EvseConfig) bool) bool {
+	return updateData(evse, evse.config, types.EvseConfigUpdated, update)
+}
+
+func updateData[T any](evse *Evse, data *T, eventType types.EmEventType, update func(data *T) bool) bool {
+	evse.mutex.Lock()
+	before := *data
+	var changes []types.EmFieldChange
+	if update(data) {
+		changes = diffFields(&before, data)
+	}
+	evse.mutex.Unlock()
+
+	if len(changes) == 0 {
+		return false
+	}
+	evse.QueueEvent(eventType, changes...)
+	return true
+}
+
+// MarkActiveLogin records that the login session is still active (e.g. when a heading was answered).
+func (evse *Evse) MarkActiveLogin() {
+	now := time.Now()
+	evse.mutex.Lock()
+	evse.lastActiveLogin = &now
+	evse.mutex.Unlock()
+}
+
+// markLoggedIn records a successful login, queueing an EvseLoggedIn event if the EVSE was not logged in yet.
+func (evse *Evse) markLoggedIn() {
+	now := time.Now()
+	evse.mutex.Lock()
+	wasLoggedIn := evse.isLoggedInLocked()
+	evse.lastActiveLogin = &now
+	evse.mutex.Unlock()
+	if !wasLoggedIn {
+		evse.QueueEvent(types.EvseLoggedIn)
+	}
+}
+
+// clearPresence forgets when the EVSE was last seen and logged in, returning whether it was logged in and online.
+func (evse *Evse) clearPresence() (wasLoggedIn bool, wasOnline bool) {
+	evse.mutex.Lock()
+	defer evse.mutex.Unlock()
+
+	wasLoggedIn, wasOnline = evse.isLoggedInLocked(), evse.isOnlineLocked()
+	evse.lastActiveLogin = nil
+	evse.lastSeen = nil
+	return wasLoggedIn, wasOnline
+}
+
+func (evse *Evse) currentPassword() types.EmPassword {
+	evse.mutex.RLock()
+	defer evse.mutex.RUnlock()
+	return evse.password
+}
+
+func (evse *Evse) Clock() types.EmClock {
+	evse.mutex.RLock()
+	defer evse.mutex.RUnlock()
+	return evse.clock.Or(evse.communicator.options.Clock)
+}
+
+func (evse *Evse) SetClock(clock types.EmClock) {
+	evse.mutex.Lock()
+	evse.clock = clock
+	evse.mutex.Unlock()
+	evse.communicator.persistEvse(evse)
 }
 
 func (evse *Evse) Serial() types.EmSerial {
-	return evse.info.Serial()
+	// The serial never changes, so no need to lock (but don't go through the getter, which would copy all of info).
+	return evse.info.serial
 }
 
 func (evse *Evse) Label() string {
+	evse.mutex.RLock()
+	defer evse.mutex.RUnlock()
+	return evse.labelLocked()
+}
+
+func (evse *Evse) labelLocked() string {
 	if evse.config.Name_ != "" {
 		return evse.config.Name_
 	}
 	if evse.info.Brand_ != "" && evse.info.Model_ != "" {
 		return fmt.Sprintf("%s %s", evse.info.Brand_, evse.info.Model_)
 	}
-	return string(evse.info.Serial())
+	return string(evse.info.serial)
 }
 
 func (evse *Evse) IP() net.IP {
+	evse.mutex.RLock()
+	defer evse.mutex.RUnlock()
 	return evse.ip
 }
 
 func (evse *Evse) Port() int {
+	evse.mutex.RLock()
+	defer evse.mutex.RUnlock()
 	return evse.port
 }
 
 func (evse *Evse) UsePassword(password types.EmPassword) error {
+	return evse.UsePasswordContext(context.Background(), password)
+}
+
+func (evse *Evse) UsePasswordContext(ctx context.Context, password types.EmPassword) error {
 	// If not online, just store the password for later use.
 	if !evse.IsOnline() {
+		evse.mutex.Lock()
 		evse.password = password
+		evse.mutex.Unlock()
+		evse.communicator.persistEvse(evse)
 		return nil
 	}
 
 	// If online, attempt to (re)log in immediately. This returns an error if the password is invalid,
 	// or updates the EVSE password if login succeeds.
-	return evse.Login(password)
+	return evse.LoginContext(ctx, password)
+}
+
+func (evse *Evse) ChangePassword(oldPassword types.EmPassword, newPassword types.EmPassword) error {
+	return evse.ChangePasswordContext(context.Background(), oldPassword, newPassword)
+}
+
+func (evse *Evse) ChangePasswordContext(ctx context.Context, oldPassword types.EmPassword, newPassword types.EmPassword) error {
+	if !evse.communicator.options.ExperimentalCommands {
+		return types.ExperimentalCommandsDisabledError{Evse: evse, Command: uint16(protocol.CmdSetPassword)}
+	}
+	for _, password := range []types.EmPassword{oldPassword, newPassword} {
+		if !password.IsValid() {
+			return types.PasswordFormatError{Password: password}
+		}
+	}
+	if !evse.IsOnline() {
+		return types.EvseOfflineError{Evse: evse}
+	}
+	if !evse.IsLoggedIn() || evse.currentPassword() != oldPassword {
+		if err := evse.LoginContext(ctx, oldPassword); err != nil {
+			return err
+		}
+	}
+
+	datagram := &protocol.Datagram{
+		Command:  protocol.CmdSetPassword,
+		Password: oldPassword,
+		Payload:  protocol.PasswordPayload{Action: protocol.ConfigSet, Password: newPassword}.Encode(),
+	}
+	if sendErr := evse.SendDatagramContext(ctx, datagram); sendErr != nil {
+		return sendErr
+	}
+	response, recvErr := evse.WaitForDatagramContext(ctx, evse.communicator.options.RequestTimeout, protocol.CmdSetPasswordResponse, protocol.CmdPasswordErrorResponse)
+	if recvErr != nil {
+		return recvErr
+	}
+	if response.Command == protocol.CmdPasswordErrorResponse {
+		return types.EvseInvalidPasswordError{Evse: evse}
+	}
+	payload, ok := DecodePayload(evse, response, protocol.PasswordSchema)
+	if !ok {
+		return types.EvseInvalidDatagramError{Evse: evse, ResponseCommand: uint16(response.Command)}
+	}
+	// The response holds the EVSE's password after the change; if it is not the new one, the old one still applies.
+	if types.EmPassword(payload.String("password")) != newPassword {
+		return types.EvsePasswordNotChangedError{Evse: evse}
+	}
+
+	// The old password is no longer valid, so store the new one before logging in again; if that fails, the
+	// communicator will keep trying with the new password. Requests sent with the old password just before the change
+	// may still be answered with a password error, which the login can take for its own, so a failed login doesn't
+	// fail the change.
+	evse.mutex.Lock()
+	evse.password = newPassword
+	evse.mutex.Unlock()
+	evse.communicator.passwordAccepted(evse, newPassword)
+	evse.communicator.persistEvse(evse)
+	evse.communicator.Logger_.Infof("[emproto4go] Changed password of EVSE %s.", evse.Serial())
+
+	if err := evse.LoginContext(ctx, newPassword); err != nil {
+		evse.communicator.Logger_.Warnf("[emproto4go] Failed to log in to EVSE %s with its new password: %v (will retry).",
+			evse.Serial(), err)
+	}
+	return nil
 }
 
 func (evse *Evse) StartCharge(params types.ChargeStartParams) (types.ChargeStartResult, error) {
+	return evse.StartChargeContext(context.Background(), params)
+}
+
+func (evse *Evse) StartChargeContext(ctx context.Context, params types.ChargeStartParams) (types.ChargeStartResult, error) {
 	if !evse.IsOnline() {
 		return types.ChargeStartResult{
 			ErrorReason:  types.ChargeStartErrorEvseOffline,
@@ -150,14 +371,14 @@ func (evse *Evse) StartCharge(params types.ChargeStartParams) (types.ChargeStart
 	}
 
 	startChargeDatagram := evse.createChargeStartDatagram(params)
-	sendErr := evse.SendDatagram(startChargeDatagram)
+	sendErr := evse.SendDatagramContext(ctx, startChargeDatagram)
 	if sendErr != nil {
 		return types.ChargeStartResult{
 			ErrorReason:  types.ChargeStartErrorSendFailed,
 			ErrorMessage: GetChargeStartErrorReasonMessage(types.ChargeStartErrorSendFailed),
 		}, sendErr
 	}
-	response, recvErr := evse.WaitForDatagram(5*time.Second, CmdChargeStartResponse)
+	response, recvErr := evse.WaitForDatagramContext(ctx, evse.communicator.options.RequestTimeout, protocol.CmdChargeStartResponse)
 	if recvErr != nil {
 		return types.ChargeStartResult{
 			ErrorReason:  types.ChargeStartErrorNoConfirmation,
@@ -165,14 +386,15 @@ func (evse *Evse) StartCharge(params types.ChargeStartParams) (types.ChargeStart
 		}, recvErr
 	}
 
-	if CheckPayloadLength(response, evse, 5) {
+	responsePayload, ok := DecodePayload(evse, response, protocol.ChargeStartResponseSchema)
+	if !ok {
 		return types.ChargeStartResult{
 			ErrorReason:  types.ChargeStartErrorNoConfirmation,
 			ErrorMessage: GetChargeStartErrorReasonMessage(types.ChargeStartErrorNoConfirmation),
 		}, types.EvseInvalidDatagramError{Evse: evse, ResponseCommand: uint16(response.Command)}
 	}
 
-	errorReason := types.ChargeStartErrorReason(response.Payload[3])
+	errorReason := types.ChargeStartErrorReason(responsePayload.Byte("error_reason"))
 	errorMessage := GetChargeStartErrorReasonMessage(errorReason)
 	if errorReason != types.ChargeStartOK {
 		return types.ChargeStartResult{ErrorReason: errorReason, ErrorMessage: errorMessage},
@@ -181,35 +403,37 @@ func (evse *Evse) StartCharge(params types.ChargeStartParams) (types.ChargeStart
 
 	return types.ChargeStartResult{
 		ErrorReason: types.ChargeStartOK,
-		LineId:      response.Payload[0],
-		Current:     types.Amps(response.Payload[4]),
+		LineId:      responsePayload.Byte("line_id"),
+		Current:     types.Amps(responsePayload.Byte("current")),
 	}, nil
 }
 
-func (evse *Evse) createChargeStartDatagram(params types.ChargeStartParams) *Datagram {
+func (evse *Evse) createChargeStartDatagram(params types.ChargeStartParams) *protocol.Datagram {
 	now := time.Now()
+	info := evse.Info()
+	config := evse.Config()
 
 	lineId := 2
 	chargeType := 1
 	if params.ForceSinglePhase {
-		if evse.info.CanForceSinglePhase() {
+		if info.CanForceSinglePhase() {
 			lineId = 1
 			chargeType = 11
 		} else {
 			evse.communicator.Logger_.Warnf("[emproto4go] ChargeStart for EVSE %s requested ForceSinglePhase, but this EVSE does not support it. Will use all available phases.", evse.Serial())
 		}
 	}
-	maxCurrent := min(params.MaxCurrent, evse.config.MaxCurrent())
+	maxCurrent := min(params.MaxCurrent, config.MaxCurrent())
 	if maxCurrent <= 6 {
 		maxCurrent = 6
-	} else if maxCurrent > evse.info.MaxCurrent() {
-		maxCurrent = evse.info.MaxCurrent()
+	} else if maxCurrent > info.MaxCurrent() {
+		maxCurrent = info.MaxCurrent()
 	}
 	userId := params.UserId
 	if userId == "" {
 		userId = evse.communicator.AppName_
 	}
-	chargeId := MakeChargeId(params.ChargeId)
+	chargeId := MakeChargeId(params.ChargeId, evse.Clock())
 	startAt := now
 	isReservation := 0
 	if params.StartAt.Unix() > 0 {
@@ -219,31 +443,34 @@ func (evse *Evse) createChargeStartDatagram(params types.ChargeStartParams) *Dat
 		}
 	}
 
-	var payload [47]byte
-	payload[0] = byte(lineId)
-	WriteUserId(payload[1:17], userId)
-	copy(payload[17:33], chargeId)
-	payload[33] = byte(isReservation)
-	binary.BigEndian.PutUint32(payload[34:38], TimeToEmTimestamp(&startAt))
-	payload[38] = byte(1) // startType, always 1
-	payload[39] = byte(chargeType)
-	if params.MaxDuration <= 0 {
-		// Zero/uninitialized, means no limit.
-		binary.BigEndian.PutUint16(payload[40:42], 0xFFFF)
-	} else {
-		binary.BigEndian.PutUint16(payload[40:42], max(1, uint16(params.MaxDuration.Minutes())))
-	}
-	if params.MaxEnergy <= 0 {
-		binary.BigEndian.PutUint16(payload[42:44], 0xFFFF)
-	} else {
-		binary.BigEndian.PutUint16(payload[42:44], uint16(params.MaxEnergy*100))
-	}
-	binary.BigEndian.PutUint16(payload[44:46], 0xFFFF) // Param3, meaning unknown, always 0xFFFF in the OEM app. Leaving it at 0x0000 makes the session stop after just a few seconds, even before car starts taking amps.
-	payload[46] = byte(maxCurrent)
-	return &Datagram{Command: CmdChargeStart, Payload: payload[:]}
+	payload := protocol.ChargeStartSchema.New(0)
+	payload.Clock = evse.Clock()
+	payload.SetUint("line_id", uint64(lineId))
+	payload.SetString("user_id", string(userId))
+	payload.SetString("charge_id", string(chargeId))
+	payload.SetUint("is_reservation", uint64(isReservation))
+	payload.SetTime("start_time", &startAt)
+	payload.SetUint("start_type", 1) // Always 1.
+	payload.SetUint("charge_type", uint64(chargeType))
+	// Zero/uninitialized limits mean no limit, which is the default in a new payload.
+	if params.MaxDuration > 0 {
+		payload.SetUint("max_duration", max(1, uint64(params.MaxDuration.Minutes())))
+	}
+	if params.MaxEnergy > 0 {
+		payload.SetFloat("max_energy", float64(params.MaxEnergy))
+	}
+	// Meaning unknown, always 0xFFFF in the OEM app. Leaving it at 0x0000 makes the session stop after just a few seconds,
+	// even before car starts taking amps.
+	payload.SetUint("param3", 0xFFFF)
+	payload.SetUint("max_current", uint64(maxCurrent))
+	return &protocol.Datagram{Command: protocol.CmdChargeStart, Payload: payload.Data}
 }
 
 func (evse *Evse) StopCharge(params types.ChargeStopParams) (types.ChargeStopResult, error) {
+	return evse.StopChargeContext(context.Background(), params)
+}
+
+func (evse *Evse) StopChargeContext(ctx context.Context, params types.ChargeStopParams) (types.ChargeStopResult, error) {
 	if !evse.IsOnline() {
 		return types.ChargeStopResult{ErrorReason: types.ChargeStopErrorEvseOffline}, types.EvseOfflineError{Evse: evse}
 	}
@@ -251,26 +478,26 @@ func (evse *Evse) StopCharge(params types.ChargeStopParams) (types.ChargeStopRes
 		return types.ChargeStopResult{ErrorReason: types.ChargeStopErrorEvseNotLoggedIn}, types.EvseNotLoggedInError{Evse: evse}
 	}
 
-	var payload [47]byte
+	payload := protocol.ChargeStopSchema.New(0)
 	if params.LineId == 0 {
-		payload[0] = 1
+		payload.SetUint("line_id", 1)
 	} else {
-		payload[0] = params.LineId
+		payload.SetUint("line_id", uint64(params.LineId))
 	}
-	WriteUserId(payload[1:17], params.UserId)
+	payload.SetString("user_id", string(params.UserId))
 
-	stopChargeDatagram := &Datagram{
-		Command: CmdChargeStop,
-		Payload: payload[:],
+	stopChargeDatagram := &protocol.Datagram{
+		Command: protocol.CmdChargeStop,
+		Payload: payload.Data,
 	}
-	sendErr := evse.SendDatagram(stopChargeDatagram)
+	sendErr := evse.SendDatagramContext(ctx, stopChargeDatagram)
 	if sendErr != nil {
 		return types.ChargeStopResult{
 			ErrorReason:  types.ChargeStopErrorSendFailed,
 			ErrorMessage: GetChargeStopErrorMessage(types.ChargeStopErrorSendFailed),
 		}, sendErr
 	}
-	response, recvErr := evse.WaitForDatagram(5*time.Second, CmdChargeStartResponse)
+	response, recvErr := evse.WaitForDatagramContext(ctx, evse.communicator.options.RequestTimeout, protocol.CmdChargeStopResponse)
 	if recvErr != nil {
 		return types.ChargeStopResult{
 			ErrorReason:  types.ChargeStopErrorNoConfirmation,
@@ -278,7 +505,8 @@ func (evse *Evse) StopCharge(params types.ChargeStopParams) (types.ChargeStopRes
 		}, recvErr
 	}
 
-	if CheckPayloadLength(response, evse, 5) {
+	responsePayload, ok := DecodePayload(evse, response, protocol.ChargeStopResponseSchema)
+	if !ok {
 		errorMessage := GetChargeStopErrorMessage(types.ChargeStopErrorNoConfirmation)
 		return types.ChargeStopResult{
 			ErrorReason:  types.ChargeStopErrorNoConfirmation,
@@ -286,7 +514,7 @@ func (evse *Evse) StopCharge(params types.ChargeStopParams) (types.ChargeStopRes
 		}, types.EvseInvalidDatagramError{Evse: evse, ResponseCommand: uint16(response.Command)}
 	}
 
-	errorReason := types.ChargeStopErrorReason(response.Payload[2])
+	errorReason := types.ChargeStopErrorReason(responsePayload.Byte("error_reason"))
 	if errorReason != types.ChargeStopOK {
 		errorMessage := GetChargeStopErrorMessage(errorReason)
 		return types.ChargeStopResult{
@@ -297,58 +525,65 @@ func (evse *Evse) StopCharge(params types.ChargeStopParams) (types.ChargeStopRes
 
 	return types.ChargeStopResult{
 		ErrorReason: types.ChargeStopOK,
-		LineId:      response.Payload[0],
+		LineId:      responsePayload.Byte("line_id"),
 	}, nil
 }
 
 func (evse *Evse) Login(password types.EmPassword) error {
+	return evse.LoginContext(context.Background(), password)
+}
+
+func (evse *Evse) LoginContext(ctx context.Context, password types.EmPassword) error {
 	if password == "" {
-		password = evse.password
+		password = evse.currentPassword()
 		if password == "" {
 			return types.EvseNoPasswordError{Evse: evse}
 		}
 	}
 
-	requestLoginDatagram := &Datagram{
-		Command:  CmdRequestLogin,
+	requestLoginDatagram := &protocol.Datagram{
+		Command:  protocol.CmdRequestLogin,
 		Password: password,
 		Payload:  []byte{0},
 	}
-	err := evse.SendDatagram(requestLoginDatagram)
+	err := evse.SendDatagramContext(ctx, requestLoginDatagram)
 	if err != nil {
 		return err
 	}
 
-	response, err := evse.WaitForDatagram(5*time.Second, CmdLoginResponse, CmdPasswordErrorResponse)
+	response, err := evse.WaitForDatagramContext(ctx, evse.communicator.options.RequestTimeout, protocol.CmdLoginResponse, protocol.CmdPasswordErrorResponse)
 	if err != nil {
 		return err
 	}
-	if response.Command == CmdPasswordErrorResponse {
+	if response.Command == protocol.CmdPasswordErrorResponse {
 		return types.EvseInvalidPasswordError{Evse: evse}
 	}
 
+	evse.mutex.Lock()
 	evse.password = password
+	evse.mutex.Unlock()
+	evse.communicator.passwordAccepted(evse, password)
 
-	loginConfirmDatagram := &Datagram{
-		Command: CmdLoginConfirm,
+	loginConfirmDatagram := &protocol.Datagram{
+		Command: protocol.CmdLoginConfirm,
 		Payload: []byte{0},
 	}
-	err = evse.SendDatagram(loginConfirmDatagram)
+	err = evse.SendDatagramContext(ctx, loginConfirmDatagram)
 	if err != nil {
 		return err
 	}
 
-	wasLoggedIn := evse.IsLoggedIn()
-	now := time.Now()
-	evse.LastActiveLogin = &now
-	if !wasLoggedIn {
-		evse.QueueEvent(types.EvseLoggedIn)
-	}
+	evse.markLoggedIn()
 
-	// Fetch info, charge and config asynchronously after login.
-	go func() { _ = evse.info.Fetch(0) }()
-	go func() { _ = evse.charge.Fetch(0) }()
-	go func() { _ = evse.config.Fetch(0) }()
+	// Fetch info, charge and config asynchronously after login, and check the EVSE's clock. The software version is
+	// only known once the info is fetched, so the profile is checked against the compatibility database after that.
+	go func() {
+		_ = evse.Info().Fetch(0)
+		evse.checkCompat()
+	}()
+	go func() { _ = evse.Charge().Fetch(0) }()
+	go func() { _ = evse.Config().Fetch(0) }()
+	go evse.checkClockDrift()
 
 	return nil
 }
@@ -357,10 +592,11 @@ func (evse *Evse) Login(password types.EmPassword) error {
 // keep trying to log in until successful or until the conditions are no longer met (e.g. EVSE goes offline or
 // an explicit UsePassword / Login call succeeds in logging in).
 func (evse *Evse) AutoLogin() {
-	if evse.password == "" || !evse.IsOnline() || evse.IsLoggedIn() {
+	password := evse.currentPassword()
+	if password == "" || !evse.IsOnline() || evse.IsLoggedIn() {
 		return
 	}
-	err := evse.Login(evse.password)
+	err := evse.Login(password)
 	if err == nil {
 		evse.communicator.Logger_.Debugf("[emproto4go] AutoLogin to EVSE %s successful", evse.Serial())
 		return
@@ -372,128 +608,150 @@ func (evse *Evse) AutoLogin() {
 	}()
 }
 
-func (evse *Evse) SendDatagram(datagram *Datagram) error {
+func (evse *Evse) SendDatagram(datagram *protocol.Datagram) error {
+	return evse.communicator.SendDatagram(evse, datagram)
+}
+
+// SendDatagramContext is like SendDatagram, but does not send anything if ctx is already done.
+func (evse *Evse) SendDatagramContext(ctx context.Context, datagram *protocol.Datagram) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	return evse.communicator.SendDatagram(evse, datagram)
 }
 
 // WaitForDatagram waits for a datagram with one of the specified commands to be received from the EVSE, and
 // returns the first matching datagram received. If no datagram is received within the specified timeout, or the
 // wait is interrupted (e.g. communicator is stopped), an error is returned.
-func (evse *Evse) WaitForDatagram(timeout time.Duration, commands ...EmCommand) (*Datagram, error) {
+func (evse *Evse) WaitForDatagram(timeout time.Duration, commands ...protocol.EmCommand) (*protocol.Datagram, error) {
+	return evse.WaitForDatagramContext(context.Background(), timeout, commands...)
+}
+
+// WaitForDatagramContext is like WaitForDatagram, but also returns (with the context's error) when ctx is done.
+func (evse *Evse) WaitForDatagramContext(ctx context.Context, timeout time.Duration, commands ...protocol.EmCommand) (*protocol.Datagram, error) {
 	if len(commands) == 0 {
 		return nil, nil
 	}
-	ch := make(chan *Datagram, 1)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	ch := make(chan *protocol.Datagram, 1)
 
 	evse.waitersMutex.Lock()
 	if evse.waiters == nil {
-		evse.waiters = make(map[EmCommand][]chan *Datagram)
+		evse.waiters = make(map[protocol.EmCommand][]chan *protocol.Datagram)
 	}
 	for _, cmd := range commands {
 		evse.waiters[cmd] = append(evse.waiters[cmd], ch)
 	}
 	evse.waitersMutex.Unlock()
 
-	// Listen for communicator stop
-	stopped := make(chan struct{})
-	go func() {
-		for {
-			if evse.communicator != nil && !evse.communicator.started {
-				close(stopped)
-				return
-			}
-			time.Sleep(50 * time.Millisecond)
-		}
-	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
 
 	select {
 	case d := <-ch:
+		// The datagram's command was already unregistered when it was delivered, but other commands may remain.
+		evse.removeWaiter(ch, commands)
 		return d, nil
-	case <-time.After(timeout):
-		// Cleanup waiter
-		evse.waitersMutex.Lock()
-		for _, cmd := range commands {
-			chs := evse.waiters[cmd]
-			for i, c := range chs {
-				if c == ch {
-					evse.waiters[cmd] = append(chs[:i], chs[i+1:]...)
-					break
-				}
-			}
-			if len(evse.waiters[cmd]) == 0 {
-				delete(evse.waiters, cmd)
-			}
-		}
-		evse.waitersMutex.Unlock()
+	case <-timer.C:
+		evse.removeWaiter(ch, commands)
 		return nil, fmt.Errorf("timeout waiting for datagram")
-	case <-stopped:
-		// Cleanup waiter
-		evse.waitersMutex.Lock()
-		for _, cmd := range commands {
-			chs := evse.waiters[cmd]
-			for i, c := range chs {
-				if c == ch {
-					evse.waiters[cmd] = append(chs[:i], chs[i+1:]...)
-					break
-				}
-			}
-			if len(evse.waiters[cmd]) == 0 {
-				delete(evse.waiters, cmd)
+	case <-ctx.Done():
+		evse.removeWaiter(ch, commands)
+		return nil, ctx.Err()
+	case <-evse.communicator.Done():
+		evse.removeWaiter(ch, commands)
+		return nil, fmt.Errorf("communicator stopped while waiting for datagram")
+	}
+}
+
+// removeWaiter unregisters a waiter channel that was registered for the given commands by WaitForDatagramContext.
+func (evse *Evse) removeWaiter(ch chan *protocol.Datagram, commands []protocol.EmCommand) {
+	evse.waitersMutex.Lock()
+	defer evse.waitersMutex.Unlock()
+
+	for _, cmd := range commands {
+		chs := evse.waiters[cmd]
+		for i, c := range chs {
+			if c == ch {
+				evse.waiters[cmd] = append(chs[:i], chs[i+1:]...)
+				break
 			}
 		}
-		evse.waitersMutex.Unlock()
-		return nil, fmt.Errorf("communicator stopped while waiting for datagram")
+		if len(evse.waiters[cmd]) == 0 {
+			delete(evse.waiters, cmd)
+		}
 	}
 }
 
 func (evse *Evse) Watch(eventTypes []types.EmEventType, channel chan<- types.EmEvent) types.EmEventWatcher {
-	return evse.communicator.WatchImpl(evse, eventTypes, channel)
+	return evse.communicator.WatchImpl(evse, eventTypes, channel, types.EmWatchOptions{})
+}
+
+func (evse *Evse) Subscribe(eventTypes []types.EmEventType, callback func(event types.EmEvent)) func() {
+	return evse.communicator.SubscribeImpl(evse, eventTypes, callback)
 }
 
-func (evse *Evse) QueueEvent(eventType types.EmEventType) {
-	evse.communicator.QueueEvent(evse, eventType)
+func (evse *Evse) WatchWithOptions(eventTypes []types.EmEventType, channel chan<- types.EmEvent, options types.EmWatchOptions) types.EmEventWatcher {
+	return evse.communicator.WatchImpl(evse, eventTypes, channel, options)
 }
 
-func (evse *Evse) DatagramReceived(datagram *Datagram, addr *net.Addr) {
+func (evse *Evse) QueueEvent(eventType types.EmEventType, changes ...types.EmFieldChange) {
+	evse.communicator.QueueEvent(evse, eventType, changes...)
+}
+
+func (evse *Evse) DatagramReceived(datagram *protocol.Datagram, addr *net.Addr) {
 	if datagram.Serial != evse.Serial() {
 		return
 	}
 	evse.communicator.Logger_.Tracef("[emproto4go] <- RECV %+v from %v", datagram, *addr)
 
-	wasOnline := evse.IsOnline()
 	now := time.Now()
-	evse.LastSeen = &now
-
-	if !wasOnline {
-		evse.QueueEvent(types.EvseOnline)
-	}
+	evse.mutex.Lock()
+	wasOnline := evse.isOnlineLocked()
+	evse.lastSeen = &now
+	evse.recordPayloadLengthLocked(datagram)
 
 	// Update addr (ip).
+	var addrChanges []types.EmFieldChange
 	if udpAddr, ok := (*addr).(*net.UDPAddr); ok {
-		infoChanged := false
 		if !evse.ip.Equal(udpAddr.IP) {
+			addrChanges = append(addrChanges, types.EmFieldChange{Field: "IP", Old: evse.ip, New: udpAddr.IP})
 			evse.ip = udpAddr.IP
-			infoChanged = true
 		}
 		if evse.port != udpAddr.Port {
+			addrChanges = append(addrChanges, types.EmFieldChange{Field: "Port", Old: evse.port, New: udpAddr.Port})
 			evse.port = udpAddr.Port
-			infoChanged = true
-		}
-		if infoChanged {
-			evse.QueueEvent(types.EvseInfoUpdated)
 		}
 	}
+	hasPassword := evse.password != ""
+	evse.mutex.Unlock()
+
+	if !wasOnline {
+		evse.QueueEvent(types.EvseOnline)
+	}
+	if len(addrChanges) > 0 {
+		evse.QueueEvent(types.EvseInfoUpdated, addrChanges...)
+	}
 
 	// If we have a password, then start a login flow asynchronously.
-	if !wasOnline && evse.password != "" {
+	if !wasOnline && hasPassword {
 		go evse.AutoLogin()
 	}
 
 	// Further processing of commands by handlers.
-	if HandlerDelegator.Handle(evse, datagram) == 0 {
+	handled := evse.communicator.handlers.Handle(evse, datagram) > 0
+	if !handled {
 		evse.communicator.Logger_.Debugf("[emproto4go] No handler for command %s from EVSE %s", datagram.Command, evse.Serial())
 	}
 
+	// When replaying a capture, the communicator doesn't log in itself. The EVSE only sends a login response for the
+	// right password, so that counts as the login of the recorded app.
+	if evse.communicator.options.ReplayLogins && datagram.Command == protocol.CmdLoginResponse {
+		evse.markLoggedIn()
+	}
+
 	// Unblock all waiters for this command
 	evse.waitersMutex.Lock()
 	if evse.waiters != nil {
@@ -505,31 +763,47 @@ func (evse *Evse) DatagramReceived(datagram *Datagram, addr *net.Addr) {
 			}
 		}
 		if len(chs) > 0 {
+			handled = true
 			delete(evse.waiters, datagram.Command)
 		}
 	}
 	evse.waitersMutex.Unlock()
+
+	if !handled {
+		evse.communicator.diagnostics.ObserveDatagram(evse.Serial(), datagram)
+	}
 }
 
 func (evse *Evse) Tick() {
-	if !evse.IsLoggedIn() && evse.LastActiveLogin != nil {
-		evse.LastActiveLogin = nil
-		evse.QueueEvent(types.EvseLoggedOut)
+	evse.mutex.Lock()
+	loggedOut := !evse.isLoggedInLocked() && evse.lastActiveLogin != nil
+	if loggedOut {
+		evse.lastActiveLogin = nil
+	}
+	offline := !evse.isOnlineLocked() && evse.lastSeen != nil
+	if offline {
+		evse.lastSeen = nil
 	}
+	loggedIn := evse.isLoggedInLocked()
+	evse.mutex.Unlock()
 
-	if !evse.IsOnline() && evse.LastSeen != nil {
-		evse.LastSeen = nil
+	if loggedOut {
+		evse.QueueEvent(types.EvseLoggedOut)
+	}
+	if offline {
 		evse.QueueEvent(types.EvseOffline)
 	}
 
-	if evse.IsLoggedIn() {
-		go func() { _ = evse.MutableCharge().Fetch(30 * time.Second) }()
-		go func() { _ = evse.MutableInfo().Fetch(4 * time.Minute) }()
-		go func() { _ = evse.MutableConfig().Fetch(3 * time.Minute) }()
+	if loggedIn {
+		go func() { _ = evse.Charge().Fetch(30 * time.Second) }()
+		go func() { _ = evse.Info().Fetch(4 * time.Minute) }()
+		go func() { _ = evse.Config().Fetch(3 * time.Minute) }()
 	}
 }
 
 func (evse *Evse) String() string {
+	evse.mutex.RLock()
+	defer evse.mutex.RUnlock()
 	return fmt.Sprintf("Evse{Label: %s, Serial: %s, MetaState: %v}",
-		evse.Label(), evse.Serial(), evse.MetaState())
+		evse.labelLocked(), evse.Serial(), evse.metaStateLocked())
 }
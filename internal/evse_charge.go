@@ -1,8 +1,10 @@
 package internal
 
 import (
+	"context"
 	"time"
 
+	"github.com/johnwoo-nl/emproto4go/protocol"
 	"github.com/johnwoo-nl/emproto4go/types"
 )
 
@@ -103,18 +105,25 @@ func (charge EvseCharge) ChargeFee() float32 {
 }
 
 func (charge EvseCharge) Fetch(maxAge time.Duration) error {
-	if charge.LastFetched != nil && time.Since(*charge.LastFetched) < maxAge {
+	return charge.FetchContext(context.Background(), maxAge)
+}
+
+func (charge EvseCharge) FetchContext(ctx context.Context, maxAge time.Duration) error {
+	charge.evse.mutex.RLock()
+	lastFetched := charge.evse.charge.LastFetched
+	charge.evse.mutex.RUnlock()
+	if lastFetched != nil && time.Since(*lastFetched) < maxAge {
 		return nil
 	}
 	if !charge.evse.IsLoggedIn() {
 		return types.EvseNotLoggedInError{Evse: charge.evse}
 	}
 
-	datagram := Datagram{Command: CmdRequestSingleACCharging, Payload: []byte{0x00}}
-	if sendErr := charge.evse.SendDatagram(&datagram); sendErr != nil {
+	datagram := protocol.Datagram{Command: protocol.CmdRequestSingleACCharging, Payload: []byte{0x00}}
+	if sendErr := charge.evse.SendDatagramContext(ctx, &datagram); sendErr != nil {
 		return sendErr
 	}
-	_, recvErr := charge.evse.WaitForDatagram(5*time.Second, CmdSingleACChargingStatusResponse)
+	_, recvErr := charge.evse.WaitForDatagramContext(ctx, charge.evse.communicator.options.RequestTimeout, protocol.CmdSingleACChargingStatusResponse)
 	if recvErr != nil {
 		charge.evse.communicator.Logger_.Warnf("[emproto4go] Failed to fetch charge data for EVSE %s: %v.", charge.evse.Serial(), recvErr)
 	}
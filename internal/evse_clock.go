@@ -0,0 +1,115 @@
+package internal
+
+import (
+	"context"
+	"time"
+
+	"github.com/johnwoo-nl/emproto4go/protocol"
+	"github.com/johnwoo-nl/emproto4go/types"
+)
+
+func (evse *Evse) GetSystemTime() (time.Time, error) {
+	return evse.GetSystemTimeContext(context.Background())
+}
+
+func (evse *Evse) GetSystemTimeContext(ctx context.Context) (time.Time, error) {
+	payload := protocol.SystemTimeSchema.New(0)
+	payload.SetUint("action", uint64(protocol.ConfigGet))
+	evseTime, err := evse.systemTime(ctx, payload)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if evseTime == nil {
+		return time.Time{}, types.EvseInvalidDatagramError{Evse: evse, ResponseCommand: uint16(protocol.CmdSetAndGetSystemTimeResponse)}
+	}
+	return *evseTime, nil
+}
+
+func (evse *Evse) SetSystemTime(t time.Time) error {
+	return evse.SetSystemTimeContext(context.Background(), t)
+}
+
+func (evse *Evse) SetSystemTimeContext(ctx context.Context, t time.Time) error {
+	payload := protocol.SystemTimeSchema.New(0)
+	payload.Clock = evse.Clock()
+	payload.SetUint("action", uint64(protocol.ConfigSet))
+	payload.SetTime("time", &t)
+	_, err := evse.systemTime(ctx, payload)
+	return err
+}
+
+func (evse *Evse) ClockDrift() *time.Duration {
+	evse.mutex.RLock()
+	defer evse.mutex.RUnlock()
+	return evse.clockDrift
+}
+
+// systemTime sends a CmdSetAndGetSystemTime request and returns the EVSE's time from the response, if any. The clock
+// drift is updated from it.
+func (evse *Evse) systemTime(ctx context.Context, payload protocol.Payload) (*time.Time, error) {
+	if !evse.communicator.options.ExperimentalCommands {
+		return nil, types.ExperimentalCommandsDisabledError{Evse: evse, Command: uint16(protocol.CmdSetAndGetSystemTime)}
+	}
+	if !evse.IsLoggedIn() {
+		return nil, types.EvseNotLoggedInError{Evse: evse}
+	}
+	sent := time.Now()
+	datagram := protocol.Datagram{Command: protocol.CmdSetAndGetSystemTime, Payload: payload.Data}
+	if sendErr := evse.SendDatagramContext(ctx, &datagram); sendErr != nil {
+		return nil, sendErr
+	}
+	response, recvErr := evse.WaitForDatagramContext(ctx, evse.communicator.options.RequestTimeout, protocol.CmdSetAndGetSystemTimeResponse)
+	if recvErr != nil {
+		return nil, recvErr
+	}
+	received := time.Now()
+
+	responsePayload, ok := DecodePayload(evse, response, protocol.SystemTimeSchema)
+	if !ok {
+		return nil, types.EvseInvalidDatagramError{Evse: evse, ResponseCommand: uint16(response.Command)}
+	}
+	evseTime := responsePayload.Time("time")
+	if evseTime == nil {
+		return nil, nil
+	}
+
+	// EVSE timestamps are truncated to the second, so compare the middle of that second with the host's time halfway
+	// the request. Drift within a second is not measurable.
+	hostTime := sent.Add(received.Sub(sent) / 2)
+	drift := evseTime.Add(time.Second / 2).Sub(hostTime).Round(time.Second)
+	evse.mutex.Lock()
+	evse.clockDrift = &drift
+	evse.mutex.Unlock()
+	return evseTime, nil
+}
+
+// checkClockDrift compares the EVSE's clock with the host's after logging in. If it drifts more than the threshold of
+// ClockDriftThreshold, an EvseClockDrift event is queued, and the EVSE's time is set if ClockAutoSync is enabled. The
+// system time command is experimental, so nothing is checked without ExperimentalCommands.
+func (evse *Evse) checkClockDrift() {
+	options := evse.communicator.options
+	if options.ClockDriftThreshold <= 0 || !options.ExperimentalCommands {
+		return
+	}
+	logger := evse.communicator.Logger_
+	if _, err := evse.GetSystemTime(); err != nil {
+		// Not all EVSEs may support this command, so this is not worth a warning.
+		logger.Debugf("[emproto4go] Failed to get system time of EVSE %s: %v", evse.Serial(), err)
+		return
+	}
+	drift := evse.ClockDrift()
+	if drift.Abs() <= options.ClockDriftThreshold {
+		return
+	}
+
+	logger.Infof("[emproto4go] Clock of EVSE %s drifts %v from the host's clock.", evse.Serial(), *drift)
+	if options.ClockAutoSync {
+		// Read the time back, as the response to a set may not include it.
+		if err := evse.SetSystemTime(time.Now().Round(time.Second)); err != nil {
+			logger.Warnf("[emproto4go] Failed to set system time of EVSE %s: %v", evse.Serial(), err)
+		} else if _, err := evse.GetSystemTime(); err != nil {
+			logger.Warnf("[emproto4go] Failed to get system time of EVSE %s after setting it: %v", evse.Serial(), err)
+		}
+	}
+	evse.QueueEvent(types.EvseClockDrift, types.EmFieldChange{Field: "ClockDrift", Old: drift, New: evse.ClockDrift()})
+}
@@ -1,8 +1,10 @@
 package internal
 
 import (
+	"context"
 	"time"
 
+	"github.com/johnwoo-nl/emproto4go/protocol"
 	"github.com/johnwoo-nl/emproto4go/types"
 )
 
@@ -13,7 +15,7 @@ type EvseConfig struct {
 	Name_            string
 	Language_        types.EmLanguage
 	TemperatureUnit_ types.EmTemperatureUnit
-	OfflineCharge_   bool
+	OfflineCharge_   bool `getter:"CanOfflineCharge"`
 	MaxCurrent_      types.Amps
 }
 
@@ -43,7 +45,14 @@ func (config *EvseConfig) MaxCurrent() types.Amps {
 }
 
 func (config *EvseConfig) Fetch(maxAge time.Duration) error {
-	if config.LastFetched != nil && time.Since(*config.LastFetched) < maxAge {
+	return config.FetchContext(context.Background(), maxAge)
+}
+
+func (config *EvseConfig) FetchContext(ctx context.Context, maxAge time.Duration) error {
+	config.evse.mutex.RLock()
+	lastFetched := config.evse.config.LastFetched
+	config.evse.mutex.RUnlock()
+	if lastFetched != nil && time.Since(*lastFetched) < maxAge {
 		return nil
 	}
 	if !config.evse.IsLoggedIn() {
@@ -54,11 +63,11 @@ func (config *EvseConfig) Fetch(maxAge time.Duration) error {
 
 	// Send out the GET requests and wait for responses in parallel.
 	// Note that we don't actually process the incoming config values here; they are handled by ConfigHandler.
-	go func() { resultsChan <- config.get("Name", CmdSetAndGetName, 32) }()
-	go func() { resultsChan <- config.get("Language", CmdSetAndGetLanguage, 1) }()
-	go func() { resultsChan <- config.get("TemperatureUnit", CmdSetAndGetTemperatureUnit, 1) }()
-	go func() { resultsChan <- config.get("OfflineCharge", CmdSetAndGetOfflineCharge, 1) }()
-	go func() { resultsChan <- config.get("MaxCurrent", CmdSetAndGetMaxCurrent, 1) }()
+	go func() { resultsChan <- config.get(ctx, "Name", protocol.CmdSetAndGetName, 32) }()
+	go func() { resultsChan <- config.get(ctx, "Language", protocol.CmdSetAndGetLanguage, 1) }()
+	go func() { resultsChan <- config.get(ctx, "TemperatureUnit", protocol.CmdSetAndGetTemperatureUnit, 1) }()
+	go func() { resultsChan <- config.get(ctx, "OfflineCharge", protocol.CmdSetAndGetOfflineCharge, 1) }()
+	go func() { resultsChan <- config.get(ctx, "MaxCurrent", protocol.CmdSetAndGetMaxCurrent, 1) }()
 
 	// Wait for all fetches to complete (or timeout), and collect errors with field names.
 	var failed []string
@@ -70,9 +79,15 @@ func (config *EvseConfig) Fetch(maxAge time.Duration) error {
 		}
 	}
 
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	if len(failed) == 0 {
 		now := time.Now()
-		config.LastFetched = &now
+		config.evse.UpdateConfig(func(config *EvseConfig) bool {
+			config.LastFetched = &now
+			return false
+		})
 		return nil
 	}
 
@@ -80,6 +95,10 @@ func (config *EvseConfig) Fetch(maxAge time.Duration) error {
 }
 
 func (config *EvseConfig) SetName(name string) error {
+	return config.SetNameContext(context.Background(), name)
+}
+
+func (config *EvseConfig) SetNameContext(ctx context.Context, name string) error {
 	// Ensure name contains only ASCII characters and is at most 11 bytes
 	asciiName := make([]byte, 0, 11)
 	for i := 0; i < len(name) && len(asciiName) < 11; i++ {
@@ -91,81 +110,102 @@ func (config *EvseConfig) SetName(name string) error {
 	copy(value[:4], []byte{'A', 'C', 'P', '#'})
 	copy(value[4:], asciiName)
 
-	if err := config.set(CmdSetAndGetName, value); err != nil {
+	if err := config.set(ctx, protocol.CmdSetAndGetName, value); err != nil {
 		return err
 	}
-	config.Name_ = string(asciiName)
-	config.evse.QueueEvent(types.EvseConfigUpdated)
+	config.evse.UpdateConfig(func(config *EvseConfig) bool {
+		return CompareAndSet(&config.Name_, string(asciiName))
+	})
 	return nil
 }
 
 func (config *EvseConfig) SetLanguage(language types.EmLanguage) error {
-	if err := config.set(CmdSetAndGetLanguage, []byte{byte(language)}); err != nil {
+	return config.SetLanguageContext(context.Background(), language)
+}
+
+func (config *EvseConfig) SetLanguageContext(ctx context.Context, language types.EmLanguage) error {
+	if err := config.set(ctx, protocol.CmdSetAndGetLanguage, []byte{byte(language)}); err != nil {
 		return err
 	}
-	config.Language_ = language
-	config.evse.QueueEvent(types.EvseConfigUpdated)
+	config.evse.UpdateConfig(func(config *EvseConfig) bool {
+		return CompareAndSet(&config.Language_, language)
+	})
 	return nil
 }
 
 func (config *EvseConfig) SetTemperatureUnit(unit types.EmTemperatureUnit) error {
-	if err := config.set(CmdSetAndGetTemperatureUnit, []byte{byte(unit)}); err != nil {
+	return config.SetTemperatureUnitContext(context.Background(), unit)
+}
+
+func (config *EvseConfig) SetTemperatureUnitContext(ctx context.Context, unit types.EmTemperatureUnit) error {
+	if err := config.set(ctx, protocol.CmdSetAndGetTemperatureUnit, []byte{byte(unit)}); err != nil {
 		return err
 	}
-	config.TemperatureUnit_ = unit
-	config.evse.QueueEvent(types.EvseConfigUpdated)
+	config.evse.UpdateConfig(func(config *EvseConfig) bool {
+		return CompareAndSet(&config.TemperatureUnit_, unit)
+	})
 	return nil
 }
 
 func (config *EvseConfig) SetOfflineCharge(offlineCharge bool) error {
+	return config.SetOfflineChargeContext(context.Background(), offlineCharge)
+}
+
+func (config *EvseConfig) SetOfflineChargeContext(ctx context.Context, offlineCharge bool) error {
 	offlineChargeByte := byte(1)
 	if offlineCharge {
 		offlineChargeByte = byte(0)
 	}
-	if err := config.set(CmdSetAndGetOfflineCharge, []byte{offlineChargeByte}); err != nil {
+	if err := config.set(ctx, protocol.CmdSetAndGetOfflineCharge, []byte{offlineChargeByte}); err != nil {
 		return err
 	}
-	config.OfflineCharge_ = offlineCharge
-	config.evse.QueueEvent(types.EvseConfigUpdated)
+	config.evse.UpdateConfig(func(config *EvseConfig) bool {
+		return CompareAndSet(&config.OfflineCharge_, offlineCharge)
+	})
 	return nil
 }
 
 func (config *EvseConfig) SetMaxCurrent(maxCurrent types.Amps) error {
-	if err := config.set(CmdSetAndGetMaxCurrent, []byte{byte(maxCurrent)}); err != nil {
+	return config.SetMaxCurrentContext(context.Background(), maxCurrent)
+}
+
+func (config *EvseConfig) SetMaxCurrentContext(ctx context.Context, maxCurrent types.Amps) error {
+	if err := config.set(ctx, protocol.CmdSetAndGetMaxCurrent, []byte{byte(maxCurrent)}); err != nil {
 		return err
 	}
-	config.MaxCurrent_ = maxCurrent
-	config.evse.QueueEvent(types.EvseConfigUpdated)
+	config.evse.UpdateConfig(func(config *EvseConfig) bool {
+		return CompareAndSet(&config.MaxCurrent_, maxCurrent)
+	})
 	return nil
 }
 
-func (config *EvseConfig) get(name string, command EmCommand, valueLen uint) result {
+func (config *EvseConfig) get(ctx context.Context, name string, command protocol.EmCommand, valueLen uint) result {
 	if !config.evse.IsLoggedIn() {
 		return result{name: name, err: types.EvseNotLoggedInError{Evse: config.evse}}
 	}
 	payload := make([]byte, 1+valueLen)
 	payload[0] = 0x02 // GET the config item; all share the same GET=2/SET=1 value.
-	datagram := Datagram{Command: command, Payload: payload}
-	if sendErr := config.evse.SendDatagram(&datagram); sendErr != nil {
+	datagram := protocol.Datagram{Command: command, Payload: payload}
+	if sendErr := config.evse.SendDatagramContext(ctx, &datagram); sendErr != nil {
 		return result{name: name, err: sendErr}
 	}
 	responseCommand := command - 0x8000
-	_, recvErr := config.evse.WaitForDatagram(8*time.Second, responseCommand)
+	_, recvErr := config.evse.WaitForDatagramContext(ctx, config.evse.communicator.options.ConfigRequestTimeout, responseCommand)
 	return result{name: name, err: recvErr}
 }
 
-func (config *EvseConfig) set(command EmCommand, value []byte) error {
+func (config *EvseConfig) set(ctx context.Context, command protocol.EmCommand, value []byte) error {
 	if !config.evse.IsLoggedIn() {
 		return types.EvseNotLoggedInError{Evse: config.evse}
 	}
 	payload := make([]byte, 1+len(value))
 	payload[0] = 0x01 // SET the config item; all share the same GET=2/SET=1 value.
 	copy(payload[1:], value)
-	datagram := Datagram{Command: command, Payload: payload}
-	if sendErr := config.evse.SendDatagram(&datagram); sendErr != nil {
+	datagram := protocol.Datagram{Command: command, Payload: payload}
+	if sendErr := config.evse.SendDatagramContext(ctx, &datagram); sendErr != nil {
 		return sendErr
 	}
 	responseCommand := command - 0x8000
-	_, recvErr := config.evse.WaitForDatagram(5*time.Second, responseCommand)
+	_, recvErr := config.evse.WaitForDatagramContext(ctx, config.evse.communicator.options.RequestTimeout, responseCommand)
 	return recvErr
 }
@@ -1,8 +1,10 @@
 package internal
 
 import (
+	"context"
 	"time"
 
+	"github.com/johnwoo-nl/emproto4go/protocol"
 	"github.com/johnwoo-nl/emproto4go/types"
 )
 
@@ -52,7 +54,7 @@ func (info EvseInfo) Phases() types.EmPhases {
 }
 
 func (info EvseInfo) CanForceSinglePhase() bool {
-	return info.EvseType_ >= 22 && info.EvseType_ <= 25 && info.Byte70_ < 11
+	return CapabilitiesOf(info, false).Has(types.CapabilityForceSinglePhase)
 }
 
 func (info EvseInfo) MaxPower() types.Watts {
@@ -76,18 +78,25 @@ func (info EvseInfo) Byte70() byte {
 }
 
 func (info EvseInfo) Fetch(maxAge time.Duration) error {
-	if info.LastFetched != nil && time.Since(*info.LastFetched) < maxAge {
+	return info.FetchContext(context.Background(), maxAge)
+}
+
+func (info EvseInfo) FetchContext(ctx context.Context, maxAge time.Duration) error {
+	info.evse.mutex.RLock()
+	lastFetched := info.evse.info.LastFetched
+	info.evse.mutex.RUnlock()
+	if lastFetched != nil && time.Since(*lastFetched) < maxAge {
 		return nil
 	}
 	if !info.evse.IsLoggedIn() {
 		return types.EvseNotLoggedInError{Evse: info.evse}
 	}
 
-	datagram := Datagram{Command: CmdGetVersion, Payload: []byte{}}
-	if sendErr := info.evse.SendDatagram(&datagram); sendErr != nil {
+	datagram := protocol.Datagram{Command: protocol.CmdGetVersion, Payload: []byte{}}
+	if sendErr := info.evse.SendDatagramContext(ctx, &datagram); sendErr != nil {
 		return sendErr
 	}
-	_, recvErr := info.evse.WaitForDatagram(5*time.Second, CmdGetVersionResponse)
+	_, recvErr := info.evse.WaitForDatagramContext(ctx, info.evse.communicator.options.RequestTimeout, protocol.CmdGetVersionResponse)
 	if recvErr != nil {
 		info.evse.communicator.Logger_.Warnf("[emproto4go] Failed to fetch info for EVSE %s: %v.", info.evse.Serial(), recvErr)
 	}
@@ -0,0 +1,41 @@
+package internal
+
+import (
+	"context"
+
+	"github.com/johnwoo-nl/emproto4go/protocol"
+	"github.com/johnwoo-nl/emproto4go/types"
+)
+
+func (evse *Evse) SendRaw(command uint16, payload []byte, expect ...uint16) ([]byte, error) {
+	return evse.SendRawContext(context.Background(), command, payload, expect...)
+}
+
+func (evse *Evse) SendRawContext(ctx context.Context, command uint16, payload []byte, expect ...uint16) ([]byte, error) {
+	if !evse.communicator.options.RawCommands {
+		return nil, types.RawCommandsDisabledError{Evse: evse}
+	}
+
+	// Sent like any other request, so the EVSE must be online and logged in, and the datagram gets its password.
+	rawDatagram := &protocol.Datagram{
+		Command: protocol.EmCommand(command),
+		Payload: append([]byte{}, payload...),
+	}
+	sendErr := evse.SendDatagramContext(ctx, rawDatagram)
+	if sendErr != nil {
+		return nil, sendErr
+	}
+	if len(expect) == 0 {
+		return nil, nil
+	}
+
+	responseCommands := make([]protocol.EmCommand, len(expect))
+	for i, responseCommand := range expect {
+		responseCommands[i] = protocol.EmCommand(responseCommand)
+	}
+	response, recvErr := evse.WaitForDatagramContext(ctx, evse.communicator.options.RequestTimeout, responseCommands...)
+	if recvErr != nil {
+		return nil, recvErr
+	}
+	return response.Payload, nil
+}
@@ -1,6 +1,8 @@
 package internal
 
 import (
+	"slices"
+
 	"github.com/johnwoo-nl/emproto4go/types"
 )
 
@@ -21,7 +23,13 @@ type EvseState struct {
 	GunState_          types.EmGunState
 	OutputState_       types.EmOutputState
 	Errors_            []types.EmError
-	NewProtocol_       bool
+	NewProtocol_       bool `getter:"IsNewProtocol"`
+}
+
+// clone returns a copy of the state that doesn't share the Errors_ slice.
+func (evse EvseState) clone() EvseState {
+	evse.Errors_ = slices.Clone(evse.Errors_)
+	return evse
 }
 
 func (evse EvseState) LineId() types.LineId {
@@ -0,0 +1,146 @@
+package internal_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/johnwoo-nl/emproto4go/emprototest/sim"
+	"github.com/johnwoo-nl/emproto4go/internal"
+	"github.com/johnwoo-nl/emproto4go/types"
+)
+
+// TestWaitForDatagramHoldsNoGoroutines checks that requests don't leave goroutines behind once they return, whether
+// they got a response, timed out or were cancelled.
+func TestWaitForDatagramHoldsNoGoroutines(t *testing.T) {
+	options := internal.DefaultCommunicatorOptions()
+	options.TickInterval = time.Hour // No background fetches while counting goroutines.
+	s := startSimulated(t, options, sim.ChargerConfig{})
+	s.login(t)
+
+	// Let the fetches after login settle, then take the baseline.
+	time.Sleep(300 * time.Millisecond)
+	baseline := runtime.NumGoroutine()
+
+	const requests = 3000
+	for i := 0; i < requests; i++ {
+		if err := s.evse.Info().FetchContext(context.Background(), 0); err != nil {
+			t.Fatalf("fetch %d: %v", i, err)
+		}
+	}
+
+	// Waits that end without a response must clean up as well.
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	for i := 0; i < requests/10; i++ {
+		_ = s.evse.Info().FetchContext(cancelled, 0)
+	}
+	for i := 0; i < 10; i++ {
+		_, _ = s.evse.WaitForDatagram(time.Millisecond, 0x0FFF)
+	}
+
+	// Allow some slack for goroutines of the runtime and the simulator that come and go.
+	var count int
+	for range 50 {
+		runtime.GC()
+		if count = runtime.NumGoroutine(); count <= baseline+5 {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("goroutine count grew from %d to %d over %d requests", baseline, count, requests)
+}
+
+func TestChangePassword(t *testing.T) {
+	tests := []struct {
+		name          string
+		fixedPassword bool
+		experimental  bool
+		expectedErr   error
+		expected      types.EmPassword
+	}{
+		{"changed", false, true, nil, "654321"},
+		{"not changed", true, true, types.EvsePasswordNotChangedError{}, testPassword},
+		{"experimental commands disabled", false, false, types.ExperimentalCommandsDisabledError{}, testPassword},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := &countingStore{}
+			options := internal.DefaultCommunicatorOptions()
+			options.Store = store
+			options.DebounceWindow = 0
+			options.ExperimentalCommands = test.experimental
+			s := startSimulated(t, options, sim.ChargerConfig{FixedPassword: test.fixedPassword})
+			s.login(t)
+			waitFor(t, "password stored", func() bool {
+				_, record := store.state()
+				return record.Password == testPassword
+			})
+
+			err := s.evse.ChangePassword(testPassword, "654321")
+			if test.expectedErr == nil && err != nil {
+				t.Fatalf("ChangePassword: %v", err)
+			}
+			if test.expectedErr != nil && reflect.TypeOf(err) != reflect.TypeOf(test.expectedErr) {
+				t.Fatalf("ChangePassword returned %v, expected a %T", err, test.expectedErr)
+			}
+
+			if password := s.charger.Password(); password != test.expected {
+				t.Errorf("charger password is %s, expected %s", password, test.expected)
+			}
+			if _, record := store.state(); record.Password != test.expected {
+				t.Errorf("stored password is %s, expected %s", record.Password, test.expected)
+			}
+			waitFor(t, "logged in", s.evse.IsLoggedIn)
+		})
+	}
+}
+
+// TestSystemTime checks that the experimental system time command is only sent when enabled, and that the clock check
+// after logging in then syncs a drifting clock.
+func TestSystemTime(t *testing.T) {
+	t.Run("disabled", func(t *testing.T) {
+		options := internal.DefaultCommunicatorOptions()
+		options.ClockDriftThreshold = time.Minute
+		options.ClockAutoSync = true
+		s := startSimulated(t, options, sim.ChargerConfig{ClockOffset: -time.Hour})
+		s.login(t)
+		waitFor(t, "logged in", s.evse.IsLoggedIn)
+
+		var disabled types.ExperimentalCommandsDisabledError
+		if _, err := s.evse.GetSystemTime(); !errors.As(err, &disabled) {
+			t.Errorf("GetSystemTime returned %v, expected an ExperimentalCommandsDisabledError", err)
+		}
+		if err := s.evse.SetSystemTime(time.Now()); !errors.As(err, &disabled) {
+			t.Errorf("SetSystemTime returned %v, expected an ExperimentalCommandsDisabledError", err)
+		}
+		time.Sleep(200 * time.Millisecond)
+		if offset := s.charger.ClockOffset(); offset != -time.Hour {
+			t.Errorf("charger clock offset is %v, expected it unchanged", offset)
+		}
+		if drift := s.evse.ClockDrift(); drift != nil {
+			t.Errorf("clock drift is %v, expected it not measured", *drift)
+		}
+	})
+
+	t.Run("auto sync", func(t *testing.T) {
+		options := internal.DefaultCommunicatorOptions()
+		options.ExperimentalCommands = true
+		options.ClockDriftThreshold = time.Minute
+		options.ClockAutoSync = true
+		s := startSimulated(t, options, sim.ChargerConfig{ClockOffset: -time.Hour})
+		s.login(t)
+
+		waitFor(t, "clock synced", func() bool { return s.charger.ClockOffset().Abs() <= time.Second })
+		waitFor(t, "clock drift measured", func() bool {
+			drift := s.evse.ClockDrift()
+			return drift != nil && drift.Abs() <= time.Second
+		})
+		if _, err := s.evse.GetSystemTime(); err != nil {
+			t.Errorf("GetSystemTime: %v", err)
+		}
+	})
+}
@@ -0,0 +1,137 @@
+package internal
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/johnwoo-nl/emproto4go/protocol"
+	"github.com/johnwoo-nl/emproto4go/types"
+)
+
+// HandlerRegistry holds the handlers of a communicator, in the order in which they run: by priority (lowest first),
+// then by order of registration.
+type HandlerRegistry struct {
+	mutex    sync.RWMutex
+	handlers []registeredHandler
+	sequence uint64
+}
+
+type registeredHandler struct {
+	name     string
+	priority int
+	sequence uint64
+	handler  Handler
+}
+
+// Register registers a handler under the given name. If a handler with that name exists, it is replaced, keeping
+// its place among handlers of the same priority.
+func (registry *HandlerRegistry) Register(name string, priority int, handler Handler) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	// Handlers are copied on write, so Handle can iterate over a snapshot without holding the lock.
+	handlers := slices.Clone(registry.handlers)
+	registered := registeredHandler{name: name, priority: priority, handler: handler}
+	if index := registry.indexLocked(name); index >= 0 {
+		registered.sequence = handlers[index].sequence
+		handlers = slices.Delete(handlers, index, index+1)
+	} else {
+		registry.sequence++
+		registered.sequence = registry.sequence
+	}
+	handlers = append(handlers, registered)
+	slices.SortStableFunc(handlers, func(a, b registeredHandler) int {
+		return cmp.Or(cmp.Compare(a.priority, b.priority), cmp.Compare(a.sequence, b.sequence))
+	})
+	registry.handlers = handlers
+}
+
+// Unregister removes the handler with the given name. Returns false if there is no such handler.
+func (registry *HandlerRegistry) Unregister(name string) bool {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	index := registry.indexLocked(name)
+	if index < 0 {
+		return false
+	}
+	registry.handlers = slices.Delete(slices.Clone(registry.handlers), index, index+1)
+	return true
+}
+
+// Names returns the names of the registered handlers, in the order in which they run.
+func (registry *HandlerRegistry) Names() []string {
+	registry.mutex.RLock()
+	defer registry.mutex.RUnlock()
+
+	names := make([]string, len(registry.handlers))
+	for i, registered := range registry.handlers {
+		names[i] = registered.name
+	}
+	return names
+}
+
+// Handle passes the datagram to all handlers for its command. Returns the number of handlers invoked.
+func (registry *HandlerRegistry) Handle(evse *Evse, datagram *protocol.Datagram) uint {
+	registry.mutex.RLock()
+	handlers := registry.handlers
+	registry.mutex.RUnlock()
+
+	handlersInvoked := uint(0)
+	for _, registered := range handlers {
+		if slices.Contains(registered.handler.Handles(), datagram.Command) {
+			handlersInvoked++
+			registered.handler.Handle(evse, datagram)
+		}
+	}
+	return handlersInvoked
+}
+
+func (registry *HandlerRegistry) indexLocked(name string) int {
+	return slices.IndexFunc(registry.handlers, func(registered registeredHandler) bool {
+		return registered.name == name
+	})
+}
+
+// publicHandler adapts a types.EmHandler registered by an application to Handler.
+type publicHandler struct {
+	handler types.EmHandler
+}
+
+func (handler publicHandler) Handles() []protocol.EmCommand {
+	commands := handler.handler.Commands()
+	handles := make([]protocol.EmCommand, len(commands))
+	for i, command := range commands {
+		handles[i] = protocol.EmCommand(command)
+	}
+	return handles
+}
+
+func (handler publicHandler) Handle(evse *Evse, datagram *protocol.Datagram) {
+	handler.handler.Handle(evse, uint16(datagram.Command), datagram.Payload)
+}
+
+func (communicator *Communicator) Handlers() *HandlerRegistry {
+	return &communicator.handlers
+}
+
+func (communicator *Communicator) RegisterHandler(name string, priority int, handler types.EmHandler) error {
+	if name == "" {
+		return fmt.Errorf("handler name must not be empty")
+	}
+	if handler == nil {
+		return fmt.Errorf("handler %s must not be nil", name)
+	}
+	communicator.handlers.Register(name, priority, publicHandler{handler: handler})
+	return nil
+}
+
+func (communicator *Communicator) UnregisterHandler(name string) bool {
+	return communicator.handlers.Unregister(name)
+}
+
+func (communicator *Communicator) HandlerNames() []string {
+	return communicator.handlers.Names()
+}
@@ -0,0 +1,17 @@
+package handlers
+
+import (
+	impl "github.com/johnwoo-nl/emproto4go/internal"
+	"github.com/johnwoo-nl/emproto4go/types"
+)
+
+// RegisterBuiltin registers the built-in handlers with a communicator's registry, all with priority 0.
+func RegisterBuiltin(registry *impl.HandlerRegistry) {
+	registry.Register(types.HandlerChargeStartStop, 0, ChargeStartStopHandler{})
+	registry.Register(types.HandlerConfig, 0, ConfigHandler{})
+	registry.Register(types.HandlerHeading, 0, HeadingHandler{})
+	registry.Register(types.HandlerLoginInfo, 0, LoginInfoHandler{})
+	registry.Register(types.HandlerSingleACCharging, 0, SingleAcChargingHandler{})
+	registry.Register(types.HandlerSingleACStatus, 0, SingleAcStatusHandler{})
+	registry.Register(types.HandlerVersion, 0, VersionHandler{})
+}
@@ -0,0 +1,372 @@
+package handlers_test
+
+import (
+	"encoding/hex"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	impl "github.com/johnwoo-nl/emproto4go/internal"
+	"github.com/johnwoo-nl/emproto4go/internal/handlers"
+	"github.com/johnwoo-nl/emproto4go/protocol"
+	"github.com/johnwoo-nl/emproto4go/types"
+)
+
+// handlerTest is a payload to feed to a handler, and a check of the resulting EVSE data.
+type handlerTest struct {
+	name    string
+	command protocol.EmCommand
+	payload []byte
+	check   func(t *testing.T, evse *impl.Evse)
+}
+
+// createTestEvse creates an EVSE on a started communicator on a pipe network. The acknowledgements the handlers send
+// go nowhere.
+func createTestEvse(t *testing.T) *impl.Evse {
+	t.Helper()
+	options := impl.DefaultCommunicatorOptions()
+	options.DebounceWindow = 0
+	options.Transport = impl.CreatePipeNetwork().Endpoint(&net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 28376})
+	communicator := impl.CreateCommunicator("test", options)
+	communicator.Logger_.SetOutput(io.Discard)
+	if err := communicator.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	t.Cleanup(communicator.Stop)
+	return communicator.DefineEvse("0123456789abcdef").(*impl.Evse)
+}
+
+func runHandlerTests(t *testing.T, handler impl.Handler, tests []handlerTest) {
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if !slices.Contains(handler.Handles(), test.command) {
+				t.Fatalf("handler does not handle command 0x%04X", test.command)
+			}
+			evse := createTestEvse(t)
+			handler.Handle(evse, &protocol.Datagram{Command: test.command, Payload: test.payload})
+			test.check(t, evse)
+		})
+	}
+}
+
+// mustDecodeHex decodes a hex string, ignoring spaces.
+func mustDecodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+	data, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		t.Fatalf("invalid hex: %v", err)
+	}
+	return data
+}
+
+// newPayload returns a payload of the given length for the schema, filled in by set.
+func newPayload(schema *protocol.PayloadSchema, length int, set func(payload protocol.Payload)) []byte {
+	payload := schema.New(length)
+	if set != nil {
+		set(payload)
+	}
+	return payload.Data
+}
+
+func expect[T comparable](t *testing.T, what string, got, want T) {
+	t.Helper()
+	if got != want {
+		t.Errorf("%s is %v, expected %v", what, got, want)
+	}
+}
+
+// expectNoUnknownValues fails the test if the EVSE's communicator recorded values without a known meaning.
+func expectNoUnknownValues(t *testing.T, evse *impl.Evse) {
+	t.Helper()
+	if unknown := evse.Communicator().Diagnostics().UnknownValues; len(unknown) != 0 {
+		t.Errorf("unknown values recorded: %v", unknown)
+	}
+}
+
+func TestSingleAcStatusHandler(t *testing.T) {
+	// Line 1, 230.0V, 16.00A, 3680W, 123.45kWh, inner 35.50°C, outer not set, charging, error bit 3.
+	singlePhase := mustDecodeHex(t, "01 08FC 0640 00000E60 00003039 5BFE FFFF 00 04 01 0E 00000008")
+	threePhase := append(slices.Clone(singlePhase),
+		0x08, 0xFD, 0x06, 0x41, // L2: 230.1V, 16.01A
+		0x08, 0xFE, 0x06, 0x42, // L3: 230.2V, 16.02A
+	)
+	newProtocol := func(state byte) []byte {
+		return append(slices.Clone(threePhase), 0x00, state)
+	}
+
+	runHandlerTests(t, handlers.SingleAcStatusHandler{}, []handlerTest{
+		{
+			name:    "single phase",
+			command: protocol.CmdSingleACStatus,
+			payload: singlePhase,
+			check: func(t *testing.T, evse *impl.Evse) {
+				state := evse.State()
+				expect(t, "line ID", state.LineId(), types.LineId(1))
+				expect(t, "L1 voltage", state.L1Voltage(), types.Volts(230.0))
+				expect(t, "L1 current", state.L1Current(), types.Amps(16.0))
+				expect(t, "L2 voltage", state.L2Voltage(), types.Volts(0))
+				expect(t, "L3 current", state.L3Current(), types.Amps(0))
+				expect(t, "power", state.CurrentPower(), types.Watts(3680))
+				expect(t, "energy counter", state.EnergyCounter(), types.KWh(123.45))
+				expect(t, "inner temperature", state.InnerTemp(), types.TempCelsius(35.5))
+				expect(t, "outer temperature", state.OuterTemp(), types.TempCelsius(-1))
+				expect(t, "current state", state.CurrentState(), types.Charging)
+				expect(t, "output state", state.OutputState(), types.OutputStateCharging)
+				expect(t, "new protocol", state.IsNewProtocol(), false)
+				if errors := state.Errors(); !slices.Equal(errors, []types.EmError{3}) {
+					t.Errorf("errors are %v, expected [3]", errors)
+				}
+			},
+		},
+		{
+			name:    "three phase",
+			command: protocol.CmdSingleACStatus,
+			payload: threePhase,
+			check: func(t *testing.T, evse *impl.Evse) {
+				state := evse.State()
+				expect(t, "L2 voltage", state.L2Voltage(), types.Volts(230.1))
+				expect(t, "L2 current", state.L2Current(), types.Amps(16.01))
+				expect(t, "L3 voltage", state.L3Voltage(), types.Volts(230.2))
+				expect(t, "L3 current", state.L3Current(), types.Amps(16.02))
+				// The reported power (3680 W) is lower than the computed one: 230.0 V * 16.00 A + 230.1 V * 16.01 A +
+				// 230.2 V * 16.02 A = 11051.705 W.
+				expect(t, "power", state.CurrentPower(), types.Watts(11051))
+				expect(t, "new protocol", state.IsNewProtocol(), false)
+			},
+		},
+		{
+			name:    "new protocol, idle",
+			command: protocol.CmdSingleACStatus,
+			payload: newProtocol(0),
+			check: func(t *testing.T, evse *impl.Evse) {
+				state := evse.State()
+				expect(t, "new protocol", state.IsNewProtocol(), true)
+				expect(t, "current state", state.CurrentState(), types.Charging)
+				expectNoUnknownValues(t, evse)
+			},
+		},
+		{
+			name:    "new protocol, state 18",
+			command: protocol.CmdSingleACStatus,
+			payload: newProtocol(18),
+			check: func(t *testing.T, evse *impl.Evse) {
+				expect(t, "current state", evse.State().CurrentState(), types.CurrentStateUnknown18)
+				expectNoUnknownValues(t, evse)
+			},
+		},
+		{
+			name:    "too short",
+			command: protocol.CmdSingleACStatus,
+			payload: singlePhase[:24],
+			check: func(t *testing.T, evse *impl.Evse) {
+				expect(t, "L1 voltage", evse.State().L1Voltage(), types.Volts(0))
+			},
+		},
+	})
+}
+
+func TestSingleAcChargingHandler(t *testing.T) {
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	session := func(length int) []byte {
+		return newPayload(protocol.SingleACChargingSchema, length, func(payload protocol.Payload) {
+			payload.SetUint("port", 1)
+			payload.SetUint("charge_state", uint64(types.Charging))
+			payload.SetString("charge_id", "202603011200abcd")
+			payload.SetString("user_id", "emproto4go")
+			payload.SetFloat("max_energy", 10)
+			payload.SetUint("max_current", 16)
+			payload.SetTime("start_time", &start)
+			payload.SetUint("duration", 90)
+			payload.SetFloat("start_energy_counter", 100)
+			payload.SetFloat("current_energy_counter", 101.5)
+			payload.SetFloat("charged_energy", 1.5)
+			payload.SetUint("new_protocol_state", 19)
+		})
+	}
+
+	runHandlerTests(t, handlers.SingleAcChargingHandler{}, []handlerTest{
+		{
+			name:    "no session",
+			command: protocol.CmdSingleACChargingStatusResponse,
+			payload: newPayload(protocol.SingleACChargingSchema, 0, nil),
+			check: func(t *testing.T, evse *impl.Evse) {
+				charge := evse.Charge()
+				if charge.MaxEnergy() != nil {
+					t.Errorf("max energy is %v, expected nil", *charge.MaxEnergy())
+				}
+				if charge.MaxDuration() != nil {
+					t.Errorf("max duration is %v, expected nil", *charge.MaxDuration())
+				}
+				if charge.StartTime() != nil {
+					t.Errorf("start time is %v, expected nil", *charge.StartTime())
+				}
+				expect(t, "start energy counter", charge.StartEnergyCounter(), types.KWh(0))
+				expect(t, "current energy counter", charge.CurrentEnergyCounter(), types.KWh(0))
+				expect(t, "charged energy", charge.ChargedEnergy(), types.KWh(0))
+			},
+		},
+		{
+			name:    "session",
+			command: protocol.CmdSingleACChargingPublicAuto,
+			payload: session(74),
+			check: func(t *testing.T, evse *impl.Evse) {
+				charge := evse.Charge()
+				expect(t, "port", charge.Port(), uint8(1))
+				expect(t, "charge state", charge.ChargeState(), types.Charging)
+				expect(t, "charge ID", charge.ChargeId(), types.ChargeId("202603011200abcd"))
+				expect(t, "user ID", charge.UserId(), types.UserId("emproto4go"))
+				if charge.MaxEnergy() == nil || *charge.MaxEnergy() != 10 {
+					t.Errorf("max energy is %v, expected 10", charge.MaxEnergy())
+				}
+				if charge.MaxDuration() != nil {
+					t.Errorf("max duration is %v, expected nil", *charge.MaxDuration())
+				}
+				expect(t, "max current", charge.MaxCurrent(), types.Amps(16))
+				if charge.StartTime() == nil || !charge.StartTime().Equal(start) {
+					t.Errorf("start time is %v, expected %v", charge.StartTime(), start)
+				}
+				expect(t, "duration", charge.Duration(), 90*time.Second)
+				expect(t, "start energy counter", charge.StartEnergyCounter(), types.KWh(100))
+				expect(t, "current energy counter", charge.CurrentEnergyCounter(), types.KWh(101.5))
+				expect(t, "charged energy", charge.ChargedEnergy(), types.KWh(1.5))
+			},
+		},
+		{
+			name:    "new protocol, state 19",
+			command: protocol.CmdSingleACChargingPublicAuto,
+			payload: session(75),
+			check: func(t *testing.T, evse *impl.Evse) {
+				expect(t, "charge state", evse.Charge().ChargeState(), types.CurrentStateUnknown19)
+				expectNoUnknownValues(t, evse)
+			},
+		},
+		{
+			name:    "too short",
+			command: protocol.CmdSingleACChargingPublicAuto,
+			payload: session(74)[:73],
+			check: func(t *testing.T, evse *impl.Evse) {
+				expect(t, "charge ID", evse.Charge().ChargeId(), types.ChargeId(""))
+			},
+		},
+	})
+}
+
+func TestLoginInfoHandler(t *testing.T) {
+	login := func(length int, evseType byte, brand, model string, byte70 byte) []byte {
+		return newPayload(protocol.LoginSchema, length, func(payload protocol.Payload) {
+			payload.SetUint("evse_type", uint64(evseType))
+			payload.SetString("brand", brand)
+			payload.SetString("model", model)
+			payload.SetString("hardware_version", "HW1.0")
+			payload.SetUint("max_power", 7400)
+			payload.SetUint("max_current", 32)
+			payload.SetUint("byte70", uint64(byte70))
+			if len(brand) > 16 {
+				payload.SetString("brand_ext", brand[16:])
+			}
+			if len(model) > 16 {
+				payload.SetString("model_ext", model[16:])
+			}
+		})
+	}
+
+	runHandlerTests(t, handlers.LoginInfoHandler{}, []handlerTest{
+		{
+			name:    "single phase",
+			command: protocol.CmdLogin,
+			payload: login(54, 1, "Besen", "BS20", 0),
+			check: func(t *testing.T, evse *impl.Evse) {
+				info := evse.Info()
+				expect(t, "EVSE type", info.EvseType(), byte(1))
+				expect(t, "brand", info.Brand(), "Besen")
+				expect(t, "model", info.Model(), "BS20")
+				expect(t, "hardware version", info.HardwareVersion(), "HW1.0")
+				expect(t, "max power", info.MaxPower(), types.Watts(7400))
+				expect(t, "max current", info.MaxCurrent(), types.Amps(32))
+				expect(t, "phases", info.Phases(), types.Phases1p)
+				expect(t, "byte 70", info.Byte70(), byte(0))
+			},
+		},
+		{
+			name:    "three phase with byte 70",
+			command: protocol.CmdLoginResponse,
+			payload: login(119, 22, "Besen", "BS20", 5),
+			check: func(t *testing.T, evse *impl.Evse) {
+				info := evse.Info()
+				expect(t, "phases", info.Phases(), types.Phases3p)
+				expect(t, "byte 70", info.Byte70(), byte(5))
+			},
+		},
+		{
+			name:    "byte 70 ignored for single phase",
+			command: protocol.CmdLoginResponse,
+			payload: login(119, 1, "Besen", "BS20", 5),
+			check: func(t *testing.T, evse *impl.Evse) {
+				expect(t, "byte 70", evse.Info().Byte70(), byte(0))
+			},
+		},
+		{
+			name:    "long brand and model",
+			command: protocol.CmdLoginResponse,
+			payload: login(151, 1, "A brand name longer than 16", "A model name longer than 16", 0),
+			check: func(t *testing.T, evse *impl.Evse) {
+				info := evse.Info()
+				expect(t, "brand", info.Brand(), "A brand name longer than 16")
+				expect(t, "model", info.Model(), "A model name longer than 16")
+			},
+		},
+		{
+			name:    "too short",
+			command: protocol.CmdLogin,
+			payload: login(54, 1, "Besen", "BS20", 0)[:53],
+			check: func(t *testing.T, evse *impl.Evse) {
+				expect(t, "brand", evse.Info().Brand(), "")
+			},
+		},
+	})
+}
+
+func TestVersionHandler(t *testing.T) {
+	version := func(length int) []byte {
+		return newPayload(protocol.VersionSchema, length, func(payload protocol.Payload) {
+			payload.SetString("hardware_version", "HW2.1")
+			payload.SetString("software_version", "V1.2.3")
+			payload.SetUint("feature", 0x00010203)
+			payload.SetUint("support_new", 0x05)
+		})
+	}
+
+	runHandlerTests(t, handlers.VersionHandler{}, []handlerTest{
+		{
+			name:    "without support_new",
+			command: protocol.CmdGetVersionResponse,
+			payload: version(36),
+			check: func(t *testing.T, evse *impl.Evse) {
+				info := evse.Info()
+				expect(t, "hardware version", info.HardwareVersion(), "HW2.1")
+				expect(t, "software version", info.SoftwareVersion(), "V1.2.3")
+				expect(t, "feature", info.Feature(), uint32(0x00010203))
+				expect(t, "support new", info.SupportNew(), uint32(0))
+			},
+		},
+		{
+			name:    "with support_new",
+			command: protocol.CmdGetVersionResponse,
+			payload: version(37),
+			check: func(t *testing.T, evse *impl.Evse) {
+				expect(t, "support new", evse.Info().SupportNew(), uint32(0x05))
+			},
+		},
+		{
+			name:    "too short",
+			command: protocol.CmdGetVersionResponse,
+			payload: version(36)[:35],
+			check: func(t *testing.T, evse *impl.Evse) {
+				expect(t, "software version", evse.Info().SoftwareVersion(), "")
+			},
+		},
+	})
+}
@@ -1,36 +1,28 @@
 package handlers
 
 import (
-	"time"
-
 	impl "github.com/johnwoo-nl/emproto4go/internal"
+	"github.com/johnwoo-nl/emproto4go/protocol"
 )
 
 type HeadingHandler struct{}
 
-func (h HeadingHandler) Handles() []impl.EmCommand {
-	return []impl.EmCommand{impl.CmdHeading}
+func (h HeadingHandler) Handles() []protocol.EmCommand {
+	return []protocol.EmCommand{protocol.CmdHeading}
 }
 
-func (h HeadingHandler) Handle(evse *impl.Evse, _ *impl.Datagram) {
-	response := &impl.Datagram{
-		Command: impl.CmdHeadingResponse,
+func (h HeadingHandler) Handle(evse *impl.Evse, _ *protocol.Datagram) {
+	response := &protocol.Datagram{
+		Command: protocol.CmdHeadingResponse,
 		Payload: []byte{0},
 	}
 	go func() {
 		err := evse.SendDatagram(response)
 		if err == nil {
-			now := time.Now()
-			evse.LastActiveLogin = &now
+			evse.MarkActiveLogin()
 		} else {
 			evse.Communicator().Logger_.Warnf("[emproto4go] Failed to send HeadingResponse to EVSE %s: %v. This may result in the login session expiring and the communicator running the Login flow again.",
 				evse.Serial(), err)
 		}
 	}()
 }
-
-func init() {
-	impl.HandlerDelegator.Register(
-		HeadingHandler{},
-	)
-}
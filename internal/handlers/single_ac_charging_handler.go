@@ -1,94 +1,27 @@
 package handlers
 
 import (
-	"encoding/binary"
 	"time"
 
 	impl "github.com/johnwoo-nl/emproto4go/internal"
+	"github.com/johnwoo-nl/emproto4go/protocol"
 	"github.com/johnwoo-nl/emproto4go/types"
 )
 
 type SingleAcChargingHandler struct{}
 
-func (h SingleAcChargingHandler) Handles() []impl.EmCommand {
-	return []impl.EmCommand{impl.CmdSingleACChargingPublicAuto, impl.CmdSingleACChargingStatusResponse}
+func (h SingleAcChargingHandler) Handles() []protocol.EmCommand {
+	return []protocol.EmCommand{protocol.CmdSingleACChargingPublicAuto, protocol.CmdSingleACChargingStatusResponse}
 }
 
-func (h SingleAcChargingHandler) Handle(evse *impl.Evse, datagram *impl.Datagram) {
-	if impl.CheckPayloadLength(datagram, evse, 74) {
+func (h SingleAcChargingHandler) Handle(evse *impl.Evse, datagram *protocol.Datagram) {
+	payload, ok := impl.DecodePayload(evse, datagram, protocol.SingleACChargingSchema)
+	if !ok {
 		return
 	}
 
-	charge := evse.MutableCharge()
-	changed := false
-	now := time.Now()
-
-	if impl.CompareAndSet(&charge.Port_, datagram.Payload[0]) {
-		changed = true
-	}
-
-	var chargeState types.EmCurrentState
-	if len(datagram.Payload) <= 74 || !(datagram.Payload[74] == 18 || datagram.Payload[74] == 19) {
-		chargeState = types.EmCurrentState(datagram.Payload[1])
-	} else {
-		chargeState = types.EmCurrentState(datagram.Payload[74])
-	}
-	if impl.CompareAndSet(&charge.ChargeState_, chargeState) {
-		changed = true
-	}
-	if impl.CompareAndSet(&charge.ChargeId_, types.ChargeId(impl.ReadString(datagram.Payload[2:18]))) {
-		changed = true
-	}
-	if impl.CompareAndSet(&charge.StartType_, datagram.Payload[18]) {
-		changed = true
-	}
-	if impl.CompareAndSet(&charge.ChargeType_, datagram.Payload[19]) {
-		changed = true
-	}
-	if impl.CompareAndSet(&charge.MaxDuration_, impl.ReadDurationMinutes(datagram.Payload, 20)) {
-		changed = true
-	}
-	if impl.CompareAndSet(&charge.MaxEnergy_, impl.ReadEnergy16(datagram.Payload, 22)) {
-		changed = true
-	}
-	if impl.CompareAndSet(&charge.ReservationTime_, impl.ReadTimestamp(datagram.Payload, 26)) {
-		changed = true
-	}
-	if impl.CompareAndSet(&charge.UserId_, types.UserId(impl.ReadString(datagram.Payload[30:46]))) {
-		changed = true
-	}
-	if impl.CompareAndSet(&charge.MaxCurrent_, types.Amps(datagram.Payload[46])) {
-		changed = true
-	}
-	if impl.CompareAndSet(&charge.StartTime_, impl.ReadTimestamp(datagram.Payload, 47)) {
-		changed = true
-	}
-	if impl.CompareAndSet(&charge.Duration_, impl.ReadDurationSeconds(datagram.Payload, 51)) {
-		changed = true
-	}
-	if impl.CompareAndSet(&charge.StartEnergyCounter_, *impl.ReadEnergy32(datagram.Payload, 55)) {
-		changed = true
-	}
-	if impl.CompareAndSet(&charge.CurrentEnergyCounter_, *impl.ReadEnergy32(datagram.Payload, 59)) {
-		changed = true
-	}
-	if impl.CompareAndSet(&charge.ChargedEnergy_, *impl.ReadEnergy32(datagram.Payload, 63)) {
-		changed = true
-	}
-	if impl.CompareAndSet(&charge.ChargePrice_, float32(binary.BigEndian.Uint32(datagram.Payload[67:71]))*0.01) {
-		changed = true
-	}
-	if impl.CompareAndSet(&charge.FeeType_, datagram.Payload[71]) {
-		changed = true
-	}
-	if impl.CompareAndSet(&charge.ChargeFee_, float32(binary.BigEndian.Uint16(datagram.Payload[72:74]))*0.01) {
-		changed = true
-	}
-
-	charge.LastFetched = &now
-
-	response := &impl.Datagram{
-		Command: impl.CmdSingleACChargingAck,
+	response := &protocol.Datagram{
+		Command: protocol.CmdSingleACChargingAck,
 		Payload: []byte{0},
 	}
 	go func() {
@@ -99,13 +32,86 @@ func (h SingleAcChargingHandler) Handle(evse *impl.Evse, datagram *impl.Datagram
 		}
 	}()
 
-	if changed {
-		evse.QueueEvent(types.EvseChargeUpdated)
+	evse.ObserveValue("charge_state", uint32(payload.Byte("charge_state")))
+	if payload.Has("new_protocol_state") {
+		evse.ObserveValue("new_protocol_state", uint32(payload.Byte("new_protocol_state")))
 	}
+
+	now := time.Now()
+	evse.UpdateCharge(func(charge *impl.EvseCharge) bool {
+		changed := false
+
+		if impl.CompareAndSet(&charge.Port_, payload.Byte("port")) {
+			changed = true
+		}
+
+		chargeState := types.EmCurrentState(payload.Byte("charge_state"))
+		if newProtocolState := payload.Byte("new_protocol_state"); newProtocolState == 18 || newProtocolState == 19 {
+			chargeState = types.EmCurrentState(newProtocolState)
+		}
+		if impl.CompareAndSet(&charge.ChargeState_, chargeState) {
+			changed = true
+		}
+		if impl.CompareAndSet(&charge.ChargeId_, types.ChargeId(payload.String("charge_id"))) {
+			changed = true
+		}
+		if impl.CompareAndSet(&charge.StartType_, payload.Byte("start_type")) {
+			changed = true
+		}
+		if impl.CompareAndSet(&charge.ChargeType_, payload.Byte("charge_type")) {
+			changed = true
+		}
+		if impl.CompareAndSet(&charge.MaxDuration_, payload.Duration("max_duration")) {
+			changed = true
+		}
+		if impl.CompareAndSet(&charge.MaxEnergy_, readEnergy(payload, "max_energy")) {
+			changed = true
+		}
+		if impl.CompareAndSet(&charge.ReservationTime_, payload.Time("reservation_time")) {
+			changed = true
+		}
+		if impl.CompareAndSet(&charge.UserId_, types.UserId(payload.String("user_id"))) {
+			changed = true
+		}
+		if impl.CompareAndSet(&charge.MaxCurrent_, types.Amps(payload.Byte("max_current"))) {
+			changed = true
+		}
+		if impl.CompareAndSet(&charge.StartTime_, payload.Time("start_time")) {
+			changed = true
+		}
+		if impl.CompareAndSet(&charge.Duration_, time.Duration(payload.Uint("duration"))*time.Second) {
+			changed = true
+		}
+		// Energy counters are 0xFFFFFFFF when there is no charging session; these are reported as 0.
+		if impl.CompareAndSet(&charge.StartEnergyCounter_, types.KWh(payload.Float("start_energy_counter"))) {
+			changed = true
+		}
+		if impl.CompareAndSet(&charge.CurrentEnergyCounter_, types.KWh(payload.Float("current_energy_counter"))) {
+			changed = true
+		}
+		if impl.CompareAndSet(&charge.ChargedEnergy_, types.KWh(payload.Float("charged_energy"))) {
+			changed = true
+		}
+		if impl.CompareAndSet(&charge.ChargePrice_, float32(payload.Float("charge_price"))) {
+			changed = true
+		}
+		if impl.CompareAndSet(&charge.FeeType_, payload.Byte("fee_type")) {
+			changed = true
+		}
+		if impl.CompareAndSet(&charge.ChargeFee_, float32(payload.Float("charge_fee"))) {
+			changed = true
+		}
+
+		charge.LastFetched = &now
+		return changed
+	})
 }
 
-func init() {
-	impl.HandlerDelegator.Register(
-		SingleAcChargingHandler{},
-	)
+// readEnergy returns the energy in a field, or nil if it is not set.
+func readEnergy(payload protocol.Payload, name string) *types.KWh {
+	if !payload.IsSet(name) {
+		return nil
+	}
+	energy := types.KWh(payload.Float(name))
+	return &energy
 }
@@ -1,52 +1,44 @@
 package handlers
 
 import (
-	"encoding/binary"
 	"time"
 
 	impl "github.com/johnwoo-nl/emproto4go/internal"
-	"github.com/johnwoo-nl/emproto4go/types"
+	"github.com/johnwoo-nl/emproto4go/protocol"
 )
 
 type VersionHandler struct{}
 
-func (h VersionHandler) Handles() []impl.EmCommand {
-	return []impl.EmCommand{impl.CmdGetVersionResponse}
+func (h VersionHandler) Handles() []protocol.EmCommand {
+	return []protocol.EmCommand{protocol.CmdGetVersionResponse}
 }
 
-func (h VersionHandler) Handle(evse *impl.Evse, datagram *impl.Datagram) {
-	if impl.CheckPayloadLength(datagram, evse, 35) {
+func (h VersionHandler) Handle(evse *impl.Evse, datagram *protocol.Datagram) {
+	payload, ok := impl.DecodePayload(evse, datagram, protocol.VersionSchema)
+	if !ok {
 		return
 	}
 
-	changed := false
+	now := time.Now()
 
-	info := evse.MutableInfo()
-	if impl.CompareAndSet(&info.HardwareVersion_, impl.ReadString(datagram.Payload[0:16])) {
-		changed = true
-	}
-	if impl.CompareAndSet(&info.SoftwareVersion_, impl.ReadString(datagram.Payload[16:32])) {
-		changed = true
-	}
-	if impl.CompareAndSet(&info.Feature_, binary.BigEndian.Uint32(datagram.Payload[32:36])) {
-		changed = true
-	}
-	if len(datagram.Payload) >= 37 {
-		if impl.CompareAndSet(&info.SupportNew_, uint32(datagram.Payload[36])) {
+	evse.UpdateInfo(func(info *impl.EvseInfo) bool {
+		changed := false
+		if impl.CompareAndSet(&info.HardwareVersion_, payload.String("hardware_version")) {
 			changed = true
 		}
-	}
-
-	now := time.Now()
-	info.LastFetched = &now
-
-	if changed {
-		evse.QueueEvent(types.EvseInfoUpdated)
-	}
-}
+		if impl.CompareAndSet(&info.SoftwareVersion_, payload.String("software_version")) {
+			changed = true
+		}
+		if impl.CompareAndSet(&info.Feature_, uint32(payload.Uint("feature"))) {
+			changed = true
+		}
+		if payload.Has("support_new") {
+			if impl.CompareAndSet(&info.SupportNew_, uint32(payload.Uint("support_new"))) {
+				changed = true
+			}
+		}
 
-func init() {
-	impl.HandlerDelegator.Register(
-		VersionHandler{},
-	)
+		info.LastFetched = &now
+		return changed
+	})
 }
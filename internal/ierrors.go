@@ -3,20 +3,13 @@ package internal
 import (
 	"fmt"
 
+	"github.com/johnwoo-nl/emproto4go/protocol"
 	"github.com/johnwoo-nl/emproto4go/types"
 )
 
-type InvalidDatagramError struct {
-	Message string
-}
-
-func (err InvalidDatagramError) Error() string {
-	return fmt.Sprintf("Invalid datagram: %s", err.Message)
-}
-
 type DatagramSendError struct {
 	Evse    types.EmEvse
-	Command EmCommand
+	Command protocol.EmCommand
 	Message string
 }
 
@@ -0,0 +1,120 @@
+package internal
+
+import (
+	"net"
+	"time"
+
+	"github.com/johnwoo-nl/emproto4go/types"
+)
+
+// CommunicatorOptions holds the settings of a communicator. Use DefaultCommunicatorOptions to get the defaults,
+// which match the timing of the OEM app.
+type CommunicatorOptions struct {
+	// Port is the UDP port to listen on. Ignored if Transport is set.
+	Port int
+	// BindAddress is the IP address to listen on. Ignored if Transport is set.
+	BindAddress net.IP
+	// Interface is the name of the network interface to listen on, or empty for all interfaces. Ignored if Transport
+	// is set.
+	Interface string
+	// Transport overrides the UDP transport created from Port, BindAddress and Interface.
+	Transport types.EmTransport
+
+	// TickInterval is the interval at which online/login state is checked and data is refreshed from EVSEs.
+	TickInterval time.Duration
+	// OnlineTimeout is the time after the last received datagram after which an EVSE is considered offline.
+	OnlineTimeout time.Duration
+	// LoginTimeout is the time after the last login or heading after which a login session is considered expired.
+	LoginTimeout time.Duration
+	// RequestTimeout is the time to wait for a response to a request (login, fetch, set, charge start/stop).
+	RequestTimeout time.Duration
+	// ConfigRequestTimeout is the time to wait for a response to a config get request. These are sent in parallel,
+	// so they get a longer timeout than other requests.
+	ConfigRequestTimeout time.Duration
+	// DebounceWindow is the time an event is held back to merge it with subsequent events of the same type for the
+	// same EVSE. If zero, events are dispatched immediately.
+	DebounceWindow time.Duration
+	// DebounceMaxDelay is the maximum time an event is held back by debouncing.
+	DebounceMaxDelay time.Duration
+
+	// Store persists known EVSEs, which are restored when the communicator is started. Nil means no persistence.
+	Store types.EmStore
+	// StoreSaveDelay is the time changes to the charging session (which are received every few seconds while
+	// charging) are held back before saving them to Store, so they are coalesced into one save. Other changes are
+	// saved right away, and pending changes when the communicator is stopped. If zero, all changes are saved right away.
+	StoreSaveDelay time.Duration
+	// Credentials supplies EVSE passwords. If nil, passwords are kept in Store (if any).
+	Credentials types.EmCredentialProvider
+
+	// Recorder receives every raw datagram received or sent by the communicator. Nil means no recording.
+	Recorder types.EmRecorder
+
+	// Clock is the default clock of EVSEs, for the timezones that are not set with EmEvse.SetClock.
+	Clock types.EmClock
+	// ClockDriftThreshold is the drift of an EVSE's clock from the host's clock above which an EvseClockDrift event
+	// is queued, checked after logging in. Zero (the default) disables the check, as does ExperimentalCommands being
+	// disabled.
+	ClockDriftThreshold time.Duration
+	// ClockAutoSync sets the EVSE's clock to the host's time when the drift exceeds ClockDriftThreshold.
+	ClockAutoSync bool
+
+	// RawCommands enables EmEvse.SendRaw. Disabled by default.
+	RawCommands bool
+	// ExperimentalCommands enables the requests with commands that are not verified with a physical EVSE (see
+	// WithExperimentalCommands). Disabled by default.
+	ExperimentalCommands bool
+
+	// ReplayLogins makes a received login response log in to the EVSE, as the recorded app did, instead of logging in
+	// with a password. Set by ReplayCapture.
+	ReplayLogins bool
+}
+
+func DefaultCommunicatorOptions() CommunicatorOptions {
+	return CommunicatorOptions{
+		Port:                 28376,
+		BindAddress:          net.IPv4zero,
+		TickInterval:         5 * time.Second,
+		OnlineTimeout:        11 * time.Second,
+		LoginTimeout:         15 * time.Second,
+		RequestTimeout:       5 * time.Second,
+		ConfigRequestTimeout: 8 * time.Second,
+		DebounceWindow:       400 * time.Millisecond,
+		DebounceMaxDelay:     2000 * time.Millisecond,
+		StoreSaveDelay:       time.Minute,
+	}
+}
+
+// withValidDurations returns the options with the durations that are out of range (negative, or zero where zero has no
+// meaning) replaced by their defaults, and the names of the options that were replaced. A zero TickInterval would make
+// the tick loop panic, and other invalid durations make requests fail right away or EVSEs go offline constantly.
+func (options CommunicatorOptions) withValidDurations() (CommunicatorOptions, []string) {
+	defaults := DefaultCommunicatorOptions()
+	var replaced []string
+	check := func(name string, value *time.Duration, defaultValue time.Duration, zeroAllowed bool) {
+		if *value < 0 || (*value == 0 && !zeroAllowed) {
+			*value = defaultValue
+			replaced = append(replaced, name)
+		}
+	}
+	check("TickInterval", &options.TickInterval, defaults.TickInterval, false)
+	check("OnlineTimeout", &options.OnlineTimeout, defaults.OnlineTimeout, false)
+	check("LoginTimeout", &options.LoginTimeout, defaults.LoginTimeout, false)
+	check("RequestTimeout", &options.RequestTimeout, defaults.RequestTimeout, false)
+	check("ConfigRequestTimeout", &options.ConfigRequestTimeout, defaults.ConfigRequestTimeout, false)
+	check("DebounceWindow", &options.DebounceWindow, defaults.DebounceWindow, true)
+	// The max delay only matters when debouncing.
+	check("DebounceMaxDelay", &options.DebounceMaxDelay, defaults.DebounceMaxDelay, options.DebounceWindow == 0)
+	check("StoreSaveDelay", &options.StoreSaveDelay, defaults.StoreSaveDelay, true)
+	check("ClockDriftThreshold", &options.ClockDriftThreshold, defaults.ClockDriftThreshold, true)
+	return options, replaced
+}
+
+// transport returns the configured transport, or creates a UDP transport from the port/address/interface options.
+func (options CommunicatorOptions) transport() types.EmTransport {
+	if options.Transport != nil {
+		return options.Transport
+	}
+	transport := CreateUdpTransport(&net.UDPAddr{IP: options.BindAddress, Port: options.Port})
+	transport.iface = options.Interface
+	return transport
+}
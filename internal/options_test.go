@@ -0,0 +1,56 @@
+package internal
+
+import (
+	"io"
+	"net"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestWithValidDurations(t *testing.T) {
+	defaults := DefaultCommunicatorOptions()
+
+	options := defaults
+	options.TickInterval = 0
+	options.RequestTimeout = -time.Second
+	options.DebounceWindow = -time.Second
+	options.StoreSaveDelay = 0 // Zero is valid: save right away.
+	validated, replaced := options.withValidDurations()
+	if !slices.Equal(replaced, []string{"TickInterval", "RequestTimeout", "DebounceWindow"}) {
+		t.Errorf("replaced %v", replaced)
+	}
+	if validated.TickInterval != defaults.TickInterval || validated.RequestTimeout != defaults.RequestTimeout ||
+		validated.DebounceWindow != defaults.DebounceWindow {
+		t.Errorf("invalid durations not replaced by defaults: %+v", validated)
+	}
+	if validated.StoreSaveDelay != 0 {
+		t.Errorf("StoreSaveDelay is %v, expected 0", validated.StoreSaveDelay)
+	}
+
+	// Without debouncing, the max delay doesn't matter.
+	options = defaults
+	options.DebounceWindow = 0
+	options.DebounceMaxDelay = 0
+	if _, replaced := options.withValidDurations(); len(replaced) != 0 {
+		t.Errorf("replaced %v, expected nothing", replaced)
+	}
+	options.DebounceWindow = time.Millisecond
+	if _, replaced := options.withValidDurations(); !slices.Equal(replaced, []string{"DebounceMaxDelay"}) {
+		t.Errorf("replaced %v, expected DebounceMaxDelay", replaced)
+	}
+}
+
+// TestZeroTickInterval checks that a communicator with a zero tick interval starts instead of panicking.
+func TestZeroTickInterval(t *testing.T) {
+	options := DefaultCommunicatorOptions()
+	options.TickInterval = 0
+	options.Transport = CreatePipeNetwork().Endpoint(&net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 28376})
+	communicator := CreateCommunicator("test", options)
+	communicator.Logger_.SetOutput(io.Discard)
+	if err := communicator.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond) // Let the tick loop start.
+	communicator.Stop()
+}
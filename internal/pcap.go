@@ -0,0 +1,366 @@
+package internal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"time"
+
+	"github.com/johnwoo-nl/emproto4go/types"
+)
+
+// emPort is the UDP port EVSEs and apps use for the EVSEMaster protocol.
+const emPort = 28376
+
+// Link-layer header types (see https://www.tcpdump.org/linktypes.html).
+const (
+	linkTypeNull     = 0
+	linkTypeEthernet = 1
+	linkTypeRaw      = 101
+	linkTypeRawAlt   = 12 // DLT_RAW on most BSDs.
+	linkTypeLoop     = 108
+	linkTypeLinuxSll = 113
+	linkTypeIPv4     = 228
+	linkTypeIPv6     = 229
+	linkTypeSll2     = 276
+)
+
+// pcapng block types.
+const (
+	pcapngSectionHeader  = 0x0A0D0D0A
+	pcapngInterfaceDesc  = 0x00000001
+	pcapngObsoletePacket = 0x00000002
+	pcapngSimplePacket   = 0x00000003
+	pcapngEnhancedPacket = 0x00000006
+)
+
+// PcapReader reads the EVSEMaster datagrams (UDP payloads to or from port 28376) from a pcap or pcapng capture, as
+// written by Wireshark or tcpdump. It is a types.EmCaptureReader; datagrams with a command sent by apps (0x8000 bit
+// set) are returned as sent, all others as received. Addr is the address of the EVSE (or of the app, for datagrams
+// broadcast by an app).
+type PcapReader struct {
+	reader *bufio.Reader
+	ng     bool
+
+	// Classic pcap.
+	byteOrder binary.ByteOrder
+	linkType  uint32
+	nanos     bool
+
+	// pcapng: the interfaces of the current section.
+	interfaces []pcapngInterface
+}
+
+type pcapngInterface struct {
+	linkType uint16
+	// tsUnit is the duration of one timestamp unit.
+	tsUnit float64
+}
+
+// pcapPacket is a captured link-layer frame.
+type pcapPacket struct {
+	timestamp time.Time
+	linkType  uint32
+	data      []byte
+}
+
+func CreatePcapReader(reader io.Reader) (*PcapReader, error) {
+	pcapReader := &PcapReader{reader: bufio.NewReader(reader)}
+	magic, err := pcapReader.reader.Peek(4)
+	if err != nil {
+		return nil, fmt.Errorf("not a pcap file: %w", err)
+	}
+
+	switch {
+	case binary.BigEndian.Uint32(magic) == pcapngSectionHeader:
+		pcapReader.ng = true
+		return pcapReader, nil
+	case binary.LittleEndian.Uint32(magic) == 0xa1b2c3d4:
+		pcapReader.byteOrder = binary.LittleEndian
+	case binary.BigEndian.Uint32(magic) == 0xa1b2c3d4:
+		pcapReader.byteOrder = binary.BigEndian
+	case binary.LittleEndian.Uint32(magic) == 0xa1b23c4d:
+		pcapReader.byteOrder, pcapReader.nanos = binary.LittleEndian, true
+	case binary.BigEndian.Uint32(magic) == 0xa1b23c4d:
+		pcapReader.byteOrder, pcapReader.nanos = binary.BigEndian, true
+	default:
+		return nil, fmt.Errorf("not a pcap or pcapng file (magic %x)", magic)
+	}
+
+	var header [24]byte
+	if _, err := io.ReadFull(pcapReader.reader, header[:]); err != nil {
+		return nil, fmt.Errorf("invalid pcap header: %w", truncatedCapture(err))
+	}
+	// The link type is in the lower 16 bits; the upper bits may hold FCS info.
+	pcapReader.linkType = pcapReader.byteOrder.Uint32(header[20:24]) & 0xffff
+	return pcapReader, nil
+}
+
+func (reader *PcapReader) Next() (types.EmCapturedDatagram, error) {
+	for {
+		var packet *pcapPacket
+		var err error
+		if reader.ng {
+			packet, err = reader.nextPcapngPacket()
+		} else {
+			packet, err = reader.nextPcapPacket()
+		}
+		if err != nil {
+			return types.EmCapturedDatagram{}, err
+		}
+
+		src, dst, payload := extractUdp(packet.linkType, packet.data)
+		if payload == nil || (src.Port != emPort && dst.Port != emPort) {
+			continue
+		}
+		datagram := types.EmCapturedDatagram{
+			Timestamp: packet.timestamp,
+			Direction: types.CaptureReceived,
+			Addr:      src,
+			Data:      payload,
+		}
+		if len(payload) >= 21 && binary.BigEndian.Uint16(payload[0:2]) == 0x0601 && payload[19]&0x80 != 0 {
+			datagram.Direction = types.CaptureSent
+			datagram.Addr = dst
+			if dst.IP.Equal(net.IPv4bcast) {
+				datagram.Addr = src
+			}
+		}
+		return datagram, nil
+	}
+}
+
+func (reader *PcapReader) nextPcapPacket() (*pcapPacket, error) {
+	var header [16]byte
+	if _, err := io.ReadFull(reader.reader, header[:]); err != nil {
+		return nil, err
+	}
+	seconds := int64(reader.byteOrder.Uint32(header[0:4]))
+	fraction := int64(reader.byteOrder.Uint32(header[4:8]))
+	if !reader.nanos {
+		fraction *= 1000
+	}
+	capLen := reader.byteOrder.Uint32(header[8:12])
+	if capLen > 256*1024 {
+		return nil, fmt.Errorf("invalid pcap packet length %d", capLen)
+	}
+	data := make([]byte, capLen)
+	if _, err := io.ReadFull(reader.reader, data); err != nil {
+		return nil, truncatedCapture(err)
+	}
+	return &pcapPacket{timestamp: time.Unix(seconds, fraction), linkType: reader.linkType, data: data}, nil
+}
+
+func (reader *PcapReader) nextPcapngPacket() (*pcapPacket, error) {
+	for {
+		blockType, body, err := reader.nextPcapngBlock()
+		if err != nil {
+			return nil, err
+		}
+
+		switch blockType {
+		case pcapngInterfaceDesc:
+			if len(body) < 8 {
+				return nil, fmt.Errorf("invalid pcapng interface description block")
+			}
+			reader.interfaces = append(reader.interfaces, pcapngInterface{
+				linkType: reader.byteOrder.Uint16(body[0:2]),
+				tsUnit:   reader.pcapngTimestampUnit(body[8:]),
+			})
+		case pcapngEnhancedPacket, pcapngObsoletePacket:
+			if len(body) < 20 {
+				return nil, fmt.Errorf("invalid pcapng packet block")
+			}
+			var ifaceId uint32
+			if blockType == pcapngEnhancedPacket {
+				ifaceId = reader.byteOrder.Uint32(body[0:4])
+			} else {
+				ifaceId = uint32(reader.byteOrder.Uint16(body[0:2]))
+			}
+			if int(ifaceId) >= len(reader.interfaces) {
+				return nil, fmt.Errorf("pcapng packet block refers to unknown interface %d", ifaceId)
+			}
+			iface := reader.interfaces[ifaceId]
+			ts := uint64(reader.byteOrder.Uint32(body[4:8]))<<32 | uint64(reader.byteOrder.Uint32(body[8:12]))
+			capLen := reader.byteOrder.Uint32(body[12:16])
+			if int(capLen) > len(body)-20 {
+				return nil, fmt.Errorf("invalid pcapng packet length %d", capLen)
+			}
+			return &pcapPacket{
+				timestamp: pcapngTimestamp(ts, iface.tsUnit),
+				linkType:  uint32(iface.linkType),
+				data:      body[20 : 20+capLen],
+			}, nil
+		case pcapngSimplePacket:
+			// No timestamp, and always from the first interface.
+			if len(body) < 4 || len(reader.interfaces) == 0 {
+				return nil, fmt.Errorf("invalid pcapng simple packet block")
+			}
+			capLen := min(int(reader.byteOrder.Uint32(body[0:4])), len(body)-4)
+			return &pcapPacket{linkType: uint32(reader.interfaces[0].linkType), data: body[4 : 4+capLen]}, nil
+		}
+		// Other blocks (statistics, name resolution, ...) are skipped.
+	}
+}
+
+// nextPcapngBlock reads the next block, handling section headers (which set the byte order and reset the interfaces).
+// Returns the block type and body (without the type and lengths).
+func (reader *PcapReader) nextPcapngBlock() (uint32, []byte, error) {
+	var header [8]byte
+	if _, err := io.ReadFull(reader.reader, header[:]); err != nil {
+		return 0, nil, err
+	}
+	blockType := binary.BigEndian.Uint32(header[0:4])
+	if blockType == pcapngSectionHeader {
+		magic, err := reader.reader.Peek(4)
+		if err != nil {
+			return 0, nil, truncatedCapture(err)
+		}
+		switch {
+		case binary.LittleEndian.Uint32(magic) == 0x1A2B3C4D:
+			reader.byteOrder = binary.LittleEndian
+		case binary.BigEndian.Uint32(magic) == 0x1A2B3C4D:
+			reader.byteOrder = binary.BigEndian
+		default:
+			return 0, nil, fmt.Errorf("invalid pcapng byte-order magic %x", magic)
+		}
+		reader.interfaces = nil
+	} else if reader.byteOrder == nil {
+		return 0, nil, fmt.Errorf("pcapng file does not start with a section header")
+	} else {
+		blockType = reader.byteOrder.Uint32(header[0:4])
+	}
+
+	length := reader.byteOrder.Uint32(header[4:8])
+	if length < 12 || length%4 != 0 || length > 64*1024*1024 {
+		return 0, nil, fmt.Errorf("invalid pcapng block length %d", length)
+	}
+	rest := make([]byte, length-8)
+	if _, err := io.ReadFull(reader.reader, rest); err != nil {
+		return 0, nil, truncatedCapture(err)
+	}
+	// The body is followed by a copy of the block length.
+	return blockType, rest[:len(rest)-4], nil
+}
+
+// pcapngTimestampUnit returns the timestamp unit from the if_tsresol option in the options of an interface description
+// block (default: microseconds).
+func (reader *PcapReader) pcapngTimestampUnit(options []byte) float64 {
+	for len(options) >= 4 {
+		code := reader.byteOrder.Uint16(options[0:2])
+		length := int(reader.byteOrder.Uint16(options[2:4]))
+		if code == 0 || 4+length > len(options) {
+			break
+		}
+		if code == 9 && length >= 1 {
+			resolution := options[4]
+			if resolution&0x80 != 0 {
+				return math.Pow(2, -float64(resolution&0x7f))
+			}
+			return math.Pow(10, -float64(resolution))
+		}
+		options = options[4+(length+3)/4*4:]
+	}
+	return 1e-6
+}
+
+func pcapngTimestamp(ts uint64, unit float64) time.Time {
+	if unit == 1e-6 {
+		return time.UnixMicro(int64(ts))
+	}
+	if unit == 1e-9 {
+		return time.Unix(0, int64(ts))
+	}
+	// Split off the whole seconds first; a timestamp of this century doesn't fit in a float64 with sub-µs precision.
+	if perSecond := math.Round(1 / unit); perSecond >= 1 && perSecond <= 1e18 {
+		return time.Unix(int64(ts/uint64(perSecond)), int64(float64(ts%uint64(perSecond))*1e9/perSecond))
+	}
+	seconds, fraction := math.Modf(float64(ts) * unit)
+	return time.Unix(int64(seconds), int64(fraction*1e9))
+}
+
+// extractUdp returns the source and destination address and the payload of a UDP packet in a link-layer frame, or a
+// nil payload if it is not a (non-fragmented) UDP packet.
+func extractUdp(linkType uint32, frame []byte) (*net.UDPAddr, *net.UDPAddr, []byte) {
+	var ip []byte
+	switch linkType {
+	case linkTypeEthernet:
+		if len(frame) < 14 {
+			return nil, nil, nil
+		}
+		etherType, offset := binary.BigEndian.Uint16(frame[12:14]), 14
+		// Skip VLAN tags.
+		for (etherType == 0x8100 || etherType == 0x88a8) && len(frame) >= offset+4 {
+			etherType, offset = binary.BigEndian.Uint16(frame[offset+2:offset+4]), offset+4
+		}
+		if etherType != 0x0800 && etherType != 0x86dd {
+			return nil, nil, nil
+		}
+		ip = frame[offset:]
+	case linkTypeNull, linkTypeLoop:
+		if len(frame) < 4 {
+			return nil, nil, nil
+		}
+		ip = frame[4:]
+	case linkTypeRaw, linkTypeRawAlt, linkTypeIPv4, linkTypeIPv6:
+		ip = frame
+	case linkTypeLinuxSll:
+		if len(frame) < 16 {
+			return nil, nil, nil
+		}
+		ip = frame[16:]
+	case linkTypeSll2:
+		if len(frame) < 20 {
+			return nil, nil, nil
+		}
+		ip = frame[20:]
+	default:
+		return nil, nil, nil
+	}
+	if len(ip) < 1 {
+		return nil, nil, nil
+	}
+
+	var srcIP, dstIP net.IP
+	var udp []byte
+	switch ip[0] >> 4 {
+	case 4:
+		headerLen := int(ip[0]&0x0f) * 4
+		if len(ip) < 20 || headerLen < 20 || len(ip) < headerLen || ip[9] != 17 {
+			return nil, nil, nil
+		}
+		// Skip fragments (more fragments flag or fragment offset set); EVSEMaster datagrams are never fragmented.
+		if binary.BigEndian.Uint16(ip[6:8])&0x3fff != 0 {
+			return nil, nil, nil
+		}
+		totalLen := int(binary.BigEndian.Uint16(ip[2:4]))
+		if totalLen >= headerLen && totalLen < len(ip) {
+			// Strip Ethernet padding.
+			ip = ip[:totalLen]
+		}
+		srcIP, dstIP, udp = net.IP(ip[12:16]), net.IP(ip[16:20]), ip[headerLen:]
+	case 6:
+		// Extension headers are not supported.
+		if len(ip) < 40 || ip[6] != 17 {
+			return nil, nil, nil
+		}
+		srcIP, dstIP, udp = net.IP(ip[8:24]), net.IP(ip[24:40]), ip[40:]
+	default:
+		return nil, nil, nil
+	}
+
+	if len(udp) < 8 {
+		return nil, nil, nil
+	}
+	udpLen := int(binary.BigEndian.Uint16(udp[4:6]))
+	if udpLen < 8 || udpLen > len(udp) {
+		// Truncated by the capture's snap length.
+		return nil, nil, nil
+	}
+	src := &net.UDPAddr{IP: srcIP, Port: int(binary.BigEndian.Uint16(udp[0:2]))}
+	dst := &net.UDPAddr{IP: dstIP, Port: int(binary.BigEndian.Uint16(udp[2:4]))}
+	return src, dst, udp[8:udpLen]
+}
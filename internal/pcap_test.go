@@ -0,0 +1,422 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/johnwoo-nl/emproto4go/protocol"
+	"github.com/johnwoo-nl/emproto4go/types"
+)
+
+var (
+	testPcapApp   = &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1).To4(), Port: emPort}
+	testPcapEvse  = &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2).To4(), Port: emPort}
+	testPcapBcast = &net.UDPAddr{IP: net.IPv4bcast.To4(), Port: emPort}
+	// testPcapStart is the timestamp of the first packet; it has whole milliseconds, so every timestamp resolution
+	// can represent it.
+	testPcapStart = time.Unix(1780000000, 123000000)
+)
+
+// testPcapPacket is a UDP packet in a fixture, with the datagram it should be read as (nil if it should be skipped).
+type testPcapPacket struct {
+	src, dst *net.UDPAddr
+	payload  []byte
+	expected *types.EmCapturedDatagram
+}
+
+// testPcapPackets returns the packets of the fixtures: a login broadcast by an EVSE, a request sent by an app to the
+// EVSE, a request broadcast by an app and a DNS packet, which is skipped.
+func testPcapPackets(t *testing.T) []testPcapPacket {
+	encode := func(command protocol.EmCommand, payload []byte) []byte {
+		data, err := (&protocol.Datagram{Key: 1, Serial: "0123456789abcdef", Password: "123456", Command: command,
+			Payload: payload}).Encode()
+		if err != nil {
+			t.Fatalf("encode: %v", err)
+		}
+		return data
+	}
+	login := encode(protocol.CmdLogin, protocol.LoginPayload{EvseType: 1, Brand: "Besen", Model: "BS20",
+		Length: 54}.Encode())
+	getVersion := encode(protocol.CmdGetVersion, []byte{0})
+	return []testPcapPacket{
+		{testPcapEvse, testPcapBcast, login,
+			&types.EmCapturedDatagram{Direction: types.CaptureReceived, Addr: testPcapEvse, Data: login}},
+		{testPcapApp, testPcapEvse, getVersion,
+			&types.EmCapturedDatagram{Direction: types.CaptureSent, Addr: testPcapEvse, Data: getVersion}},
+		{testPcapApp, testPcapBcast, getVersion,
+			&types.EmCapturedDatagram{Direction: types.CaptureSent, Addr: testPcapApp, Data: getVersion}},
+		{&net.UDPAddr{IP: testPcapApp.IP, Port: 40000}, &net.UDPAddr{IP: testPcapEvse.IP, Port: 53}, []byte{1, 2, 3},
+			nil},
+	}
+}
+
+func testUdp(src, dst *net.UDPAddr, payload []byte) []byte {
+	udp := binary.BigEndian.AppendUint16(nil, uint16(src.Port))
+	udp = binary.BigEndian.AppendUint16(udp, uint16(dst.Port))
+	udp = binary.BigEndian.AppendUint16(udp, uint16(8+len(payload)))
+	udp = binary.BigEndian.AppendUint16(udp, 0)
+	return append(udp, payload...)
+}
+
+func testIPv4(src, dst *net.UDPAddr, payload []byte) []byte {
+	udp := testUdp(src, dst, payload)
+	ip := []byte{0x45, 0}
+	ip = binary.BigEndian.AppendUint16(ip, uint16(20+len(udp)))
+	ip = append(ip, 0, 0, 0, 0, 64, 17, 0, 0)
+	ip = append(ip, src.IP.To4()...)
+	ip = append(ip, dst.IP.To4()...)
+	return append(ip, udp...)
+}
+
+func testIPv6(src, dst *net.UDPAddr, payload []byte) []byte {
+	udp := testUdp(src, dst, payload)
+	ip := []byte{0x60, 0, 0, 0}
+	ip = binary.BigEndian.AppendUint16(ip, uint16(len(udp)))
+	ip = append(ip, 17, 64)
+	ip = append(ip, src.IP.To16()...)
+	ip = append(ip, dst.IP.To16()...)
+	return append(ip, udp...)
+}
+
+// testFrame wraps an IPv4 or IPv6 packet in a link-layer frame.
+func testFrame(linkType uint32, ip []byte) []byte {
+	etherType := uint16(0x0800)
+	if ip[0]>>4 == 6 {
+		etherType = 0x86dd
+	}
+	switch linkType {
+	case linkTypeEthernet:
+		frame := append(make([]byte, 12), byte(etherType>>8), byte(etherType))
+		return append(frame, ip...)
+	case linkTypeNull, linkTypeLoop:
+		return append([]byte{2, 0, 0, 0}, ip...)
+	case linkTypeLinuxSll:
+		frame := append(make([]byte, 14), byte(etherType>>8), byte(etherType))
+		return append(frame, ip...)
+	case linkTypeSll2:
+		frame := append([]byte{byte(etherType >> 8), byte(etherType)}, make([]byte, 18)...)
+		return append(frame, ip...)
+	}
+	return ip
+}
+
+func testPcap(order binary.AppendByteOrder, nanos bool, linkType uint32, frames [][]byte) []byte {
+	magic := uint32(0xa1b2c3d4)
+	if nanos {
+		magic = 0xa1b23c4d
+	}
+	data := order.AppendUint32(nil, magic)
+	data = order.AppendUint16(data, 2)
+	data = order.AppendUint16(data, 4)
+	data = append(data, make([]byte, 8)...)
+	data = order.AppendUint32(data, 65535)
+	data = order.AppendUint32(data, linkType)
+	for i, frame := range frames {
+		timestamp := testPcapStart.Add(time.Duration(i) * time.Second)
+		fraction := timestamp.Nanosecond() / 1000
+		if nanos {
+			fraction = timestamp.Nanosecond()
+		}
+		data = order.AppendUint32(data, uint32(timestamp.Unix()))
+		data = order.AppendUint32(data, uint32(fraction))
+		data = order.AppendUint32(data, uint32(len(frame)))
+		data = order.AppendUint32(data, uint32(len(frame)))
+		data = append(data, frame...)
+	}
+	return data
+}
+
+func testPcapngBlock(order binary.AppendByteOrder, blockType uint32, body []byte) []byte {
+	body = append(bytes.Clone(body), make([]byte, (4-len(body)%4)%4)...)
+	block := order.AppendUint32(nil, blockType)
+	block = order.AppendUint32(block, uint32(12+len(body)))
+	block = append(block, body...)
+	return order.AppendUint32(block, uint32(12+len(body)))
+}
+
+// testPcapng returns a pcapng capture with one interface, with the frames in enhanced packet blocks, or in simple
+// packet blocks if simple is set. tsResol is the if_tsresol option of the interface (0 for the default).
+func testPcapng(order binary.AppendByteOrder, tsResol byte, simple bool, linkType uint16, frames [][]byte) []byte {
+	section := order.AppendUint32(nil, 0x1A2B3C4D)
+	section = order.AppendUint16(section, 1)
+	section = order.AppendUint16(section, 0)
+	section = append(section, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff)
+	data := testPcapngBlock(order, pcapngSectionHeader, section)
+
+	iface := order.AppendUint16(nil, linkType)
+	iface = order.AppendUint16(iface, 0)
+	iface = order.AppendUint32(iface, 65535)
+	// An if_name option before if_tsresol, so the options are walked.
+	iface = order.AppendUint16(iface, 2)
+	iface = order.AppendUint16(iface, 4)
+	iface = append(iface, "eth0"...)
+	if tsResol != 0 {
+		iface = order.AppendUint16(iface, 9)
+		iface = order.AppendUint16(iface, 1)
+		iface = append(iface, tsResol, 0, 0, 0)
+	}
+	iface = append(iface, 0, 0, 0, 0)
+	data = append(data, testPcapngBlock(order, pcapngInterfaceDesc, iface)...)
+
+	// A block that is not a packet, which is skipped.
+	data = append(data, testPcapngBlock(order, 0x00000005, make([]byte, 12))...)
+
+	unit := 1e-6
+	switch tsResol {
+	case 9:
+		unit = 1e-9
+	case 3:
+		unit = 1e-3
+	case 0x80 | 10:
+		unit = 1.0 / 1024
+	}
+	for i, frame := range frames {
+		if simple {
+			body := order.AppendUint32(nil, uint32(len(frame)))
+			data = append(data, testPcapngBlock(order, pcapngSimplePacket, append(body, frame...))...)
+			continue
+		}
+		timestamp := testPcapStart.Add(time.Duration(i) * time.Second)
+		ts := uint64(timestamp.Unix())*uint64(1/unit+0.5) + uint64(float64(timestamp.Nanosecond())*1e-9/unit+0.5)
+		body := order.AppendUint32(nil, 0)
+		body = order.AppendUint32(body, uint32(ts>>32))
+		body = order.AppendUint32(body, uint32(ts))
+		body = order.AppendUint32(body, uint32(len(frame)))
+		body = order.AppendUint32(body, uint32(len(frame)))
+		data = append(data, testPcapngBlock(order, pcapngEnhancedPacket, append(body, frame...))...)
+	}
+	return data
+}
+
+func testFrames(t *testing.T, linkType uint32) [][]byte {
+	var frames [][]byte
+	for _, packet := range testPcapPackets(t) {
+		frames = append(frames, testFrame(linkType, testIPv4(packet.src, packet.dst, packet.payload)))
+	}
+	return frames
+}
+
+// readPcap reads all datagrams from a capture, until an error (io.EOF at the end of the capture).
+func readPcap(t *testing.T, data []byte) (datagrams []types.EmCapturedDatagram, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			t.Fatalf("reading % x panicked: %v", data, r)
+		}
+	}()
+	reader, err := CreatePcapReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	for {
+		datagram, err := reader.Next()
+		if err != nil {
+			return datagrams, err
+		}
+		datagrams = append(datagrams, datagram)
+	}
+}
+
+func testPcapFixtures(t *testing.T) []struct {
+	name       string
+	data       []byte
+	timestamps bool
+} {
+	return []struct {
+		name       string
+		data       []byte
+		timestamps bool
+	}{
+		{"pcap, little-endian, microseconds", testPcap(binary.LittleEndian, false, linkTypeEthernet,
+			testFrames(t, linkTypeEthernet)), true},
+		{"pcap, big-endian, microseconds", testPcap(binary.BigEndian, false, linkTypeEthernet,
+			testFrames(t, linkTypeEthernet)), true},
+		{"pcap, little-endian, nanoseconds", testPcap(binary.LittleEndian, true, linkTypeRaw,
+			testFrames(t, linkTypeRaw)), true},
+		{"pcap, big-endian, nanoseconds", testPcap(binary.BigEndian, true, linkTypeLinuxSll,
+			testFrames(t, linkTypeLinuxSll)), true},
+		{"pcap, Linux SLL2", testPcap(binary.LittleEndian, false, linkTypeSll2, testFrames(t, linkTypeSll2)), true},
+		{"pcap, BSD loopback", testPcap(binary.LittleEndian, false, linkTypeNull, testFrames(t, linkTypeNull)), true},
+		{"pcapng, little-endian, enhanced packets", testPcapng(binary.LittleEndian, 0, false, linkTypeEthernet,
+			testFrames(t, linkTypeEthernet)), true},
+		{"pcapng, big-endian, enhanced packets, nanoseconds", testPcapng(binary.BigEndian, 9, false, linkTypeEthernet,
+			testFrames(t, linkTypeEthernet)), true},
+		{"pcapng, enhanced packets, milliseconds", testPcapng(binary.LittleEndian, 3, false, linkTypeIPv4,
+			testFrames(t, linkTypeIPv4)), true},
+		{"pcapng, enhanced packets, binary resolution", testPcapng(binary.LittleEndian, 0x80|10, false,
+			linkTypeLinuxSll, testFrames(t, linkTypeLinuxSll)), false},
+		{"pcapng, simple packets", testPcapng(binary.BigEndian, 0, true, linkTypeEthernet,
+			testFrames(t, linkTypeEthernet)), false},
+	}
+}
+
+// TestPcapReader reads the same packets from captures in each supported format and checks the datagrams, including
+// their direction and address.
+func TestPcapReader(t *testing.T) {
+	var expected []types.EmCapturedDatagram
+	for i, packet := range testPcapPackets(t) {
+		if packet.expected != nil {
+			datagram := *packet.expected
+			datagram.Timestamp = testPcapStart.Add(time.Duration(i) * time.Second)
+			expected = append(expected, datagram)
+		}
+	}
+
+	for _, fixture := range testPcapFixtures(t) {
+		t.Run(fixture.name, func(t *testing.T) {
+			datagrams, err := readPcap(t, fixture.data)
+			if err != io.EOF {
+				t.Fatalf("reading returned %v, expected io.EOF", err)
+			}
+			if len(datagrams) != len(expected) {
+				t.Fatalf("read %d datagrams, expected %d", len(datagrams), len(expected))
+			}
+			for i, datagram := range datagrams {
+				if datagram.Direction != expected[i].Direction || datagram.Addr.String() != expected[i].Addr.String() ||
+					!bytes.Equal(datagram.Data, expected[i].Data) {
+					t.Errorf("datagram %d is %s from %s, expected %s from %s", i, datagram.Direction, datagram.Addr,
+						expected[i].Direction, expected[i].Addr)
+				}
+				if fixture.timestamps && !datagram.Timestamp.Equal(expected[i].Timestamp) {
+					t.Errorf("datagram %d has timestamp %v, expected %v", i, datagram.Timestamp, expected[i].Timestamp)
+				}
+			}
+		})
+	}
+}
+
+func TestPcapngTimestampUnit(t *testing.T) {
+	// 2^-10 seconds: 1024 units is 1 second.
+	timestamp := pcapngTimestamp(1780000000*1024+512, 1.0/1024)
+	if expected := time.Unix(1780000000, 500000000); !timestamp.Equal(expected) {
+		t.Errorf("timestamp is %v, expected %v", timestamp, expected)
+	}
+}
+
+func TestExtractUdp(t *testing.T) {
+	payload := []byte{6, 1, 2, 3}
+	v4 := testIPv4(testPcapApp, testPcapEvse, payload)
+	v6Src := &net.UDPAddr{IP: net.ParseIP("fe80::1"), Port: 40000}
+	v6Dst := &net.UDPAddr{IP: net.ParseIP("fe80::2"), Port: emPort}
+	v6 := testIPv6(v6Src, v6Dst, payload)
+
+	vlan := append(make([]byte, 12), 0x81, 0x00, 0, 1, 0x08, 0x00)
+	vlan = append(vlan, v4...)
+	padded := append(testFrame(linkTypeEthernet, v4), 0, 0, 0, 0)
+	fragment := bytes.Clone(v4)
+	fragment[6] = 0x20 // More fragments.
+	tcp := bytes.Clone(v4)
+	tcp[9] = 6
+	snapped := v4[:len(v4)-1]
+	arp := append(make([]byte, 12), 0x08, 0x06)
+	arp = append(arp, make([]byte, 28)...)
+
+	tests := []struct {
+		name     string
+		linkType uint32
+		frame    []byte
+		src, dst *net.UDPAddr
+	}{
+		{"ethernet", linkTypeEthernet, testFrame(linkTypeEthernet, v4), testPcapApp, testPcapEvse},
+		{"ethernet, IPv6", linkTypeEthernet, testFrame(linkTypeEthernet, v6), v6Src, v6Dst},
+		{"ethernet, VLAN", linkTypeEthernet, vlan, testPcapApp, testPcapEvse},
+		{"ethernet, padded", linkTypeEthernet, padded, testPcapApp, testPcapEvse},
+		{"ethernet, ARP", linkTypeEthernet, arp, nil, nil},
+		{"null", linkTypeNull, testFrame(linkTypeNull, v4), testPcapApp, testPcapEvse},
+		{"loop", linkTypeLoop, testFrame(linkTypeLoop, v6), v6Src, v6Dst},
+		{"raw", linkTypeRaw, v4, testPcapApp, testPcapEvse},
+		{"raw, BSD", linkTypeRawAlt, v6, v6Src, v6Dst},
+		{"IPv4", linkTypeIPv4, v4, testPcapApp, testPcapEvse},
+		{"IPv6", linkTypeIPv6, v6, v6Src, v6Dst},
+		{"Linux SLL", linkTypeLinuxSll, testFrame(linkTypeLinuxSll, v4), testPcapApp, testPcapEvse},
+		{"Linux SLL2", linkTypeSll2, testFrame(linkTypeSll2, v6), v6Src, v6Dst},
+		{"unknown link type", 147, v4, nil, nil},
+		{"fragment", linkTypeRaw, fragment, nil, nil},
+		{"TCP", linkTypeRaw, tcp, nil, nil},
+		{"snapped", linkTypeRaw, snapped, nil, nil},
+		{"empty", linkTypeRaw, nil, nil, nil},
+		{"short ethernet", linkTypeEthernet, make([]byte, 13), nil, nil},
+		{"short SLL", linkTypeLinuxSll, make([]byte, 15), nil, nil},
+		{"short IPv4", linkTypeRaw, v4[:19], nil, nil},
+		{"short IPv6", linkTypeRaw, v6[:39], nil, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			src, dst, data := extractUdp(test.linkType, test.frame)
+			if test.src == nil {
+				if data != nil {
+					t.Errorf("extracted % x, expected nothing", data)
+				}
+				return
+			}
+			if !bytes.Equal(data, payload) {
+				t.Fatalf("extracted % x, expected % x", data, payload)
+			}
+			if src.String() != test.src.String() || dst.String() != test.dst.String() {
+				t.Errorf("extracted %s -> %s, expected %s -> %s", src, dst, test.src, test.dst)
+			}
+		})
+	}
+}
+
+func TestPcapInvalid(t *testing.T) {
+	frames := testFrames(t, linkTypeEthernet)
+	classic := testPcap(binary.LittleEndian, false, linkTypeEthernet, frames)
+	ng := testPcapng(binary.LittleEndian, 0, false, linkTypeEthernet, frames)
+
+	hugePacket := bytes.Clone(classic)
+	binary.LittleEndian.PutUint32(hugePacket[24+8:], 1<<20)
+	badBlockLength := bytes.Clone(ng)
+	binary.LittleEndian.PutUint32(badBlockLength[4:], 13)
+	// The interface description block follows the 28-byte section header block.
+	unknownInterface := testPcapng(binary.LittleEndian, 0, false, linkTypeEthernet, frames)
+	unknownInterface = append(unknownInterface[:28], unknownInterface[28+binary.LittleEndian.Uint32(unknownInterface[32:]):]...)
+	badByteOrder := bytes.Clone(ng)
+	badByteOrder[8] = 0
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"not a pcap file", []byte("EMCAP\x01 not a pcap file")},
+		{"truncated pcap header", classic[:20]},
+		{"truncated pcap packet", classic[:len(classic)-1]},
+		{"truncated pcap packet header", classic[:24+10]},
+		{"pcap packet too large", hugePacket},
+		{"truncated pcapng block", ng[:len(ng)-1]},
+		{"truncated pcapng block header", ng[:4]},
+		{"invalid pcapng block length", badBlockLength},
+		{"unknown pcapng interface", unknownInterface},
+		{"invalid pcapng byte-order magic", badByteOrder},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := readPcap(t, test.data); err == nil || err == io.EOF {
+				t.Errorf("reading returned %v, expected an error", err)
+			}
+		})
+	}
+}
+
+// TestPcapCorrupt truncates and corrupts the fixtures in every possible place and checks that reading them never
+// panics or loops.
+func TestPcapCorrupt(t *testing.T) {
+	for _, fixture := range testPcapFixtures(t) {
+		t.Run(fixture.name, func(t *testing.T) {
+			for length := range len(fixture.data) {
+				_, _ = readPcap(t, fixture.data[:length])
+			}
+			for i := range fixture.data {
+				for _, value := range []byte{0x00, 0xff, fixture.data[i] ^ 0x80} {
+					data := bytes.Clone(fixture.data)
+					data[i] = value
+					_, _ = readPcap(t, data)
+				}
+			}
+		})
+	}
+}
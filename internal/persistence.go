@@ -0,0 +1,278 @@
+package internal
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/johnwoo-nl/emproto4go/types"
+)
+
+// restoreEvses defines the EVSEs from the store that aren't known yet, with their last known data and password.
+// Called by Start.
+func (communicator *Communicator) restoreEvses() {
+	store := communicator.options.Store
+	if store == nil {
+		return
+	}
+	records, err := store.Load()
+	if err != nil {
+		communicator.Logger_.Warnf("[emproto4go] Failed to load EVSEs from store: %v", err)
+		return
+	}
+	for _, record := range records {
+		if communicator.getEvseImpl(record.Serial) != nil {
+			continue
+		}
+		evse := communicator.newEvse(record.Serial)
+		evse.restore(record)
+		communicator.lookupPassword(evse)
+		communicator.storeMutex.Lock()
+		communicator.savedRecords[record.Serial] = record
+		communicator.storeMutex.Unlock()
+		communicator.addEvse(evse)
+		communicator.Logger_.Debugf("[emproto4go] Restored EVSE %s from store", record.Serial)
+	}
+}
+
+// lookupPassword sets the EVSE's password from the credential provider, if there is one and it knows the password.
+func (communicator *Communicator) lookupPassword(evse *Evse) {
+	provider := communicator.options.Credentials
+	if provider == nil {
+		return
+	}
+	password, err := provider.Password(evse.Serial())
+	if err != nil {
+		communicator.Logger_.Warnf("[emproto4go] Failed to get password for EVSE %s from credential provider: %v", evse.Serial(), err)
+		return
+	}
+	if password != "" {
+		evse.mutex.Lock()
+		evse.password = password
+		evse.mutex.Unlock()
+	}
+}
+
+// passwordAccepted is called after successfully logging in to the EVSE with the given password.
+func (communicator *Communicator) passwordAccepted(evse *Evse, password types.EmPassword) {
+	if provider := communicator.options.Credentials; provider != nil {
+		if err := provider.SetPassword(evse.Serial(), password); err != nil {
+			communicator.Logger_.Warnf("[emproto4go] Failed to store password for EVSE %s: %v", evse.Serial(), err)
+		}
+	}
+}
+
+// persistOnEvent saves the EVSE's record to the store (if any) when the event means there is something new to save.
+// Charging session updates arrive every few seconds while charging, so those are saved with a delay.
+func (communicator *Communicator) persistOnEvent(event types.EmEvent) {
+	evse, ok := event.Evse.(*Evse)
+	if !ok {
+		return
+	}
+	switch event.Type {
+	case types.EvseInfoUpdated, types.EvseConfigUpdated, types.EvseLoggedIn, types.EvseChargeStarted, types.EvseChargeStopped:
+		communicator.persistEvse(evse)
+	case types.EvseChargeUpdated:
+		communicator.persistEvseLater(evse)
+	}
+}
+
+// persistEvseLater saves the EVSE's record after StoreSaveDelay, unless a save is pending already.
+func (communicator *Communicator) persistEvseLater(evse *Evse) {
+	delay := communicator.options.StoreSaveDelay
+	if communicator.options.Store == nil {
+		return
+	}
+	if delay <= 0 {
+		communicator.persistEvse(evse)
+		return
+	}
+
+	communicator.pendingSavesMutex.Lock()
+	defer communicator.pendingSavesMutex.Unlock()
+	if _, pending := communicator.pendingSaves[evse.Serial()]; pending {
+		return
+	}
+	communicator.pendingSaves[evse.Serial()] = time.AfterFunc(delay, func() { communicator.persistEvse(evse) })
+}
+
+// flushPendingSaves saves the EVSEs with a pending delayed save right away. Called by Stop.
+func (communicator *Communicator) flushPendingSaves() {
+	communicator.pendingSavesMutex.Lock()
+	var evses []*Evse
+	for serial, timer := range communicator.pendingSaves {
+		if timer.Stop() {
+			if evse := communicator.getEvseImpl(serial); evse != nil {
+				evses = append(evses, evse)
+			}
+		}
+		delete(communicator.pendingSaves, serial)
+	}
+	communicator.pendingSavesMutex.Unlock()
+
+	for _, evse := range evses {
+		communicator.persistEvse(evse)
+	}
+}
+
+// cancelPendingSave cancels the delayed save of the EVSE, if any, because it is saved (or removed) right away.
+func (communicator *Communicator) cancelPendingSave(serial types.EmSerial) {
+	communicator.pendingSavesMutex.Lock()
+	defer communicator.pendingSavesMutex.Unlock()
+	if timer, pending := communicator.pendingSaves[serial]; pending {
+		timer.Stop()
+		delete(communicator.pendingSaves, serial)
+	}
+}
+
+func (communicator *Communicator) persistEvse(evse *Evse) {
+	store := communicator.options.Store
+	if store == nil {
+		return
+	}
+	communicator.cancelPendingSave(evse.Serial())
+
+	// Build the record while holding the lock, so a save with older data can't overwrite a newer one.
+	communicator.storeMutex.Lock()
+	defer communicator.storeMutex.Unlock()
+
+	if communicator.getEvseImpl(evse.Serial()) != evse {
+		// Removed meanwhile.
+		return
+	}
+	record := evse.record(communicator.options.Credentials == nil)
+	if saved, ok := communicator.savedRecords[record.Serial]; ok && reflect.DeepEqual(saved, record) {
+		// E.g. only IsOnline changed, which is not saved.
+		return
+	}
+	if err := store.Save(record); err != nil {
+		communicator.Logger_.Warnf("[emproto4go] Failed to save EVSE %s to store: %v", evse.Serial(), err)
+		return
+	}
+	communicator.savedRecords[record.Serial] = record
+}
+
+func (communicator *Communicator) unpersistEvse(serial types.EmSerial) {
+	store := communicator.options.Store
+	if store == nil {
+		return
+	}
+
+	communicator.cancelPendingSave(serial)
+	communicator.storeMutex.Lock()
+	defer communicator.storeMutex.Unlock()
+
+	delete(communicator.savedRecords, serial)
+	if err := store.Delete(serial); err != nil {
+		communicator.Logger_.Warnf("[emproto4go] Failed to delete EVSE %s from store: %v", serial, err)
+	}
+}
+
+// record returns the EVSE's data for the store; the password is only included if includePassword is true.
+func (evse *Evse) record(includePassword bool) types.EmEvseRecord {
+	evse.mutex.RLock()
+	defer evse.mutex.RUnlock()
+
+	info, config, charge := evse.info, evse.config, evse.charge
+	record := types.EmEvseRecord{
+		Serial: info.serial,
+		IP:     evse.ip,
+		Port:   evse.port,
+		Clock:  evse.clock,
+		Info: types.EmInfoRecord{
+			Brand:           info.Brand_,
+			Model:           info.Model_,
+			HardwareVersion: info.HardwareVersion_,
+			SoftwareVersion: info.SoftwareVersion_,
+			EvseType:        info.EvseType_,
+			Phases:          info.Phases_,
+			MaxPower:        info.MaxPower_,
+			MaxCurrent:      info.MaxCurrent_,
+			Feature:         info.Feature_,
+			SupportNew:      info.SupportNew_,
+			Byte70:          info.Byte70_,
+		},
+		Config: types.EmConfigRecord{
+			Name:            config.Name_,
+			Language:        config.Language_,
+			TemperatureUnit: config.TemperatureUnit_,
+			OfflineCharge:   config.OfflineCharge_,
+			MaxCurrent:      config.MaxCurrent_,
+		},
+		Charge: types.EmChargeRecord{
+			Port:                 charge.Port_,
+			ChargeState:          charge.ChargeState_,
+			ChargeId:             charge.ChargeId_,
+			StartType:            charge.StartType_,
+			ChargeType:           charge.ChargeType_,
+			MaxDuration:          charge.MaxDuration_,
+			MaxEnergy:            charge.MaxEnergy_,
+			ReservationTime:      charge.ReservationTime_,
+			UserId:               charge.UserId_,
+			MaxCurrent:           charge.MaxCurrent_,
+			StartTime:            charge.StartTime_,
+			Duration:             charge.Duration_,
+			StartEnergyCounter:   charge.StartEnergyCounter_,
+			CurrentEnergyCounter: charge.CurrentEnergyCounter_,
+			ChargedEnergy:        charge.ChargedEnergy_,
+			ChargePrice:          charge.ChargePrice_,
+			FeeType:              charge.FeeType_,
+			ChargeFee:            charge.ChargeFee_,
+		},
+	}
+	if includePassword {
+		record.Password = evse.password
+	}
+	return record
+}
+
+// restore sets the EVSE's data from a stored record. Meant for EVSEs that are not yet added to the communicator, so it
+// doesn't queue any events. LastFetched stays nil, so all data is fetched again after logging in.
+func (evse *Evse) restore(record types.EmEvseRecord) {
+	evse.mutex.Lock()
+	defer evse.mutex.Unlock()
+
+	evse.password = record.Password
+	evse.ip = record.IP
+	evse.port = record.Port
+	evse.clock = record.Clock
+
+	info := evse.info
+	info.Brand_ = record.Info.Brand
+	info.Model_ = record.Info.Model
+	info.HardwareVersion_ = record.Info.HardwareVersion
+	info.SoftwareVersion_ = record.Info.SoftwareVersion
+	info.EvseType_ = record.Info.EvseType
+	info.Phases_ = record.Info.Phases
+	info.MaxPower_ = record.Info.MaxPower
+	info.MaxCurrent_ = record.Info.MaxCurrent
+	info.Feature_ = record.Info.Feature
+	info.SupportNew_ = record.Info.SupportNew
+	info.Byte70_ = record.Info.Byte70
+
+	config := evse.config
+	config.Name_ = record.Config.Name
+	config.Language_ = record.Config.Language
+	config.TemperatureUnit_ = record.Config.TemperatureUnit
+	config.OfflineCharge_ = record.Config.OfflineCharge
+	config.MaxCurrent_ = record.Config.MaxCurrent
+
+	charge := evse.charge
+	charge.Port_ = record.Charge.Port
+	charge.ChargeState_ = record.Charge.ChargeState
+	charge.ChargeId_ = record.Charge.ChargeId
+	charge.StartType_ = record.Charge.StartType
+	charge.ChargeType_ = record.Charge.ChargeType
+	charge.MaxDuration_ = record.Charge.MaxDuration
+	charge.MaxEnergy_ = record.Charge.MaxEnergy
+	charge.ReservationTime_ = record.Charge.ReservationTime
+	charge.UserId_ = record.Charge.UserId
+	charge.MaxCurrent_ = record.Charge.MaxCurrent
+	charge.StartTime_ = record.Charge.StartTime
+	charge.Duration_ = record.Charge.Duration
+	charge.StartEnergyCounter_ = record.Charge.StartEnergyCounter
+	charge.CurrentEnergyCounter_ = record.Charge.CurrentEnergyCounter
+	charge.ChargedEnergy_ = record.Charge.ChargedEnergy
+	charge.ChargePrice_ = record.Charge.ChargePrice
+	charge.FeeType_ = record.Charge.FeeType
+	charge.ChargeFee_ = record.Charge.ChargeFee
+}
@@ -0,0 +1,78 @@
+package internal_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/johnwoo-nl/emproto4go/emprototest/sim"
+	"github.com/johnwoo-nl/emproto4go/internal"
+	"github.com/johnwoo-nl/emproto4go/types"
+)
+
+// countingStore keeps records in memory and counts the saves.
+type countingStore struct {
+	mutex   sync.Mutex
+	records map[types.EmSerial]types.EmEvseRecord
+	saves   int
+}
+
+func (store *countingStore) Load() ([]types.EmEvseRecord, error) {
+	return nil, nil
+}
+
+func (store *countingStore) Save(record types.EmEvseRecord) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if store.records == nil {
+		store.records = make(map[types.EmSerial]types.EmEvseRecord)
+	}
+	store.records[record.Serial] = record
+	store.saves++
+	return nil
+}
+
+func (store *countingStore) Delete(serial types.EmSerial) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	delete(store.records, serial)
+	return nil
+}
+
+func (store *countingStore) state() (int, types.EmEvseRecord) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	return store.saves, store.records[testSerial]
+}
+
+// TestChargeUpdatesAreSavedDelayed checks that charging session updates don't rewrite the store every time, and that
+// the last one is saved when the communicator is stopped.
+func TestChargeUpdatesAreSavedDelayed(t *testing.T) {
+	store := &countingStore{}
+	options := internal.DefaultCommunicatorOptions()
+	options.Store = store
+	options.StoreSaveDelay = time.Hour
+	options.DebounceWindow = 0
+	s := startSimulated(t, options, sim.ChargerConfig{PluggedIn: true})
+	s.login(t)
+	waitFor(t, "config fetched", func() bool { return s.evse.Config().MaxCurrent() != 0 })
+	if _, err := s.evse.StartCharge(types.ChargeStartParams{}); err != nil {
+		t.Fatalf("StartCharge: %v", err)
+	}
+
+	// The simulator sends a charging datagram every 50ms, each with a higher energy counter.
+	time.Sleep(200 * time.Millisecond)
+	savesBefore, _ := store.state()
+	time.Sleep(time.Second)
+	savesAfter, _ := store.state()
+	if savesAfter != savesBefore {
+		t.Errorf("store saved %d times while charging, expected the charge updates to be held back", savesAfter-savesBefore)
+	}
+
+	energy := s.evse.Charge().CurrentEnergyCounter()
+	s.communicator.Stop()
+	_, record := store.state()
+	if record.Charge.CurrentEnergyCounter < energy {
+		t.Errorf("energy counter in store is %v after Stop, expected at least %v", record.Charge.CurrentEnergyCounter, energy)
+	}
+}
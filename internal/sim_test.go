@@ -0,0 +1,85 @@
+package internal_test
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/johnwoo-nl/emproto4go/emprototest/sim"
+	"github.com/johnwoo-nl/emproto4go/internal"
+	"github.com/johnwoo-nl/emproto4go/internal/handlers"
+	"github.com/johnwoo-nl/emproto4go/types"
+)
+
+const (
+	testSerial   = types.EmSerial("0123456789abcdef")
+	testPassword = types.EmPassword("123456")
+)
+
+// simulated is a communicator connected to a simulated charger over an in-memory pipe network.
+type simulated struct {
+	communicator *internal.Communicator
+	simulator    *sim.Simulator
+	charger      *sim.Charger
+	evse         *internal.Evse
+}
+
+// startSimulated starts a simulated charger and a communicator with the given options, and waits until the
+// communicator has discovered the charger. Both are stopped when the test ends.
+func startSimulated(t *testing.T, options internal.CommunicatorOptions, config sim.ChargerConfig) *simulated {
+	t.Helper()
+	if config.Serial == "" {
+		config.Serial = testSerial
+	}
+	if config.Password == "" {
+		config.Password = testPassword
+	}
+
+	network := internal.CreatePipeNetwork()
+	simulator := sim.CreatePipeSimulator(network, nil)
+	simulator.Interval = 50 * time.Millisecond
+	simulator.Logger.SetOutput(io.Discard)
+	charger, err := simulator.AddCharger(config)
+	if err != nil {
+		t.Fatalf("AddCharger: %v", err)
+	}
+
+	options.Transport = network.Endpoint(&net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 28376})
+	communicator := internal.CreateCommunicator("test", options)
+	communicator.Logger_.SetOutput(io.Discard)
+	handlers.RegisterBuiltin(communicator.Handlers())
+	evse := communicator.DefineEvse(config.Serial).(*internal.Evse)
+
+	if err := communicator.Start(); err != nil {
+		t.Fatalf("Start communicator: %v", err)
+	}
+	t.Cleanup(communicator.Stop)
+	if err := simulator.Start(); err != nil {
+		t.Fatalf("Start simulator: %v", err)
+	}
+	t.Cleanup(simulator.Stop)
+
+	waitFor(t, "EVSE online", evse.IsOnline)
+	return &simulated{communicator: communicator, simulator: simulator, charger: charger, evse: evse}
+}
+
+// login logs in to the simulated charger with its password.
+func (s *simulated) login(t *testing.T) {
+	t.Helper()
+	if err := s.evse.UsePassword(s.charger.Password()); err != nil {
+		t.Fatalf("UsePassword: %v", err)
+	}
+}
+
+// waitFor polls condition until it is true, failing the test after a few seconds.
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
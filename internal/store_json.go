@@ -0,0 +1,134 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/johnwoo-nl/emproto4go/types"
+)
+
+// JsonFileStore is a types.EmStore that keeps all records in a single JSON file. The file is rewritten (via a
+// temporary file and a rename) on every save, and is only readable by the owner since it may contain passwords.
+type JsonFileStore struct {
+	path string
+
+	mutex   sync.Mutex
+	records map[types.EmSerial]types.EmEvseRecord
+}
+
+type jsonFileStoreContent struct {
+	Evses []types.EmEvseRecord `json:"evses"`
+}
+
+func CreateJsonFileStore(path string) *JsonFileStore {
+	return &JsonFileStore{path: path}
+}
+
+func (store *JsonFileStore) Load() ([]types.EmEvseRecord, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if err := store.read(); err != nil {
+		return nil, err
+	}
+	records := make([]types.EmEvseRecord, 0, len(store.records))
+	for _, record := range store.records {
+		records = append(records, record)
+	}
+	slices.SortFunc(records, func(a, b types.EmEvseRecord) int { return strings.Compare(string(a.Serial), string(b.Serial)) })
+	return records, nil
+}
+
+func (store *JsonFileStore) Save(record types.EmEvseRecord) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if err := store.read(); err != nil {
+		return err
+	}
+	store.records[record.Serial] = record
+	return store.write()
+}
+
+func (store *JsonFileStore) Delete(serial types.EmSerial) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if err := store.read(); err != nil {
+		return err
+	}
+	if _, exists := store.records[serial]; !exists {
+		return nil
+	}
+	delete(store.records, serial)
+	return store.write()
+}
+
+// read loads the file into records, unless that was already done. A missing file is treated as an empty store. Must
+// be called with mutex held.
+func (store *JsonFileStore) read() error {
+	if store.records != nil {
+		return nil
+	}
+	records := make(map[types.EmSerial]types.EmEvseRecord)
+	data, err := os.ReadFile(store.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		store.records = records
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var content jsonFileStoreContent
+	if err := json.Unmarshal(data, &content); err != nil {
+		return err
+	}
+	for _, record := range content.Evses {
+		records[record.Serial] = record
+	}
+	store.records = records
+	return nil
+}
+
+// write writes all records to the file. Must be called with mutex held.
+func (store *JsonFileStore) write() error {
+	content := jsonFileStoreContent{Evses: make([]types.EmEvseRecord, 0, len(store.records))}
+	for _, record := range store.records {
+		content.Evses = append(content.Evses, record)
+	}
+	slices.SortFunc(content.Evses, func(a, b types.EmEvseRecord) int { return strings.Compare(string(a.Serial), string(b.Serial)) })
+	data, err := json.MarshalIndent(content, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(store.path, data)
+}
+
+// writeFileAtomic writes data to a temporary file next to path, then renames it to path, so readers never see a
+// partially written file.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	if err := tmp.Chmod(0o600); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
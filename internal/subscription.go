@@ -0,0 +1,52 @@
+package internal
+
+import (
+	"runtime/debug"
+
+	"github.com/johnwoo-nl/emproto4go/types"
+)
+
+const (
+	// Events for a subscriber are queued in its channel, and in the watcher's ring buffer when the channel is full.
+	subscriberChannelSize = 16
+	subscriberBufferSize  = 1024
+)
+
+func (communicator *Communicator) Subscribe(filter types.EmEventFilter, callback func(event types.EmEvent)) func() {
+	var evse *Evse
+	if filter.Evse != nil {
+		evse = communicator.getEvseImpl(filter.Evse.Serial())
+	}
+	return communicator.SubscribeImpl(evse, filter.Types, callback)
+}
+
+// SubscribeImpl subscribes the callback using a drop-oldest watcher, whose events are passed to the callback by a
+// goroutine dedicated to this subscriber.
+func (communicator *Communicator) SubscribeImpl(evse *Evse, eventTypes []types.EmEventType, callback func(event types.EmEvent)) func() {
+	channel := make(chan types.EmEvent, subscriberChannelSize)
+	watcher := communicator.WatchImpl(evse, eventTypes, channel, types.EmWatchOptions{
+		Policy:     types.DeliveryDropOldest,
+		BufferSize: subscriberBufferSize,
+	})
+
+	go func() {
+		for event := range channel {
+			communicator.callSubscriber(watcher, callback, event)
+		}
+		if dropped := watcher.Dropped(); dropped > 0 {
+			communicator.Logger_.Warnf("[emproto4go] Subscriber %d was too slow; %d events were dropped", watcher.id, dropped)
+		}
+	}()
+
+	return watcher.Stop
+}
+
+func (communicator *Communicator) callSubscriber(watcher *EventWatcher, callback func(event types.EmEvent), event types.EmEvent) {
+	defer func() {
+		if r := recover(); r != nil {
+			communicator.Logger_.Errorf("[emproto4go] Subscriber %d panicked on %s event for EVSE %s: %v\n%s",
+				watcher.id, event.Type, event.Evse.Serial(), r, debug.Stack())
+		}
+	}()
+	callback(event)
+}
@@ -0,0 +1,71 @@
+package internal
+
+import (
+	"encoding/hex"
+	"io"
+	"strings"
+
+	"github.com/johnwoo-nl/emproto4go/protocol"
+	"github.com/johnwoo-nl/emproto4go/types"
+)
+
+// Transcribe reads all datagrams from a capture and decodes their headers. Datagrams that cannot be decoded are
+// included with an error. If reading the capture fails halfway, the entries read so far are returned with the error.
+func Transcribe(reader types.EmCaptureReader) ([]types.EmTranscriptEntry, error) {
+	var entries []types.EmTranscriptEntry
+	for {
+		datagram, err := reader.Next()
+		if err == io.EOF {
+			return entries, nil
+		} else if err != nil {
+			return entries, err
+		}
+		entries = append(entries, TranscribeDatagram(datagram))
+	}
+}
+
+// TranscribeDatagram decodes the header of a captured datagram.
+func TranscribeDatagram(captured types.EmCapturedDatagram) types.EmTranscriptEntry {
+	entry := types.EmTranscriptEntry{
+		Timestamp: captured.Timestamp,
+		Direction: captured.Direction,
+		Length:    len(captured.Data),
+	}
+	if captured.Addr != nil {
+		entry.Addr = captured.Addr.String()
+	}
+
+	datagram, err := protocol.Decode(captured.Data)
+	if err != nil {
+		entry.Error = err.Error()
+		return entry
+	}
+	if datagram == nil {
+		entry.Error = "not an EVSEMaster datagram"
+		return entry
+	}
+	entry.Command = uint16(datagram.Command)
+	entry.CommandName = datagram.Command.Name()
+	entry.Serial = datagram.Serial
+	entry.Key = datagram.Key
+	entry.PasswordSet = datagram.Password != ""
+	entry.Payload = hexBytes(datagram.Payload)
+	return entry
+}
+
+// hexBytes formats data as space-separated hex bytes.
+func hexBytes(data []byte) string {
+	if len(data) == 0 {
+		return ""
+	}
+	encoded := hex.EncodeToString(data)
+	var builder strings.Builder
+	builder.Grow(len(data) * 3)
+	for i := 0; i < len(encoded); i += 2 {
+		if i > 0 {
+			builder.WriteByte(' ')
+		}
+		builder.WriteString(encoded[i : i+2])
+	}
+	return builder.String()
+}
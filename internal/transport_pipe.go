@@ -0,0 +1,148 @@
+package internal
+
+import (
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/johnwoo-nl/emproto4go/types"
+)
+
+// pipeInboxSize is the number of datagrams an endpoint buffers before further datagrams are dropped, just like a
+// UDP socket would drop datagrams when its receive buffer is full.
+const pipeInboxSize = 256
+
+var errAddrInUse = errors.New("address already in use")
+
+type PipeNetwork struct {
+	endpointsMutex sync.RWMutex
+	endpoints      map[string]*PipeTransport
+}
+
+// PipeTransport is an in-memory transport attached to a PipeNetwork.
+type PipeTransport struct {
+	network *PipeNetwork
+	addr    *net.UDPAddr
+
+	mutex  sync.RWMutex
+	inbox  chan pipeDatagram
+	closed chan struct{}
+}
+
+type pipeDatagram struct {
+	data []byte
+	from *net.UDPAddr
+}
+
+func CreatePipeNetwork() *PipeNetwork {
+	return &PipeNetwork{endpoints: make(map[string]*PipeTransport)}
+}
+
+func (network *PipeNetwork) Endpoint(addr *net.UDPAddr) types.EmTransport {
+	return &PipeTransport{network: network, addr: addr}
+}
+
+func (network *PipeNetwork) deliver(data []byte, from *net.UDPAddr, to *net.UDPAddr) {
+	network.endpointsMutex.RLock()
+	defer network.endpointsMutex.RUnlock()
+
+	if to.IP.Equal(net.IPv4bcast) {
+		for _, endpoint := range network.endpoints {
+			if endpoint.addr.Port == to.Port && endpoint.addr.String() != from.String() {
+				endpoint.enqueue(data, from)
+			}
+		}
+		return
+	}
+	if endpoint, exists := network.endpoints[to.String()]; exists {
+		endpoint.enqueue(data, from)
+	}
+}
+
+func (transport *PipeTransport) Open() error {
+	transport.network.endpointsMutex.Lock()
+	defer transport.network.endpointsMutex.Unlock()
+
+	key := transport.addr.String()
+	if existing, exists := transport.network.endpoints[key]; exists && existing != transport {
+		return &net.OpError{Op: "listen", Net: "pipe", Addr: transport.addr, Err: errAddrInUse}
+	}
+
+	transport.mutex.Lock()
+	transport.inbox = make(chan pipeDatagram, pipeInboxSize)
+	transport.closed = make(chan struct{})
+	transport.mutex.Unlock()
+
+	transport.network.endpoints[key] = transport
+	return nil
+}
+
+func (transport *PipeTransport) ReadFrom(buf []byte) (int, net.Addr, error) {
+	transport.mutex.RLock()
+	inbox, closed := transport.inbox, transport.closed
+	transport.mutex.RUnlock()
+
+	if inbox == nil {
+		return 0, nil, net.ErrClosed
+	}
+	select {
+	case datagram := <-inbox:
+		return copy(buf, datagram.data), datagram.from, nil
+	case <-closed:
+		return 0, nil, net.ErrClosed
+	}
+}
+
+func (transport *PipeTransport) WriteTo(data []byte, addr net.Addr) (int, error) {
+	if transport.LocalAddr() == nil {
+		return 0, net.ErrClosed
+	}
+	to, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return 0, &net.AddrError{Err: "not a UDP address", Addr: addr.String()}
+	}
+	transport.network.deliver(append([]byte(nil), data...), transport.addr, to)
+	return len(data), nil
+}
+
+func (transport *PipeTransport) Close() error {
+	transport.network.endpointsMutex.Lock()
+	defer transport.network.endpointsMutex.Unlock()
+
+	transport.mutex.Lock()
+	defer transport.mutex.Unlock()
+
+	if transport.inbox == nil {
+		return nil
+	}
+	close(transport.closed)
+	transport.inbox = nil
+	if transport.network.endpoints[transport.addr.String()] == transport {
+		delete(transport.network.endpoints, transport.addr.String())
+	}
+	return nil
+}
+
+func (transport *PipeTransport) LocalAddr() net.Addr {
+	transport.mutex.RLock()
+	defer transport.mutex.RUnlock()
+
+	if transport.inbox == nil {
+		return nil
+	}
+	return transport.addr
+}
+
+func (transport *PipeTransport) enqueue(data []byte, from *net.UDPAddr) {
+	transport.mutex.RLock()
+	defer transport.mutex.RUnlock()
+
+	if transport.inbox == nil {
+		return
+	}
+	select {
+	case transport.inbox <- pipeDatagram{data: data, from: from}:
+	default:
+		// Inbox full; drop the datagram.
+	}
+}
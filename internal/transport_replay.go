@@ -0,0 +1,177 @@
+package internal
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/johnwoo-nl/emproto4go/types"
+)
+
+// ReplayTransport is a transport that feeds the received datagrams from a capture to the communicator reading from it.
+// Datagrams are paced by their timestamps divided by speed; a speed of 0 (or less) feeds them as fast as the
+// communicator processes them.
+type ReplayTransport struct {
+	reader types.EmCaptureReader
+	speed  float64
+	addr   *net.UDPAddr
+
+	mutex    sync.Mutex
+	closed   chan struct{}
+	done     chan struct{}
+	err      error
+	replayed int
+
+	// Pacing: the timestamp of the first replayed datagram and the time it was replayed.
+	firstTimestamp time.Time
+	startedAt      time.Time
+}
+
+func CreateReplayTransport(reader types.EmCaptureReader, speed float64) *ReplayTransport {
+	return &ReplayTransport{
+		reader: reader,
+		speed:  speed,
+		addr:   &net.UDPAddr{IP: net.IPv4zero, Port: 28376},
+		done:   make(chan struct{}),
+	}
+}
+
+func (transport *ReplayTransport) Open() error {
+	transport.mutex.Lock()
+	defer transport.mutex.Unlock()
+
+	if transport.closed == nil {
+		transport.closed = make(chan struct{})
+	}
+	return nil
+}
+
+func (transport *ReplayTransport) ReadFrom(buf []byte) (int, net.Addr, error) {
+	transport.mutex.Lock()
+	closed := transport.closed
+	transport.mutex.Unlock()
+	if closed == nil {
+		return 0, nil, net.ErrClosed
+	}
+
+	// The communicator processes a datagram before reading the next one, so once we get here after the end of the
+	// capture, all datagrams have been processed.
+	datagram, err := transport.next()
+	if err != nil {
+		transport.finish(err)
+		<-closed
+		return 0, nil, net.ErrClosed
+	}
+
+	if !transport.wait(datagram.Timestamp, closed) {
+		return 0, nil, net.ErrClosed
+	}
+
+	transport.mutex.Lock()
+	transport.replayed++
+	transport.mutex.Unlock()
+
+	var from net.Addr
+	if datagram.Addr != nil {
+		from = datagram.Addr
+	}
+	return copy(buf, datagram.Data), from, nil
+}
+
+// next returns the next received datagram from the capture, skipping sent datagrams.
+func (transport *ReplayTransport) next() (types.EmCapturedDatagram, error) {
+	for {
+		datagram, err := transport.reader.Next()
+		if err != nil || datagram.Direction != types.CaptureSent {
+			return datagram, err
+		}
+	}
+}
+
+// wait waits until it is time to replay a datagram with the given timestamp. Returns false if the transport was closed
+// meanwhile.
+func (transport *ReplayTransport) wait(timestamp time.Time, closed <-chan struct{}) bool {
+	if transport.speed <= 0 || timestamp.IsZero() {
+		return true
+	}
+	if transport.startedAt.IsZero() {
+		transport.firstTimestamp = timestamp
+		transport.startedAt = time.Now()
+		return true
+	}
+
+	offset := time.Duration(float64(timestamp.Sub(transport.firstTimestamp)) / transport.speed)
+	delay := time.Until(transport.startedAt.Add(offset))
+	if delay <= 0 {
+		return true
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-closed:
+		return false
+	}
+}
+
+// finish marks the replay as done; err is the error that stopped reading the capture (io.EOF at its end).
+func (transport *ReplayTransport) finish(err error) {
+	transport.mutex.Lock()
+	defer transport.mutex.Unlock()
+
+	select {
+	case <-transport.done:
+		return
+	default:
+	}
+	if err != io.EOF {
+		transport.err = err
+	}
+	close(transport.done)
+}
+
+func (transport *ReplayTransport) WriteTo(data []byte, _ net.Addr) (int, error) {
+	if transport.LocalAddr() == nil {
+		return 0, net.ErrClosed
+	}
+	return len(data), nil
+}
+
+func (transport *ReplayTransport) Close() error {
+	transport.mutex.Lock()
+	defer transport.mutex.Unlock()
+
+	if transport.closed != nil {
+		close(transport.closed)
+		transport.closed = nil
+	}
+	return nil
+}
+
+func (transport *ReplayTransport) LocalAddr() net.Addr {
+	transport.mutex.Lock()
+	defer transport.mutex.Unlock()
+
+	if transport.closed == nil {
+		return nil
+	}
+	return transport.addr
+}
+
+func (transport *ReplayTransport) Done() <-chan struct{} {
+	return transport.done
+}
+
+func (transport *ReplayTransport) Err() error {
+	transport.mutex.Lock()
+	defer transport.mutex.Unlock()
+	return transport.err
+}
+
+func (transport *ReplayTransport) Replayed() int {
+	transport.mutex.Lock()
+	defer transport.mutex.Unlock()
+	return transport.replayed
+}
@@ -0,0 +1,73 @@
+package internal
+
+import (
+	"net"
+	"sync"
+)
+
+// UdpTransport is the default transport, sending and receiving datagrams on a UDP socket.
+type UdpTransport struct {
+	addr  *net.UDPAddr
+	iface string
+
+	connMutex sync.RWMutex
+	conn      *net.UDPConn
+}
+
+func CreateUdpTransport(addr *net.UDPAddr) *UdpTransport {
+	return &UdpTransport{addr: addr}
+}
+
+func (transport *UdpTransport) Open() error {
+	conn, err := listenUdp(transport.addr, transport.iface)
+	if err != nil {
+		return err
+	}
+	transport.connMutex.Lock()
+	transport.conn = conn
+	transport.connMutex.Unlock()
+	return nil
+}
+
+func (transport *UdpTransport) ReadFrom(buf []byte) (int, net.Addr, error) {
+	transport.connMutex.RLock()
+	conn := transport.conn
+	transport.connMutex.RUnlock()
+
+	if conn == nil {
+		return 0, nil, net.ErrClosed
+	}
+	return conn.ReadFrom(buf)
+}
+
+func (transport *UdpTransport) WriteTo(data []byte, addr net.Addr) (int, error) {
+	transport.connMutex.RLock()
+	defer transport.connMutex.RUnlock()
+
+	if transport.conn == nil {
+		return 0, net.ErrClosed
+	}
+	return transport.conn.WriteTo(data, addr)
+}
+
+func (transport *UdpTransport) Close() error {
+	transport.connMutex.Lock()
+	defer transport.connMutex.Unlock()
+
+	if transport.conn == nil {
+		return nil
+	}
+	err := transport.conn.Close()
+	transport.conn = nil
+	return err
+}
+
+func (transport *UdpTransport) LocalAddr() net.Addr {
+	transport.connMutex.RLock()
+	defer transport.connMutex.RUnlock()
+
+	if transport.conn == nil {
+		return nil
+	}
+	return transport.conn.LocalAddr()
+}
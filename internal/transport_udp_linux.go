@@ -0,0 +1,34 @@
+//go:build linux
+
+package internal
+
+import (
+	"context"
+	"net"
+	"syscall"
+)
+
+// listenUdp listens on the given address. If iface is set, the socket is bound to that network interface using
+// SO_BINDTODEVICE, so it only receives (broadcast) datagrams arriving on that interface and sends via that interface.
+func listenUdp(addr *net.UDPAddr, iface string) (*net.UDPConn, error) {
+	if iface == "" {
+		return net.ListenUDP("udp", addr)
+	}
+	listenConfig := net.ListenConfig{
+		Control: func(_, _ string, conn syscall.RawConn) error {
+			var sockErr error
+			err := conn.Control(func(fd uintptr) {
+				sockErr = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, iface)
+			})
+			if err != nil {
+				return err
+			}
+			return sockErr
+		},
+	}
+	conn, err := listenConfig.ListenPacket(context.Background(), "udp4", addr.String())
+	if err != nil {
+		return nil, err
+	}
+	return conn.(*net.UDPConn), nil
+}
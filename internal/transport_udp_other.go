@@ -0,0 +1,31 @@
+//go:build !linux
+
+package internal
+
+import (
+	"fmt"
+	"net"
+)
+
+// listenUdp listens on the given address. If iface is set, the socket is bound to the first IPv4 address of that
+// network interface instead of the given IP. Note that on some platforms (e.g. macOS), a socket bound to a unicast
+// address does not receive broadcast datagrams.
+func listenUdp(addr *net.UDPAddr, iface string) (*net.UDPConn, error) {
+	if iface == "" {
+		return net.ListenUDP("udp", addr)
+	}
+	netIface, err := net.InterfaceByName(iface)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := netIface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, ifaceAddr := range addrs {
+		if ipNet, ok := ifaceAddr.(*net.IPNet); ok && ipNet.IP.To4() != nil {
+			return net.ListenUDP("udp", &net.UDPAddr{IP: ipNet.IP, Port: addr.Port})
+		}
+	}
+	return nil, fmt.Errorf("network interface %s has no IPv4 address", iface)
+}
@@ -0,0 +1,204 @@
+package emproto4go
+
+import (
+	"net"
+	"time"
+
+	"github.com/johnwoo-nl/emproto4go/internal"
+	"github.com/johnwoo-nl/emproto4go/types"
+)
+
+// Option configures a communicator created by CreateCommunicatorWithOptions.
+type Option func(options *internal.CommunicatorOptions)
+
+// WithPort sets the UDP port to listen on (default 28376). Note that EVSEs send their broadcasts to port 28376, so
+// only change this if some form of port forwarding is in place.
+//
+//goland:noinspection GoUnusedExportedFunction
+func WithPort(port int) Option {
+	return func(options *internal.CommunicatorOptions) {
+		options.Port = port
+	}
+}
+
+// WithBindAddress sets the IP address to listen on (default 0.0.0.0, i.e. all addresses). Note that on most platforms,
+// a socket bound to a unicast address does not receive broadcasts, so EVSEs would not be discovered; prefer
+// WithInterface on multi-homed hosts.
+//
+//goland:noinspection GoUnusedExportedFunction
+func WithBindAddress(ip net.IP) Option {
+	return func(options *internal.CommunicatorOptions) {
+		options.BindAddress = ip
+	}
+}
+
+// WithInterface makes the communicator listen only on the network interface with the given name (e.g. "eth1"). On
+// Linux, the socket is bound to the interface; on other platforms, it is bound to the interface's first IPv4 address.
+//
+//goland:noinspection GoUnusedExportedFunction
+func WithInterface(name string) Option {
+	return func(options *internal.CommunicatorOptions) {
+		options.Interface = name
+	}
+}
+
+// WithTransport makes the communicator use the given transport instead of a UDP socket. When set, WithPort,
+// WithBindAddress and WithInterface have no effect.
+//
+//goland:noinspection GoUnusedExportedFunction
+func WithTransport(transport types.EmTransport) Option {
+	return func(options *internal.CommunicatorOptions) {
+		options.Transport = transport
+	}
+}
+
+// WithTickInterval sets the interval at which the communicator checks online/login state of EVSEs and refreshes
+// their data (default 5s).
+//
+//goland:noinspection GoUnusedExportedFunction
+func WithTickInterval(interval time.Duration) Option {
+	return func(options *internal.CommunicatorOptions) {
+		options.TickInterval = interval
+	}
+}
+
+// WithOnlineTimeout sets the time after the last received datagram after which an EVSE is considered offline
+// (default 11s).
+//
+//goland:noinspection GoUnusedExportedFunction
+func WithOnlineTimeout(timeout time.Duration) Option {
+	return func(options *internal.CommunicatorOptions) {
+		options.OnlineTimeout = timeout
+	}
+}
+
+// WithLoginTimeout sets the time after the last login or heading after which a login session is considered expired
+// (default 15s).
+//
+//goland:noinspection GoUnusedExportedFunction
+func WithLoginTimeout(timeout time.Duration) Option {
+	return func(options *internal.CommunicatorOptions) {
+		options.LoginTimeout = timeout
+	}
+}
+
+// WithRequestTimeout sets the time to wait for a response to a request such as login, fetch, config set, or charge
+// start/stop (default 5s).
+//
+//goland:noinspection GoUnusedExportedFunction
+func WithRequestTimeout(timeout time.Duration) Option {
+	return func(options *internal.CommunicatorOptions) {
+		options.RequestTimeout = timeout
+	}
+}
+
+// WithConfigRequestTimeout sets the time to wait for a response to a config get request (default 8s). Config items
+// are fetched in parallel, so they get a longer timeout than other requests.
+//
+//goland:noinspection GoUnusedExportedFunction
+func WithConfigRequestTimeout(timeout time.Duration) Option {
+	return func(options *internal.CommunicatorOptions) {
+		options.ConfigRequestTimeout = timeout
+	}
+}
+
+// WithDebounce sets the event debounce window (default 400ms) and the maximum time an event can be held back by
+// debouncing (default 2000ms). A window of 0 disables debouncing, dispatching every event immediately.
+//
+//goland:noinspection GoUnusedExportedFunction
+func WithDebounce(window time.Duration, maxDelay time.Duration) Option {
+	return func(options *internal.CommunicatorOptions) {
+		options.DebounceWindow = window
+		options.DebounceMaxDelay = maxDelay
+	}
+}
+
+// WithStore makes the communicator persist known EVSEs (serial, last address, info, config, last charge and, without
+// a credential provider, password) to the given store, and restore them when it is started. See CreateJsonFileStore.
+//
+//goland:noinspection GoUnusedExportedFunction
+func WithStore(store types.EmStore) Option {
+	return func(options *internal.CommunicatorOptions) {
+		options.Store = store
+	}
+}
+
+// WithStoreSaveDelay sets the time changes to an EVSE's charging session are held back before saving them to the
+// store, so the store isn't rewritten every few seconds while charging (default 1 minute). Other changes are saved right
+// away, and pending changes when the communicator is stopped. Zero saves all changes right away.
+//
+//goland:noinspection GoUnusedExportedFunction
+func WithStoreSaveDelay(delay time.Duration) Option {
+	return func(options *internal.CommunicatorOptions) {
+		options.StoreSaveDelay = delay
+	}
+}
+
+// WithCredentialProvider makes the communicator get EVSE passwords from the given provider (for restored as well as
+// newly discovered EVSEs) instead of keeping them in the store. See CreateEnvCredentialProvider and
+// CreateEncryptedFileCredentialProvider.
+//
+//goland:noinspection GoUnusedExportedFunction
+func WithCredentialProvider(provider types.EmCredentialProvider) Option {
+	return func(options *internal.CommunicatorOptions) {
+		options.Credentials = provider
+	}
+}
+
+// WithRecorder makes the communicator pass every raw datagram it receives or sends (with timestamp, direction and
+// remote address) to the given recorder, e.g. to write a capture file for debugging. See CreateCaptureRecorder.
+//
+//goland:noinspection GoUnusedExportedFunction
+func WithRecorder(recorder types.EmRecorder) Option {
+	return func(options *internal.CommunicatorOptions) {
+		options.Recorder = recorder
+	}
+}
+
+// WithClock sets the default clock of EVSEs, which converts the timestamps in datagrams (see types.EmClock). Use this
+// if the host's timezone is not that of the EVSEs, e.g. in a container without a TZ setting. Timezones set per EVSE
+// with EmEvse.SetClock take precedence.
+//
+//goland:noinspection GoUnusedExportedFunction
+func WithClock(clock types.EmClock) Option {
+	return func(options *internal.CommunicatorOptions) {
+		options.Clock = clock
+	}
+}
+
+// WithRawCommands enables EmEvse.SendRaw, which sends arbitrary commands to EVSEs, e.g. for reverse-engineering
+// features of the OEM app. Without this option, SendRaw returns a types.RawCommandsDisabledError. Only use this for
+// experimentation: an EVSE may act on any command it receives, and the library doesn't track what raw commands change.
+//
+//goland:noinspection GoUnusedExportedFunction
+func WithRawCommands() Option {
+	return func(options *internal.CommunicatorOptions) {
+		options.RawCommands = true
+	}
+}
+
+// WithExperimentalCommands enables requests with commands whose layout is guessed from the OEM app, but not verified
+// with a physical EVSE or a capture of the OEM app: EmEvse.ChangePassword, EmEvse.GetSystemTime and
+// EmEvse.SetSystemTime, and with them the clock check of WithClockSync. Without this option, they return a
+// types.ExperimentalCommandsDisabledError. An EVSE may misinterpret such a command, so only use this to try these
+// features on an EVSE you can reset.
+//
+//goland:noinspection GoUnusedExportedFunction
+func WithExperimentalCommands() Option {
+	return func(options *internal.CommunicatorOptions) {
+		options.ExperimentalCommands = true
+	}
+}
+
+// WithClockSync enables a check after logging in that queues a types.EvseClockDrift event if the drift of an EVSE's
+// clock from the host's clock exceeds the threshold (zero, the default, disables the check). If autoSync is true, the
+// EVSE's clock is then set to the host's time, so scheduled charges start at the intended moment. Experimental: the
+// check uses the system time command, so it also requires WithExperimentalCommands.
+//
+//goland:noinspection GoUnusedExportedFunction
+func WithClockSync(threshold time.Duration, autoSync bool) Option {
+	return func(options *internal.CommunicatorOptions) {
+		options.ClockDriftThreshold = threshold
+		options.ClockAutoSync = autoSync
+	}
+}
@@ -0,0 +1,118 @@
+package protocol
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// EmCommand identifies the type of datagram. Commands sent by the app have the 0x8000 bit set; the response to such a
+// command usually has the same code without that bit.
+type EmCommand uint16
+
+const (
+	CmdLogin                 = EmCommand(0x0001)
+	CmdLoginResponse         = EmCommand(0x0002)
+	CmdLoginConfirm          = EmCommand(0x8001)
+	CmdRequestLogin          = EmCommand(0x8002)
+	CmdPasswordErrorResponse = EmCommand(0x0155)
+	CmdHeading               = EmCommand(0x0003)
+	CmdHeadingResponse       = EmCommand(0x8003)
+
+	CmdSingleACStatus    = EmCommand(0x0004) // Sent by EVSE unsolicited periodically when logged in.
+	CmdSingleACStatusAck = EmCommand(0x8004) // Ack for above.
+
+	CmdSingleACChargingPublicAuto     = EmCommand(0x0005) // Sent by EVSE unsolicited periodically when logged in.
+	CmdSingleACChargingAck            = EmCommand(0x8005) // Ack for above.
+	CmdRequestSingleACCharging        = EmCommand(0x8006) // Sent by client to explicitly request status.
+	CmdSingleACChargingStatusResponse = EmCommand(0x0006) // Response to above, same datagram layout as 0x0005.
+
+	CmdGetVersion         = EmCommand(0x8106) // Request version info (some fields not present in CmdLogin).
+	CmdGetVersionResponse = EmCommand(0x0106) // Version info.
+
+	CmdSetAndGetLanguage                = EmCommand(0x810F)
+	CmdSetAndGetLanguageResponse        = EmCommand(0x010F)
+	CmdSetAndGetName                    = EmCommand(0x8108)
+	CmdSetAndGetNameResponse            = EmCommand(0x0108)
+	CmdSetAndGetOfflineCharge           = EmCommand(0x810D)
+	CmdSetAndGetOfflineChargeResponse   = EmCommand(0x010D)
+	CmdSetAndGetMaxCurrent              = EmCommand(0x8107)
+	CmdSetAndGetMaxCurrentResponse      = EmCommand(0x0107)
+	CmdSetAndGetTemperatureUnit         = EmCommand(0x8112)
+	CmdSetAndGetTemperatureUnitResponse = EmCommand(0x0112)
+
+	// Get or set the EVSE's clock. Experimental: the layout (like the other CmdSetAndGet* commands: action, then a
+	// timestamp) is guessed from the OEM app, but not verified with a physical EVSE or a capture of the OEM app.
+	CmdSetAndGetSystemTime         = EmCommand(0x8101)
+	CmdSetAndGetSystemTimeResponse = EmCommand(0x0101)
+
+	// Change the EVSE's password. Experimental: the current password is in the datagram as usual, and the payload is
+	// assumed to be like that of the CmdSetAndGet* commands (action, then the new password), but this is not verified
+	// with a physical EVSE or a capture of the OEM app.
+	CmdSetPassword         = EmCommand(0x8109)
+	CmdSetPasswordResponse = EmCommand(0x0109)
+
+	CmdChargeStart         = EmCommand(0x8007)
+	CmdChargeStartResponse = EmCommand(0x0007)
+	CmdChargeStop          = EmCommand(0x8008)
+	CmdChargeStopResponse  = EmCommand(0x0008)
+)
+
+var commandNames = map[EmCommand]string{
+	CmdLogin:                            "CmdLogin",
+	CmdLoginResponse:                    "CmdLoginResponse",
+	CmdLoginConfirm:                     "CmdLoginConfirm",
+	CmdRequestLogin:                     "CmdRequestLogin",
+	CmdPasswordErrorResponse:            "CmdPasswordErrorResponse",
+	CmdHeading:                          "CmdHeading",
+	CmdHeadingResponse:                  "CmdHeadingResponse",
+	CmdSingleACStatus:                   "CmdSingleACStatus",
+	CmdSingleACStatusAck:                "CmdSingleACStatusAck",
+	CmdSingleACChargingPublicAuto:       "CmdSingleACChargingPublicAuto",
+	CmdSingleACChargingAck:              "CmdSingleACChargingAck",
+	CmdGetVersion:                       "CmdGetVersion",
+	CmdGetVersionResponse:               "CmdGetVersionResponse",
+	CmdSetAndGetLanguage:                "CmdSetAndGetLanguage",
+	CmdSetAndGetLanguageResponse:        "CmdSetAndGetLanguageResponse",
+	CmdSetAndGetName:                    "CmdSetAndGetName",
+	CmdSetAndGetNameResponse:            "CmdSetAndGetNameResponse",
+	CmdSetAndGetOfflineCharge:           "CmdSetAndGetOfflineCharge",
+	CmdSetAndGetOfflineChargeResponse:   "CmdSetAndGetOfflineChargeResponse",
+	CmdSetAndGetMaxCurrent:              "CmdSetAndGetMaxCurrent",
+	CmdSetAndGetMaxCurrentResponse:      "CmdSetAndGetMaxCurrentResponse",
+	CmdSetAndGetTemperatureUnit:         "CmdSetAndGetTemperatureUnit",
+	CmdSetAndGetTemperatureUnitResponse: "CmdSetAndGetTemperatureUnitResponse",
+	CmdSetAndGetSystemTime:              "CmdSetAndGetSystemTime",
+	CmdSetAndGetSystemTimeResponse:      "CmdSetAndGetSystemTimeResponse",
+	CmdSetPassword:                      "CmdSetPassword",
+	CmdSetPasswordResponse:              "CmdSetPasswordResponse",
+	CmdRequestSingleACCharging:          "CmdRequestSingleACCharging",
+	CmdSingleACChargingStatusResponse:   "CmdSingleACChargingStatusResponse",
+	CmdChargeStart:                      "CmdChargeStart",
+	CmdChargeStartResponse:              "CmdChargeStartResponse",
+	CmdChargeStop:                       "CmdChargeStop",
+	CmdChargeStopResponse:               "CmdChargeStopResponse",
+}
+
+func (e EmCommand) String() string {
+	str := fmt.Sprintf("0x%04x", uint16(e))
+	if name, ok := commandNames[e]; ok {
+		return str + ":" + name
+	}
+	return str
+}
+
+// Name returns the name of the command (e.g. "CmdLogin"), or "" if the command is unknown.
+func (e EmCommand) Name() string {
+	return commandNames[e]
+}
+
+// SentByApp returns whether the command is sent by the app (rather than by the EVSE).
+func (e EmCommand) SentByApp() bool {
+	return e&0x8000 != 0
+}
+
+// KnownCommands returns all known commands, sorted by code.
+func KnownCommands() []EmCommand {
+	return slices.Sorted(maps.Keys(commandNames))
+}
@@ -1,4 +1,7 @@
-package internal
+// Package protocol implements the EVSEMaster UDP protocol: the datagram codec, the command codes and the payload
+// layouts of the known commands, both as schemas (see PayloadSchema) and as typed structs (see CommandPayload). It is
+// used by the communicator and the simulator, and can be used to build other tools such as sniffers and proxies.
+package protocol
 
 import (
 	"encoding/binary"
@@ -7,6 +10,8 @@ import (
 	"github.com/johnwoo-nl/emproto4go/types"
 )
 
+// Datagram is a decoded EVSEMaster datagram. On the wire, it consists of the magic 0x0601, the length, the key, the
+// serial (8 bytes), the password (6 bytes), the command, the payload, a checksum and the tail 0x0F02, all big-endian.
 type Datagram struct {
 	Key      byte
 	Serial   types.EmSerial
@@ -15,6 +20,8 @@ type Datagram struct {
 	Payload  []byte
 }
 
+// Encode returns the raw bytes of the datagram. Serial must be 16 hex characters; a password that is not 6 characters
+// long is sent as zeroes.
 func (datagram *Datagram) Encode() ([]byte, error) {
 	if datagram == nil {
 		return nil, fmt.Errorf("datagram is nil")
@@ -54,15 +61,40 @@ func (datagram *Datagram) Encode() ([]byte, error) {
 	binary.BigEndian.PutUint16(data[19:21], uint16(datagram.Command))
 	copy(data[21:21+len(datagram.Payload)], datagram.Payload)
 
-	var checksum uint16
-	for _, b := range data[:len(data)-4] {
-		checksum = (checksum + uint16(b)) % 0xffff
-	}
-	binary.BigEndian.PutUint16(data[len(data)-4:len(data)-2], checksum)
+	binary.BigEndian.PutUint16(data[len(data)-4:len(data)-2], checksum(data))
 	binary.BigEndian.PutUint16(data[len(data)-2:], 0x0F02)
 	return data, nil
 }
 
+// checksum computes the checksum of a raw datagram, which covers everything before the checksum and tail.
+func checksum(data []byte) uint16 {
+	var sum uint16
+	for _, b := range data[:len(data)-4] {
+		sum = (sum + uint16(b)) % 0xffff
+	}
+	return sum
+}
+
+// RedactPassword returns a copy of the raw datagram with the password (bytes 13-18, and the new password in the payload
+// of CmdSetPassword and its response) zeroed and the checksum recomputed, so the result still decodes. Data that is
+// not an EvseMaster datagram is returned as an unchanged copy.
+func RedactPassword(data []byte) []byte {
+	redacted := make([]byte, len(data))
+	copy(redacted, data)
+	if len(data) < 25 || binary.BigEndian.Uint16(data[0:2]) != 0x0601 || binary.BigEndian.Uint16(data[2:4]) != uint16(len(data)) {
+		return redacted
+	}
+	clear(redacted[13:19])
+	command := EmCommand(binary.BigEndian.Uint16(data[19:21]))
+	if (command == CmdSetPassword || command == CmdSetPasswordResponse) && len(data) >= 21+PasswordSchema.MinLength()+4 {
+		clear(redacted[22:28])
+	}
+	binary.BigEndian.PutUint16(redacted[len(redacted)-4:len(redacted)-2], checksum(redacted))
+	return redacted
+}
+
+// Decode decodes a raw datagram. Returns nil without error if the data is not an EVSEMaster datagram, or an
+// InvalidDatagramError if its checksum is incorrect.
 func Decode(data []byte) (*Datagram, error) {
 	// This is not an EvseMaster datagram. Don't return an error, just nil to indicate not handled.
 	if len(data) < 25 {
@@ -81,10 +113,7 @@ func Decode(data []byte) (*Datagram, error) {
 	}
 
 	packetChecksum := binary.BigEndian.Uint16(data[len(data)-4 : len(data)-2])
-	var computedChecksum uint16
-	for _, b := range data[:len(data)-4] {
-		computedChecksum = (computedChecksum + uint16(b)) % 0xffff
-	}
+	computedChecksum := checksum(data)
 	if computedChecksum != packetChecksum {
 		return nil, InvalidDatagramError{Message: fmt.Sprintf("checksum mismatch, computed %04x does not match %04x from packet", computedChecksum, packetChecksum)}
 	}
@@ -123,3 +152,11 @@ func (datagram *Datagram) String() string {
 		datagram.Command, datagram.Serial, datagram.Key, pwd, payloadStr, len(datagram.Payload),
 	)
 }
+
+type InvalidDatagramError struct {
+	Message string
+}
+
+func (err InvalidDatagramError) Error() string {
+	return fmt.Sprintf("Invalid datagram: %s", err.Message)
+}
@@ -0,0 +1,569 @@
+package protocol
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/johnwoo-nl/emproto4go/types"
+)
+
+// CommandPayload is a typed payload of a known command. Encode returns its raw bytes, which can be used as
+// Datagram.Payload.
+type CommandPayload interface {
+	Encode() []byte
+}
+
+// DecodePayload decodes the payload of a datagram into the typed payload for its command (e.g. LoginPayload for
+// CmdLogin). Returns nil without error for commands with an unknown payload layout.
+func DecodePayload(datagram *Datagram) (CommandPayload, error) {
+	switch datagram.Command {
+	case CmdLogin, CmdLoginResponse:
+		return DecodeLoginPayload(datagram.Payload)
+	case CmdSingleACStatus:
+		return DecodeStatusPayload(datagram.Payload)
+	case CmdSingleACChargingPublicAuto, CmdSingleACChargingStatusResponse:
+		return DecodeChargingPayload(datagram.Payload)
+	case CmdGetVersionResponse:
+		return DecodeVersionPayload(datagram.Payload)
+	case CmdChargeStart:
+		return DecodeChargeStartPayload(datagram.Payload)
+	case CmdChargeStartResponse:
+		return DecodeChargeStartResponsePayload(datagram.Payload)
+	case CmdChargeStop:
+		return DecodeChargeStopPayload(datagram.Payload)
+	case CmdChargeStopResponse:
+		return DecodeChargeStopResponsePayload(datagram.Payload)
+	case CmdSetAndGetLanguage, CmdSetAndGetLanguageResponse, CmdSetAndGetName, CmdSetAndGetNameResponse,
+		CmdSetAndGetOfflineCharge, CmdSetAndGetOfflineChargeResponse, CmdSetAndGetMaxCurrent,
+		CmdSetAndGetMaxCurrentResponse, CmdSetAndGetTemperatureUnit, CmdSetAndGetTemperatureUnitResponse:
+		return DecodeConfigPayload(datagram.Payload)
+	case CmdSetAndGetSystemTime, CmdSetAndGetSystemTimeResponse:
+		return DecodeSystemTimePayload(datagram.Payload)
+	case CmdSetPassword, CmdSetPasswordResponse:
+		return DecodePasswordPayload(datagram.Payload)
+	case CmdRequestLogin, CmdLoginConfirm, CmdPasswordErrorResponse, CmdHeading, CmdHeadingResponse,
+		CmdSingleACStatusAck, CmdSingleACChargingAck, CmdRequestSingleACCharging, CmdGetVersion:
+		return DecodeBytePayload(datagram.Payload)
+	}
+	return nil, nil
+}
+
+// LoginPayload is the payload of CmdLogin and CmdLoginResponse, describing the EVSE.
+type LoginPayload struct {
+	EvseType        byte
+	Brand           string // Up to 32 characters; characters after the first 16 need a 151-byte payload.
+	Model           string // Up to 32 characters; characters after the first 16 need a 151-byte payload.
+	HardwareVersion string
+	MaxPower        types.Watts
+	MaxCurrent      types.Amps
+	Byte70          byte // Only present in payloads of at least 119 bytes.
+
+	// Length is the length of the payload. When encoding, the payload is made longer if needed for Byte70 or for
+	// long brands and models.
+	Length int
+}
+
+func DecodeLoginPayload(data []byte) (LoginPayload, error) {
+	payload, err := LoginSchema.Decode(data)
+	if err != nil {
+		return LoginPayload{}, err
+	}
+	return LoginPayload{
+		EvseType:        payload.Byte("evse_type"),
+		Brand:           payload.String("brand") + payload.String("brand_ext"),
+		Model:           payload.String("model") + payload.String("model_ext"),
+		HardwareVersion: payload.String("hardware_version"),
+		MaxPower:        types.Watts(payload.Uint("max_power")),
+		MaxCurrent:      types.Amps(payload.Byte("max_current")),
+		Byte70:          payload.Byte("byte70"),
+		Length:          len(data),
+	}, nil
+}
+
+func (login LoginPayload) Encode() []byte {
+	length := login.Length
+	if len(login.Brand) > 16 || len(login.Model) > 16 {
+		length = max(length, 151)
+	} else if login.Byte70 != 0 {
+		length = max(length, 119)
+	}
+
+	payload := LoginSchema.New(length)
+	payload.SetUint("evse_type", uint64(login.EvseType))
+	payload.SetString("brand", login.Brand)
+	payload.SetString("model", login.Model)
+	payload.SetString("hardware_version", login.HardwareVersion)
+	payload.SetUint("max_power", uint64(login.MaxPower))
+	payload.SetUint("max_current", uint64(login.MaxCurrent))
+	payload.SetUint("byte70", uint64(login.Byte70))
+	if len(login.Brand) > 16 {
+		payload.SetString("brand_ext", login.Brand[16:])
+	}
+	if len(login.Model) > 16 {
+		payload.SetString("model_ext", login.Model[16:])
+	}
+	return payload.Data
+}
+
+// StatusPayload is the payload of CmdSingleACStatus, sent periodically by a logged-in EVSE.
+type StatusPayload struct {
+	LineId            types.LineId
+	L1Voltage         types.Volts
+	L1Current         types.Amps
+	L2Voltage         types.Volts // Only present in payloads of at least 33 bytes (3-phase EVSEs).
+	L2Current         types.Amps  // Only present in payloads of at least 33 bytes (3-phase EVSEs).
+	L3Voltage         types.Volts // Only present in payloads of at least 33 bytes (3-phase EVSEs).
+	L3Current         types.Amps  // Only present in payloads of at least 33 bytes (3-phase EVSEs).
+	Power             types.Watts
+	EnergyCounter     types.KWh
+	InnerTemp         *types.TempCelsius // nil if not set.
+	OuterTemp         *types.TempCelsius // nil if not set.
+	EmergencyBtnState types.EmEmergencyBtnState
+	GunState          types.EmGunState
+	OutputState       types.EmOutputState
+	CurrentState      types.EmCurrentState
+	Errors            uint32               // Bit n set means types.EmError(n) is active.
+	NewProtocolState  types.EmCurrentState // Only present in payloads of at least 35 bytes.
+
+	// Length is the length of the payload (at least 25 bytes). When encoding, the payload is made longer if needed for
+	// nonzero L2/L3 values or NewProtocolState.
+	Length int
+}
+
+func DecodeStatusPayload(data []byte) (StatusPayload, error) {
+	payload, err := SingleACStatusSchema.Decode(data)
+	if err != nil {
+		return StatusPayload{}, err
+	}
+	return StatusPayload{
+		LineId:            types.LineId(payload.Byte("line_id")),
+		L1Voltage:         types.Volts(payload.Float("l1_voltage")),
+		L1Current:         types.Amps(payload.Float("l1_current")),
+		L2Voltage:         types.Volts(payload.Float("l2_voltage")),
+		L2Current:         types.Amps(payload.Float("l2_current")),
+		L3Voltage:         types.Volts(payload.Float("l3_voltage")),
+		L3Current:         types.Amps(payload.Float("l3_current")),
+		Power:             types.Watts(payload.Uint("power")),
+		EnergyCounter:     types.KWh(payload.Float("energy_counter")),
+		InnerTemp:         readTemperature(payload, "inner_temp"),
+		OuterTemp:         readTemperature(payload, "outer_temp"),
+		EmergencyBtnState: types.EmEmergencyBtnState(payload.Byte("emergency_btn_state")),
+		GunState:          types.EmGunState(payload.Byte("gun_state")),
+		OutputState:       types.EmOutputState(payload.Byte("output_state")),
+		CurrentState:      types.EmCurrentState(payload.Byte("current_state")),
+		Errors:            uint32(payload.Uint("errors")),
+		NewProtocolState:  types.EmCurrentState(payload.Byte("new_protocol_state")),
+		Length:            len(data),
+	}, nil
+}
+
+func (status StatusPayload) Encode() []byte {
+	length := status.Length
+	if status.NewProtocolState != 0 {
+		length = max(length, 35)
+	} else if status.L2Voltage != 0 || status.L2Current != 0 || status.L3Voltage != 0 || status.L3Current != 0 {
+		length = max(length, 33)
+	}
+
+	payload := SingleACStatusSchema.New(length)
+	payload.SetUint("line_id", uint64(status.LineId))
+	payload.SetFloat("l1_voltage", float64(status.L1Voltage))
+	payload.SetFloat("l1_current", float64(status.L1Current))
+	payload.SetFloat("l2_voltage", float64(status.L2Voltage))
+	payload.SetFloat("l2_current", float64(status.L2Current))
+	payload.SetFloat("l3_voltage", float64(status.L3Voltage))
+	payload.SetFloat("l3_current", float64(status.L3Current))
+	payload.SetUint("power", uint64(status.Power))
+	payload.SetFloat("energy_counter", float64(status.EnergyCounter))
+	writeTemperature(payload, "inner_temp", status.InnerTemp)
+	writeTemperature(payload, "outer_temp", status.OuterTemp)
+	payload.SetUint("emergency_btn_state", uint64(status.EmergencyBtnState))
+	payload.SetUint("gun_state", uint64(status.GunState))
+	payload.SetUint("output_state", uint64(status.OutputState))
+	payload.SetUint("current_state", uint64(status.CurrentState))
+	payload.SetUint("errors", uint64(status.Errors))
+	payload.SetUint("new_protocol_state", uint64(status.NewProtocolState))
+	return payload.Data
+}
+
+// ChargingPayload is the payload of CmdSingleACChargingPublicAuto (sent periodically by a logged-in EVSE) and
+// CmdSingleACChargingStatusResponse, describing the current or last charging session.
+type ChargingPayload struct {
+	Port                 byte
+	ChargeState          types.EmCurrentState
+	ChargeId             types.ChargeId
+	StartType            byte
+	ChargeType           byte
+	MaxDuration          *time.Duration // nil means no limit; whole minutes.
+	MaxEnergy            *types.KWh     // nil means no limit.
+	ReservationTime      *time.Time     // nil if not set.
+	UserId               types.UserId
+	MaxCurrent           types.Amps
+	StartTime            *time.Time // nil if not set.
+	Duration             time.Duration
+	StartEnergyCounter   *types.KWh // nil if not set.
+	CurrentEnergyCounter *types.KWh // nil if not set.
+	ChargedEnergy        *types.KWh // nil if not set.
+	ChargePrice          float32
+	FeeType              byte
+	ChargeFee            float32
+	NewProtocolState     types.EmCurrentState // Only present in payloads of at least 75 bytes.
+
+	// Length is the length of the payload (at least 74 bytes). When encoding, the payload is made longer if needed for
+	// a nonzero NewProtocolState.
+	Length int
+}
+
+func DecodeChargingPayload(data []byte) (ChargingPayload, error) {
+	payload, err := SingleACChargingSchema.Decode(data)
+	if err != nil {
+		return ChargingPayload{}, err
+	}
+	return ChargingPayload{
+		Port:                 payload.Byte("port"),
+		ChargeState:          types.EmCurrentState(payload.Byte("charge_state")),
+		ChargeId:             types.ChargeId(payload.String("charge_id")),
+		StartType:            payload.Byte("start_type"),
+		ChargeType:           payload.Byte("charge_type"),
+		MaxDuration:          payload.Duration("max_duration"),
+		MaxEnergy:            readEnergy(payload, "max_energy"),
+		ReservationTime:      payload.Time("reservation_time"),
+		UserId:               types.UserId(payload.String("user_id")),
+		MaxCurrent:           types.Amps(payload.Byte("max_current")),
+		StartTime:            payload.Time("start_time"),
+		Duration:             time.Duration(payload.Uint("duration")) * time.Second,
+		StartEnergyCounter:   readEnergy(payload, "start_energy_counter"),
+		CurrentEnergyCounter: readEnergy(payload, "current_energy_counter"),
+		ChargedEnergy:        readEnergy(payload, "charged_energy"),
+		ChargePrice:          float32(payload.Float("charge_price")),
+		FeeType:              payload.Byte("fee_type"),
+		ChargeFee:            float32(payload.Float("charge_fee")),
+		NewProtocolState:     types.EmCurrentState(payload.Byte("new_protocol_state")),
+		Length:               len(data),
+	}, nil
+}
+
+func (charging ChargingPayload) Encode() []byte {
+	length := charging.Length
+	if charging.NewProtocolState != 0 {
+		length = max(length, 75)
+	}
+
+	payload := SingleACChargingSchema.New(length)
+	payload.SetUint("port", uint64(charging.Port))
+	payload.SetUint("charge_state", uint64(charging.ChargeState))
+	payload.SetString("charge_id", string(charging.ChargeId))
+	payload.SetUint("start_type", uint64(charging.StartType))
+	payload.SetUint("charge_type", uint64(charging.ChargeType))
+	payload.SetDuration("max_duration", charging.MaxDuration)
+	writeEnergy(payload, "max_energy", charging.MaxEnergy)
+	payload.SetTime("reservation_time", charging.ReservationTime)
+	payload.SetString("user_id", string(charging.UserId))
+	payload.SetUint("max_current", uint64(charging.MaxCurrent))
+	payload.SetTime("start_time", charging.StartTime)
+	payload.SetDuration("duration", &charging.Duration)
+	writeEnergy(payload, "start_energy_counter", charging.StartEnergyCounter)
+	writeEnergy(payload, "current_energy_counter", charging.CurrentEnergyCounter)
+	writeEnergy(payload, "charged_energy", charging.ChargedEnergy)
+	payload.SetFloat("charge_price", float64(charging.ChargePrice))
+	payload.SetUint("fee_type", uint64(charging.FeeType))
+	payload.SetFloat("charge_fee", float64(charging.ChargeFee))
+	payload.SetUint("new_protocol_state", uint64(charging.NewProtocolState))
+	return payload.Data
+}
+
+// VersionPayload is the payload of CmdGetVersionResponse.
+type VersionPayload struct {
+	HardwareVersion string
+	SoftwareVersion string
+	Feature         uint32
+	SupportNew      byte // Only present in payloads of at least 37 bytes.
+
+	// Length is the length of the payload (at least 36 bytes). When encoding, the payload is made longer if needed for
+	// a nonzero SupportNew.
+	Length int
+}
+
+func DecodeVersionPayload(data []byte) (VersionPayload, error) {
+	payload, err := VersionSchema.Decode(data)
+	if err != nil {
+		return VersionPayload{}, err
+	}
+	return VersionPayload{
+		HardwareVersion: payload.String("hardware_version"),
+		SoftwareVersion: payload.String("software_version"),
+		Feature:         uint32(payload.Uint("feature")),
+		SupportNew:      payload.Byte("support_new"),
+		Length:          len(data),
+	}, nil
+}
+
+func (version VersionPayload) Encode() []byte {
+	length := version.Length
+	if version.SupportNew != 0 {
+		length = max(length, 37)
+	}
+
+	payload := VersionSchema.New(length)
+	payload.SetString("hardware_version", version.HardwareVersion)
+	payload.SetString("software_version", version.SoftwareVersion)
+	payload.SetUint("feature", uint64(version.Feature))
+	payload.SetUint("support_new", uint64(version.SupportNew))
+	return payload.Data
+}
+
+// ChargeStartPayload is the payload of CmdChargeStart.
+type ChargeStartPayload struct {
+	LineId        types.LineId
+	UserId        types.UserId
+	ChargeId      types.ChargeId
+	IsReservation bool
+	StartTime     *time.Time // nil if not set.
+	StartType     byte
+	ChargeType    byte
+	MaxDuration   *time.Duration // nil means no limit; whole minutes.
+	MaxEnergy     *types.KWh     // nil means no limit.
+	Param3        uint16         // Meaning unknown; always 0xFFFF in the OEM app.
+	MaxCurrent    types.Amps
+}
+
+func DecodeChargeStartPayload(data []byte) (ChargeStartPayload, error) {
+	payload, err := ChargeStartSchema.Decode(data)
+	if err != nil {
+		return ChargeStartPayload{}, err
+	}
+	return ChargeStartPayload{
+		LineId:        types.LineId(payload.Byte("line_id")),
+		UserId:        types.UserId(payload.String("user_id")),
+		ChargeId:      types.ChargeId(payload.String("charge_id")),
+		IsReservation: payload.Byte("is_reservation") == 1,
+		StartTime:     payload.Time("start_time"),
+		StartType:     payload.Byte("start_type"),
+		ChargeType:    payload.Byte("charge_type"),
+		MaxDuration:   payload.Duration("max_duration"),
+		MaxEnergy:     readEnergy(payload, "max_energy"),
+		Param3:        uint16(payload.Uint("param3")),
+		MaxCurrent:    types.Amps(payload.Byte("max_current")),
+	}, nil
+}
+
+func (start ChargeStartPayload) Encode() []byte {
+	payload := ChargeStartSchema.New(0)
+	payload.SetUint("line_id", uint64(start.LineId))
+	payload.SetString("user_id", string(start.UserId))
+	payload.SetString("charge_id", string(start.ChargeId))
+	if start.IsReservation {
+		payload.SetUint("is_reservation", 1)
+	}
+	payload.SetTime("start_time", start.StartTime)
+	payload.SetUint("start_type", uint64(start.StartType))
+	payload.SetUint("charge_type", uint64(start.ChargeType))
+	payload.SetDuration("max_duration", start.MaxDuration)
+	writeEnergy(payload, "max_energy", start.MaxEnergy)
+	payload.SetUint("param3", uint64(start.Param3))
+	payload.SetUint("max_current", uint64(start.MaxCurrent))
+	return payload.Data
+}
+
+// ChargeStartResponsePayload is the payload of CmdChargeStartResponse.
+type ChargeStartResponsePayload struct {
+	LineId      types.LineId
+	ErrorReason types.ChargeStartErrorReason
+	Current     types.Amps
+}
+
+func DecodeChargeStartResponsePayload(data []byte) (ChargeStartResponsePayload, error) {
+	payload, err := ChargeStartResponseSchema.Decode(data)
+	if err != nil {
+		return ChargeStartResponsePayload{}, err
+	}
+	return ChargeStartResponsePayload{
+		LineId:      types.LineId(payload.Byte("line_id")),
+		ErrorReason: types.ChargeStartErrorReason(payload.Byte("error_reason")),
+		Current:     types.Amps(payload.Byte("current")),
+	}, nil
+}
+
+func (response ChargeStartResponsePayload) Encode() []byte {
+	payload := ChargeStartResponseSchema.New(0)
+	payload.SetUint("line_id", uint64(response.LineId))
+	payload.SetUint("error_reason", uint64(response.ErrorReason))
+	payload.SetUint("current", uint64(response.Current))
+	return payload.Data
+}
+
+// ChargeStopPayload is the payload of CmdChargeStop.
+type ChargeStopPayload struct {
+	LineId types.LineId
+	UserId types.UserId
+}
+
+func DecodeChargeStopPayload(data []byte) (ChargeStopPayload, error) {
+	payload, err := ChargeStopSchema.Decode(data)
+	if err != nil {
+		return ChargeStopPayload{}, err
+	}
+	return ChargeStopPayload{
+		LineId: types.LineId(payload.Byte("line_id")),
+		UserId: types.UserId(payload.String("user_id")),
+	}, nil
+}
+
+func (stop ChargeStopPayload) Encode() []byte {
+	payload := ChargeStopSchema.New(0)
+	payload.SetUint("line_id", uint64(stop.LineId))
+	payload.SetString("user_id", string(stop.UserId))
+	return payload.Data
+}
+
+// ChargeStopResponsePayload is the payload of CmdChargeStopResponse.
+type ChargeStopResponsePayload struct {
+	LineId      types.LineId
+	ErrorReason types.ChargeStopErrorReason
+}
+
+func DecodeChargeStopResponsePayload(data []byte) (ChargeStopResponsePayload, error) {
+	payload, err := ChargeStopResponseSchema.Decode(data)
+	if err != nil {
+		return ChargeStopResponsePayload{}, err
+	}
+	return ChargeStopResponsePayload{
+		LineId:      types.LineId(payload.Byte("line_id")),
+		ErrorReason: types.ChargeStopErrorReason(payload.Byte("error_reason")),
+	}, nil
+}
+
+func (response ChargeStopResponsePayload) Encode() []byte {
+	payload := ChargeStopResponseSchema.New(0)
+	payload.SetUint("line_id", uint64(response.LineId))
+	payload.SetUint("error_reason", uint64(response.ErrorReason))
+	return payload.Data
+}
+
+// ConfigAction is the first byte of the payload of the CmdSetAndGet* commands and their responses.
+type ConfigAction byte
+
+const (
+	ConfigSet = ConfigAction(0x01)
+	ConfigGet = ConfigAction(0x02)
+)
+
+// ConfigPayload is the payload of the CmdSetAndGet* commands and their responses. Value is a single byte for all
+// config items except the name (CmdSetAndGetName), which is a 32-byte string field. For ConfigGet requests, Value is
+// zeroes of the same length.
+type ConfigPayload struct {
+	Action ConfigAction
+	Value  []byte
+}
+
+func DecodeConfigPayload(data []byte) (ConfigPayload, error) {
+	if len(data) < 2 {
+		return ConfigPayload{}, fmt.Errorf("config payload too short (need at least 2 bytes, got %d)", len(data))
+	}
+	return ConfigPayload{Action: ConfigAction(data[0]), Value: data[1:]}, nil
+}
+
+func (config ConfigPayload) Encode() []byte {
+	return append([]byte{byte(config.Action)}, config.Value...)
+}
+
+// SystemTimePayload is the payload of CmdSetAndGetSystemTime and its response. Time is converted with the default
+// clock; use SystemTimeSchema with Payload.Clock for an EVSE with another clock. For ConfigGet requests, Time is nil.
+type SystemTimePayload struct {
+	Action ConfigAction
+	Time   *time.Time
+}
+
+func DecodeSystemTimePayload(data []byte) (SystemTimePayload, error) {
+	payload, err := SystemTimeSchema.Decode(data)
+	if err != nil {
+		return SystemTimePayload{}, err
+	}
+	return SystemTimePayload{
+		Action: ConfigAction(payload.Byte("action")),
+		Time:   payload.Time("time"),
+	}, nil
+}
+
+func (systemTime SystemTimePayload) Encode() []byte {
+	payload := SystemTimeSchema.New(0)
+	payload.SetUint("action", uint64(systemTime.Action))
+	if systemTime.Time != nil {
+		payload.SetTime("time", systemTime.Time)
+	}
+	return payload.Data
+}
+
+// PasswordPayload is the payload of CmdSetPassword and its response.
+type PasswordPayload struct {
+	Action   ConfigAction
+	Password types.EmPassword
+}
+
+func DecodePasswordPayload(data []byte) (PasswordPayload, error) {
+	payload, err := PasswordSchema.Decode(data)
+	if err != nil {
+		return PasswordPayload{}, err
+	}
+	return PasswordPayload{
+		Action:   ConfigAction(payload.Byte("action")),
+		Password: types.EmPassword(payload.String("password")),
+	}, nil
+}
+
+func (password PasswordPayload) Encode() []byte {
+	payload := PasswordSchema.New(0)
+	payload.SetUint("action", uint64(password.Action))
+	payload.SetString("password", string(password.Password))
+	return payload.Data
+}
+
+// BytePayload is the single-byte payload of the commands that carry no information besides their command code, such
+// as acks, CmdHeading(Response), CmdRequestLogin and CmdLoginConfirm. The value is usually 0 (1 for
+// CmdSingleACStatusAck). CmdGetVersion has an empty payload, which decodes as value 0 and encodes as a single byte.
+type BytePayload struct {
+	Value byte
+}
+
+func DecodeBytePayload(data []byte) (BytePayload, error) {
+	if len(data) == 0 {
+		return BytePayload{}, nil
+	}
+	return BytePayload{Value: data[0]}, nil
+}
+
+func (payload BytePayload) Encode() []byte {
+	return []byte{payload.Value}
+}
+
+func readTemperature(payload Payload, name string) *types.TempCelsius {
+	if !payload.IsSet(name) {
+		return nil
+	}
+	temp := types.TempCelsius(payload.Float(name))
+	return &temp
+}
+
+func writeTemperature(payload Payload, name string, temp *types.TempCelsius) {
+	if temp == nil {
+		payload.Unset(name)
+		return
+	}
+	payload.SetFloat(name, float64(*temp))
+}
+
+func readEnergy(payload Payload, name string) *types.KWh {
+	if !payload.IsSet(name) {
+		return nil
+	}
+	energy := types.KWh(payload.Float(name))
+	return &energy
+}
+
+func writeEnergy(payload Payload, name string, energy *types.KWh) {
+	if energy == nil {
+		payload.Unset(name)
+		return
+	}
+	payload.SetFloat(name, float64(*energy))
+}
@@ -0,0 +1,234 @@
+package protocol_test
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/johnwoo-nl/emproto4go/protocol"
+	"github.com/johnwoo-nl/emproto4go/types"
+)
+
+func ptr[T any](value T) *T {
+	return &value
+}
+
+// TestPayloadRoundTrip encodes each typed payload and checks that decoding it yields the same payload.
+func TestPayloadRoundTrip(t *testing.T) {
+	// Times as they decode, i.e. whole seconds at the EVSE's location.
+	startTime := protocol.EmTimestampToTime(1780000000)
+	reservationTime := protocol.EmTimestampToTime(1780003600)
+
+	tests := []struct {
+		name    string
+		payload protocol.CommandPayload
+		decode  func(data []byte) (protocol.CommandPayload, error)
+	}{
+		{"login", protocol.LoginPayload{EvseType: 1, Brand: "Besen", Model: "BS20", HardwareVersion: "HW1.0",
+			MaxPower: 7400, MaxCurrent: 32, Length: 54}, decodeWith(protocol.DecodeLoginPayload)},
+		{"login with byte 70", protocol.LoginPayload{EvseType: 22, Brand: "Besen", Model: "BS20", Byte70: 5,
+			Length: 119}, decodeWith(protocol.DecodeLoginPayload)},
+		{"login with long brand and model", protocol.LoginPayload{EvseType: 1, Brand: "A brand name longer than 16",
+			Model: "A model name longer than 16", Length: 151}, decodeWith(protocol.DecodeLoginPayload)},
+		{"status", protocol.StatusPayload{LineId: 1, L1Voltage: 230, L1Current: 16, Power: 3680, EnergyCounter: 123.45,
+			InnerTemp: ptr(types.TempCelsius(35.5)), EmergencyBtnState: 0, GunState: 4, OutputState: 1,
+			CurrentState: types.Charging, Errors: 0x08, Length: 25}, decodeWith(protocol.DecodeStatusPayload)},
+		{"status, three phase", protocol.StatusPayload{LineId: 1, L1Voltage: 230, L2Voltage: 231, L3Voltage: 232,
+			L1Current: 16, L2Current: 15, L3Current: 14, OuterTemp: ptr(types.TempCelsius(-5)), Length: 33},
+			decodeWith(protocol.DecodeStatusPayload)},
+		{"status, new protocol", protocol.StatusPayload{LineId: 1, NewProtocolState: 18, Length: 35},
+			decodeWith(protocol.DecodeStatusPayload)},
+		{"charging, no session", protocol.ChargingPayload{Length: 74}, decodeWith(protocol.DecodeChargingPayload)},
+		{"charging", protocol.ChargingPayload{Port: 1, ChargeState: types.Charging, ChargeId: "202603011200abcd",
+			StartType: 1, ChargeType: 1, MaxDuration: ptr(90 * time.Minute), MaxEnergy: ptr(types.KWh(10)),
+			ReservationTime: reservationTime, UserId: "emproto4go", MaxCurrent: 16, StartTime: startTime,
+			Duration: 90 * time.Second, StartEnergyCounter: ptr(types.KWh(100)), CurrentEnergyCounter: ptr(types.KWh(101.5)),
+			ChargedEnergy: ptr(types.KWh(1.5)), ChargePrice: 0.25, FeeType: 1, ChargeFee: 0.5, NewProtocolState: 19,
+			Length: 75}, decodeWith(protocol.DecodeChargingPayload)},
+		{"version", protocol.VersionPayload{HardwareVersion: "HW2.1", SoftwareVersion: "V1.2.3", Feature: 0x00010203,
+			Length: 36}, decodeWith(protocol.DecodeVersionPayload)},
+		{"version with support_new", protocol.VersionPayload{SoftwareVersion: "V1.2.3", SupportNew: 5, Length: 37},
+			decodeWith(protocol.DecodeVersionPayload)},
+		{"charge start", protocol.ChargeStartPayload{LineId: 1, UserId: "emproto4go", ChargeId: "202603011200abcd",
+			StartType: 1, ChargeType: 1, Param3: 0xFFFF, MaxCurrent: 16}, decodeWith(protocol.DecodeChargeStartPayload)},
+		{"charge start, reservation with limits", protocol.ChargeStartPayload{LineId: 1, IsReservation: true,
+			StartTime: startTime, MaxDuration: ptr(2 * time.Hour), MaxEnergy: ptr(types.KWh(20)), MaxCurrent: 10},
+			decodeWith(protocol.DecodeChargeStartPayload)},
+		{"charge start response", protocol.ChargeStartResponsePayload{LineId: 1, ErrorReason: 2, Current: 16},
+			decodeWith(protocol.DecodeChargeStartResponsePayload)},
+		{"charge stop", protocol.ChargeStopPayload{LineId: 1, UserId: "emproto4go"},
+			decodeWith(protocol.DecodeChargeStopPayload)},
+		{"charge stop response", protocol.ChargeStopResponsePayload{LineId: 1, ErrorReason: 3},
+			decodeWith(protocol.DecodeChargeStopResponsePayload)},
+		{"config", protocol.ConfigPayload{Action: protocol.ConfigSet, Value: []byte{16}},
+			decodeWith(protocol.DecodeConfigPayload)},
+		{"system time", protocol.SystemTimePayload{Action: protocol.ConfigSet, Time: startTime},
+			decodeWith(protocol.DecodeSystemTimePayload)},
+		{"system time, get", protocol.SystemTimePayload{Action: protocol.ConfigGet},
+			decodeWith(protocol.DecodeSystemTimePayload)},
+		{"password", protocol.PasswordPayload{Action: protocol.ConfigSet, Password: "654321"},
+			decodeWith(protocol.DecodePasswordPayload)},
+		{"byte", protocol.BytePayload{Value: 1}, decodeWith(protocol.DecodeBytePayload)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data := test.payload.Encode()
+			decoded, err := test.decode(data)
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if !reflect.DeepEqual(decoded, test.payload) {
+				t.Errorf("decoded %+v, expected %+v", decoded, test.payload)
+			}
+			if encoded := decoded.Encode(); !bytes.Equal(encoded, data) {
+				t.Errorf("re-encoded % x, expected % x", encoded, data)
+			}
+		})
+	}
+}
+
+func decodeWith[T protocol.CommandPayload](decode func(data []byte) (T, error)) func(data []byte) (protocol.CommandPayload, error) {
+	return func(data []byte) (protocol.CommandPayload, error) {
+		return decode(data)
+	}
+}
+
+// TestSchemaRoundTrip sets every field of every schema to a distinct value and reads them back, which also catches
+// overlapping fields.
+func TestSchemaRoundTrip(t *testing.T) {
+	for _, schema := range protocol.PayloadSchemas {
+		t.Run(schema.Name, func(t *testing.T) {
+			length := schema.MinLength()
+			for _, field := range schema.Fields {
+				length = max(length, field.RequiredLength())
+			}
+
+			payload := schema.New(length)
+			for _, field := range schema.Fields {
+				if field.HasSentinel && payload.IsSet(field.Name) {
+					t.Errorf("field %s is set in a new payload", field.Name)
+				}
+			}
+			for i, field := range schema.Fields {
+				switch field.Kind {
+				case protocol.FieldUint:
+					payload.SetUint(field.Name, uint64(i+1))
+				case protocol.FieldString:
+					payload.SetString(field.Name, fieldString(i))
+				case protocol.FieldTimestamp:
+					payload.SetTime(field.Name, fieldTime(i))
+				}
+			}
+
+			decoded, err := schema.Decode(bytes.Clone(payload.Data))
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			for i, field := range schema.Fields {
+				if !decoded.Has(field.Name) {
+					t.Errorf("field %s not present", field.Name)
+					continue
+				}
+				switch field.Kind {
+				case protocol.FieldUint:
+					if value := decoded.Uint(field.Name); value != uint64(i+1) {
+						t.Errorf("field %s is %d, expected %d", field.Name, value, i+1)
+					}
+				case protocol.FieldString:
+					if value := decoded.String(field.Name); value != fieldString(i) {
+						t.Errorf("field %s is %q, expected %q", field.Name, value, fieldString(i))
+					}
+				case protocol.FieldTimestamp:
+					if value := decoded.Time(field.Name); value == nil || !value.Equal(*fieldTime(i)) {
+						t.Errorf("field %s is %v, expected %v", field.Name, value, fieldTime(i))
+					}
+				}
+			}
+
+			// The shortest valid payload decodes, without its optional fields; a shorter one doesn't.
+			shortest, err := schema.Decode(payload.Data[:schema.MinLength()])
+			if err != nil {
+				t.Fatalf("decode shortest: %v", err)
+			}
+			for _, field := range schema.Fields {
+				if shortest.Has(field.Name) != (field.RequiredLength() <= schema.MinLength()) {
+					t.Errorf("field %s present is %v in the shortest payload", field.Name, shortest.Has(field.Name))
+				}
+			}
+			if _, err := schema.Decode(payload.Data[:schema.MinLength()-1]); err == nil {
+				t.Errorf("payload of %d bytes decoded without error", schema.MinLength()-1)
+			}
+		})
+	}
+}
+
+func fieldString(i int) string {
+	return string([]byte{'a' + byte(i), '1'})
+}
+
+func fieldTime(i int) *time.Time {
+	return protocol.EmTimestampToTime(1780000000 + uint32(i)*3600)
+}
+
+// TestDatagramRoundTrip encodes a datagram for each known command and checks that decoding it yields the same
+// datagram, and that its payload decodes to a typed payload that survives encoding.
+func TestDatagramRoundTrip(t *testing.T) {
+	for _, command := range protocol.KnownCommands() {
+		t.Run(command.Name(), func(t *testing.T) {
+			payload := []byte{0}
+			if schema := protocol.SchemaFor(command); schema != nil {
+				payload = schema.New(0).Data
+			} else if isConfigCommand(command) {
+				payload = []byte{byte(protocol.ConfigGet), 0}
+			}
+			datagram := &protocol.Datagram{
+				Key:      1,
+				Serial:   "0123456789abcdef",
+				Password: "123456",
+				Command:  command,
+				Payload:  payload,
+			}
+
+			data, err := datagram.Encode()
+			if err != nil {
+				t.Fatalf("encode: %v", err)
+			}
+			decoded, err := protocol.Decode(data)
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if !reflect.DeepEqual(decoded, datagram) {
+				t.Errorf("decoded %v, expected %v", decoded, datagram)
+			}
+
+			typed, err := protocol.DecodePayload(decoded)
+			if err != nil {
+				t.Fatalf("decode payload: %v", err)
+			}
+			if typed == nil {
+				t.Fatalf("no typed payload for command %s", command)
+			}
+			// Timestamps that are not set may encode as 0xFFFFFFFF rather than 0, so compare the decoded values.
+			retyped, err := protocol.DecodePayload(&protocol.Datagram{Command: command, Payload: typed.Encode()})
+			if err != nil {
+				t.Fatalf("decode encoded payload: %v", err)
+			}
+			if !reflect.DeepEqual(retyped, typed) {
+				t.Errorf("typed payload %+v encodes to %+v", typed, retyped)
+			}
+		})
+	}
+}
+
+func isConfigCommand(command protocol.EmCommand) bool {
+	switch command {
+	case protocol.CmdSetAndGetLanguage, protocol.CmdSetAndGetLanguageResponse, protocol.CmdSetAndGetName,
+		protocol.CmdSetAndGetNameResponse, protocol.CmdSetAndGetOfflineCharge, protocol.CmdSetAndGetOfflineChargeResponse,
+		protocol.CmdSetAndGetMaxCurrent, protocol.CmdSetAndGetMaxCurrentResponse, protocol.CmdSetAndGetTemperatureUnit,
+		protocol.CmdSetAndGetTemperatureUnitResponse:
+		return true
+	}
+	return false
+}
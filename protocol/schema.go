@@ -0,0 +1,451 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/johnwoo-nl/emproto4go/types"
+)
+
+// FieldKind is the type of a payload field.
+type FieldKind int
+
+const (
+	// FieldUint is a big-endian unsigned integer of 1, 2 or 4 bytes, optionally scaled (see SchemaField.Scale).
+	FieldUint FieldKind = iota
+	// FieldString is a fixed-width string, padded with zero bytes (or spaces or 0xFF).
+	FieldString
+	// FieldTimestamp is a 4-byte EVSEMaster timestamp (see ClockToEmTimestamp); 0 and 0xFFFFFFFF mean not set.
+	FieldTimestamp
+)
+
+// SchemaField describes a field in a payload.
+type SchemaField struct {
+	// Name identifies the field (in snake_case, as it is also used in Wireshark display filters).
+	Name  string
+	Label string
+
+	Offset int
+	Width  int
+	Kind   FieldKind
+	// Hex makes tools display the field in hexadecimal.
+	Hex bool
+
+	// If Scale is non-zero, the value of a FieldUint is (raw + Bias) * Scale, in Unit. Unit "min" or "s" makes the
+	// field a duration.
+	Scale float64
+	Bias  int
+	Unit  string
+
+	// Sentinel is the raw value meaning "not set" (e.g. 0xFFFF for no limit), if HasSentinel is true. New payloads
+	// start with all such fields not set.
+	Sentinel    uint64
+	HasSentinel bool
+
+	// Optional fields may be missing from shorter payloads. An optional field is present if the payload is at least
+	// MinLength bytes long, or if MinLength is 0, if the payload is long enough to hold the field.
+	Optional  bool
+	MinLength int
+}
+
+// RequiredLength returns the payload length needed for the field to be present.
+func (field *SchemaField) RequiredLength() int {
+	if field.Optional {
+		return max(field.Offset+field.Width, field.MinLength)
+	}
+	return field.Offset + field.Width
+}
+
+// PayloadSchema describes the payload layout of one or more commands. It drives decoding (by handlers), encoding (by
+// request builders and the simulator) and the Wireshark dissector.
+type PayloadSchema struct {
+	// Name identifies the schema (in snake_case, as it is also used in Wireshark display filters).
+	Name     string
+	Commands []EmCommand
+	Fields   []SchemaField
+	// Length is the length of the payload, if it is longer than needed for its required fields.
+	Length int
+
+	fieldsOnce   sync.Once
+	fieldsByName map[string]*SchemaField
+}
+
+// MinLength returns the minimum length of a valid payload.
+func (schema *PayloadSchema) MinLength() int {
+	length := schema.Length
+	for _, field := range schema.Fields {
+		if !field.Optional {
+			length = max(length, field.Offset+field.Width)
+		}
+	}
+	return length
+}
+
+// Field returns the field with the given name. Panics if there is no such field, since that is a programming error.
+func (schema *PayloadSchema) Field(name string) *SchemaField {
+	schema.fieldsOnce.Do(func() {
+		schema.fieldsByName = make(map[string]*SchemaField, len(schema.Fields))
+		for i := range schema.Fields {
+			schema.fieldsByName[schema.Fields[i].Name] = &schema.Fields[i]
+		}
+	})
+	field, exists := schema.fieldsByName[name]
+	if !exists {
+		panic(fmt.Sprintf("payload schema %s has no field %s", schema.Name, name))
+	}
+	return field
+}
+
+// Decode returns the payload for reading its fields. Returns an error if the payload is shorter than MinLength.
+func (schema *PayloadSchema) Decode(data []byte) (Payload, error) {
+	if minLength := schema.MinLength(); len(data) < minLength {
+		return Payload{}, fmt.Errorf("%s payload too short (need at least %d bytes, got %d)", schema.Name, minLength, len(data))
+	}
+	return Payload{Schema: schema, Data: data}, nil
+}
+
+// New returns a new payload of the given length (or MinLength, if length is smaller), with all fields that have a
+// sentinel value set to it.
+func (schema *PayloadSchema) New(length int) Payload {
+	payload := Payload{Schema: schema, Data: make([]byte, max(length, schema.MinLength()))}
+	for _, field := range schema.Fields {
+		if field.HasSentinel {
+			payload.Unset(field.Name)
+		}
+	}
+	return payload
+}
+
+// Payload is a payload with a schema, for reading and writing its fields by name. Absent optional fields read as zero
+// values, and writes to them are ignored.
+type Payload struct {
+	Schema *PayloadSchema
+	Data   []byte
+	// Clock is the clock of the EVSE the payload is from or for, for converting timestamps. The zero value is the
+	// default clock.
+	Clock types.EmClock
+}
+
+// Has returns whether the field is present in the payload.
+func (payload Payload) Has(name string) bool {
+	return payload.has(payload.Schema.Field(name))
+}
+
+func (payload Payload) has(field *SchemaField) bool {
+	return len(payload.Data) >= field.RequiredLength()
+}
+
+// IsSet returns whether the field is present and not set to its sentinel value.
+func (payload Payload) IsSet(name string) bool {
+	field := payload.Schema.Field(name)
+	return payload.has(field) && (!field.HasSentinel || payload.raw(field) != field.Sentinel)
+}
+
+// Uint returns the raw value of a numeric field.
+func (payload Payload) Uint(name string) uint64 {
+	field := payload.Schema.Field(name)
+	if !payload.has(field) {
+		return 0
+	}
+	return payload.raw(field)
+}
+
+// Byte returns the raw value of a numeric field as a byte, for single-byte fields.
+func (payload Payload) Byte(name string) byte {
+	return byte(payload.Uint(name))
+}
+
+// Float returns the scaled value of a numeric field, or 0 if it is not set.
+func (payload Payload) Float(name string) float64 {
+	field := payload.Schema.Field(name)
+	if !payload.IsSet(name) {
+		return 0
+	}
+	value := float64(int64(payload.raw(field)) + int64(field.Bias))
+	if field.Scale != 0 {
+		value *= field.Scale
+	}
+	return value
+}
+
+// Duration returns the value of a duration field, or nil if it is not set.
+func (payload Payload) Duration(name string) *time.Duration {
+	field := payload.Schema.Field(name)
+	if !payload.IsSet(name) {
+		return nil
+	}
+	duration := time.Duration(payload.raw(field)) * durationUnit(field)
+	return &duration
+}
+
+// String returns the value of a string field.
+func (payload Payload) String(name string) string {
+	field := payload.Schema.Field(name)
+	if !payload.has(field) {
+		return ""
+	}
+	return ReadString(payload.Data[field.Offset : field.Offset+field.Width])
+}
+
+// Time returns the value of a timestamp field, or nil if it is not set.
+func (payload Payload) Time(name string) *time.Time {
+	field := payload.Schema.Field(name)
+	if !payload.has(field) {
+		return nil
+	}
+	return EmTimestampToClock(payload.Clock, uint32(payload.raw(field)))
+}
+
+// SetUint sets the raw value of a numeric field.
+func (payload Payload) SetUint(name string, value uint64) {
+	field := payload.Schema.Field(name)
+	if !payload.has(field) {
+		return
+	}
+	data := payload.Data[field.Offset : field.Offset+field.Width]
+	switch field.Width {
+	case 1:
+		data[0] = byte(value)
+	case 2:
+		binary.BigEndian.PutUint16(data, uint16(value))
+	case 4:
+		binary.BigEndian.PutUint32(data, uint32(value))
+	default:
+		panic(fmt.Sprintf("field %s.%s has unsupported width %d", payload.Schema.Name, field.Name, field.Width))
+	}
+}
+
+// SetFloat sets the scaled value of a numeric field.
+func (payload Payload) SetFloat(name string, value float64) {
+	field := payload.Schema.Field(name)
+	if field.Scale != 0 {
+		value /= field.Scale
+	}
+	payload.SetUint(name, uint64(max(0, math.Round(value)-float64(field.Bias))))
+}
+
+// SetDuration sets the value of a duration field; nil unsets it.
+func (payload Payload) SetDuration(name string, value *time.Duration) {
+	if value == nil {
+		payload.Unset(name)
+		return
+	}
+	payload.SetUint(name, uint64(*value/durationUnit(payload.Schema.Field(name))))
+}
+
+// SetString sets the value of a string field, truncating it to the field's width.
+func (payload Payload) SetString(name string, value string) {
+	field := payload.Schema.Field(name)
+	if !payload.has(field) {
+		return
+	}
+	data := payload.Data[field.Offset : field.Offset+field.Width]
+	clear(data)
+	copy(data, value)
+}
+
+// SetTime sets the value of a timestamp field; nil unsets it.
+func (payload Payload) SetTime(name string, value *time.Time) {
+	payload.SetUint(name, uint64(ClockToEmTimestamp(payload.Clock, value)))
+}
+
+// Unset sets a field to its sentinel value (which is a no-op for fields without a sentinel).
+func (payload Payload) Unset(name string) {
+	if field := payload.Schema.Field(name); field.HasSentinel {
+		payload.SetUint(name, field.Sentinel)
+	}
+}
+
+func (payload Payload) raw(field *SchemaField) uint64 {
+	data := payload.Data[field.Offset : field.Offset+field.Width]
+	switch field.Width {
+	case 1:
+		return uint64(data[0])
+	case 2:
+		return uint64(binary.BigEndian.Uint16(data))
+	case 4:
+		return uint64(binary.BigEndian.Uint32(data))
+	}
+	panic(fmt.Sprintf("field %s.%s has unsupported width %d", payload.Schema.Name, field.Name, field.Width))
+}
+
+func durationUnit(field *SchemaField) time.Duration {
+	if field.Unit == "min" {
+		return time.Minute
+	}
+	return time.Second
+}
+
+// SchemaFor returns the payload schema for the command, or nil if its payload layout is unknown.
+func SchemaFor(command EmCommand) *PayloadSchema {
+	for _, schema := range PayloadSchemas {
+		for _, schemaCommand := range schema.Commands {
+			if schemaCommand == command {
+				return schema
+			}
+		}
+	}
+	return nil
+}
+
+// PayloadSchemas are all known payload layouts.
+var PayloadSchemas = []*PayloadSchema{
+	LoginSchema,
+	SingleACStatusSchema,
+	SingleACChargingSchema,
+	VersionSchema,
+	ChargeStartSchema,
+	ChargeStartResponseSchema,
+	ChargeStopSchema,
+	ChargeStopResponseSchema,
+	SystemTimeSchema,
+	PasswordSchema,
+}
+
+var LoginSchema = &PayloadSchema{
+	Name:     "login",
+	Commands: []EmCommand{CmdLogin, CmdLoginResponse},
+	Fields: []SchemaField{
+		{Name: "evse_type", Label: "EVSE type", Offset: 0, Width: 1},
+		{Name: "brand", Label: "Brand", Offset: 1, Width: 16, Kind: FieldString},
+		{Name: "model", Label: "Model", Offset: 17, Width: 16, Kind: FieldString},
+		{Name: "hardware_version", Label: "Hardware version", Offset: 33, Width: 16, Kind: FieldString},
+		{Name: "max_power", Label: "Max power", Offset: 49, Width: 4, Unit: "W"},
+		{Name: "max_current", Label: "Max current", Offset: 53, Width: 1, Unit: "A"},
+		{Name: "byte70", Label: "Byte 70", Offset: 70, Width: 1, Hex: true, Optional: true, MinLength: 119},
+		// Brand and model continue here if they are longer than 16 characters.
+		{Name: "brand_ext", Label: "Brand (continued)", Offset: 119, Width: 16, Kind: FieldString, Optional: true, MinLength: 151},
+		{Name: "model_ext", Label: "Model (continued)", Offset: 135, Width: 16, Kind: FieldString, Optional: true, MinLength: 151},
+	},
+}
+
+var SingleACStatusSchema = &PayloadSchema{
+	Name:     "status",
+	Commands: []EmCommand{CmdSingleACStatus},
+	Fields: []SchemaField{
+		{Name: "line_id", Label: "Line ID", Offset: 0, Width: 1},
+		{Name: "l1_voltage", Label: "L1 voltage", Offset: 1, Width: 2, Scale: 0.1, Unit: "V"},
+		{Name: "l1_current", Label: "L1 current", Offset: 3, Width: 2, Scale: 0.01, Unit: "A"},
+		{Name: "power", Label: "Power", Offset: 5, Width: 4, Unit: "W"},
+		{Name: "energy_counter", Label: "Energy counter", Offset: 9, Width: 4, Scale: 0.01, Unit: "kWh"},
+		{Name: "inner_temp", Label: "Inner temperature", Offset: 13, Width: 2, Scale: 0.01, Bias: -20000, Unit: "°C", Sentinel: 0xFFFF, HasSentinel: true},
+		{Name: "outer_temp", Label: "Outer temperature", Offset: 15, Width: 2, Scale: 0.01, Bias: -20000, Unit: "°C", Sentinel: 0xFFFF, HasSentinel: true},
+		{Name: "emergency_btn_state", Label: "Emergency button state", Offset: 17, Width: 1},
+		{Name: "gun_state", Label: "Gun state", Offset: 18, Width: 1},
+		{Name: "output_state", Label: "Output state", Offset: 19, Width: 1},
+		{Name: "current_state", Label: "Current state", Offset: 20, Width: 1},
+		{Name: "errors", Label: "Errors", Offset: 21, Width: 4, Hex: true},
+		// L2 and L3 are only present for 3-phase EVSEs.
+		{Name: "l2_voltage", Label: "L2 voltage", Offset: 25, Width: 2, Scale: 0.1, Unit: "V", Optional: true, MinLength: 33},
+		{Name: "l2_current", Label: "L2 current", Offset: 27, Width: 2, Scale: 0.01, Unit: "A", Optional: true, MinLength: 33},
+		{Name: "l3_voltage", Label: "L3 voltage", Offset: 29, Width: 2, Scale: 0.1, Unit: "V", Optional: true, MinLength: 33},
+		{Name: "l3_current", Label: "L3 current", Offset: 31, Width: 2, Scale: 0.01, Unit: "A", Optional: true, MinLength: 33},
+		{Name: "new_protocol_state", Label: "Current state (new protocol)", Offset: 34, Width: 1, Optional: true},
+	},
+}
+
+var SingleACChargingSchema = &PayloadSchema{
+	Name:     "charging",
+	Commands: []EmCommand{CmdSingleACChargingPublicAuto, CmdSingleACChargingStatusResponse},
+	Fields: []SchemaField{
+		{Name: "port", Label: "Port", Offset: 0, Width: 1},
+		{Name: "charge_state", Label: "Charge state", Offset: 1, Width: 1},
+		{Name: "charge_id", Label: "Charge ID", Offset: 2, Width: 16, Kind: FieldString},
+		{Name: "start_type", Label: "Start type", Offset: 18, Width: 1},
+		{Name: "charge_type", Label: "Charge type", Offset: 19, Width: 1},
+		{Name: "max_duration", Label: "Max duration", Offset: 20, Width: 2, Unit: "min", Sentinel: 0xFFFF, HasSentinel: true},
+		{Name: "max_energy", Label: "Max energy", Offset: 22, Width: 2, Scale: 0.01, Unit: "kWh", Sentinel: 0xFFFF, HasSentinel: true},
+		{Name: "reservation_time", Label: "Reservation time", Offset: 26, Width: 4, Kind: FieldTimestamp},
+		{Name: "user_id", Label: "User ID", Offset: 30, Width: 16, Kind: FieldString},
+		{Name: "max_current", Label: "Max current", Offset: 46, Width: 1, Unit: "A"},
+		{Name: "start_time", Label: "Start time", Offset: 47, Width: 4, Kind: FieldTimestamp},
+		{Name: "duration", Label: "Duration", Offset: 51, Width: 4, Unit: "s"},
+		{Name: "start_energy_counter", Label: "Start energy counter", Offset: 55, Width: 4, Scale: 0.01, Unit: "kWh", Sentinel: 0xFFFFFFFF, HasSentinel: true},
+		{Name: "current_energy_counter", Label: "Current energy counter", Offset: 59, Width: 4, Scale: 0.01, Unit: "kWh", Sentinel: 0xFFFFFFFF, HasSentinel: true},
+		{Name: "charged_energy", Label: "Charged energy", Offset: 63, Width: 4, Scale: 0.01, Unit: "kWh", Sentinel: 0xFFFFFFFF, HasSentinel: true},
+		{Name: "charge_price", Label: "Charge price", Offset: 67, Width: 4, Scale: 0.01},
+		{Name: "fee_type", Label: "Fee type", Offset: 71, Width: 1},
+		{Name: "charge_fee", Label: "Charge fee", Offset: 72, Width: 2, Scale: 0.01},
+		{Name: "new_protocol_state", Label: "Charge state (new protocol)", Offset: 74, Width: 1, Optional: true},
+	},
+}
+
+var VersionSchema = &PayloadSchema{
+	Name:     "version",
+	Commands: []EmCommand{CmdGetVersionResponse},
+	Fields: []SchemaField{
+		{Name: "hardware_version", Label: "Hardware version", Offset: 0, Width: 16, Kind: FieldString},
+		{Name: "software_version", Label: "Software version", Offset: 16, Width: 16, Kind: FieldString},
+		{Name: "feature", Label: "Features", Offset: 32, Width: 4, Hex: true},
+		{Name: "support_new", Label: "Support new", Offset: 36, Width: 1, Hex: true, Optional: true},
+	},
+}
+
+var ChargeStartSchema = &PayloadSchema{
+	Name:     "charge_start",
+	Commands: []EmCommand{CmdChargeStart},
+	Fields: []SchemaField{
+		{Name: "line_id", Label: "Line ID", Offset: 0, Width: 1},
+		{Name: "user_id", Label: "User ID", Offset: 1, Width: 16, Kind: FieldString},
+		{Name: "charge_id", Label: "Charge ID", Offset: 17, Width: 16, Kind: FieldString},
+		{Name: "is_reservation", Label: "Is reservation", Offset: 33, Width: 1},
+		{Name: "start_time", Label: "Start time", Offset: 34, Width: 4, Kind: FieldTimestamp},
+		{Name: "start_type", Label: "Start type", Offset: 38, Width: 1},
+		{Name: "charge_type", Label: "Charge type", Offset: 39, Width: 1},
+		{Name: "max_duration", Label: "Max duration", Offset: 40, Width: 2, Unit: "min", Sentinel: 0xFFFF, HasSentinel: true},
+		{Name: "max_energy", Label: "Max energy", Offset: 42, Width: 2, Scale: 0.01, Unit: "kWh", Sentinel: 0xFFFF, HasSentinel: true},
+		{Name: "param3", Label: "Param 3", Offset: 44, Width: 2, Hex: true},
+		{Name: "max_current", Label: "Max current", Offset: 46, Width: 1, Unit: "A"},
+	},
+}
+
+var ChargeStartResponseSchema = &PayloadSchema{
+	Name:     "charge_start_response",
+	Commands: []EmCommand{CmdChargeStartResponse},
+	Fields: []SchemaField{
+		{Name: "line_id", Label: "Line ID", Offset: 0, Width: 1},
+		{Name: "error_reason", Label: "Error reason", Offset: 3, Width: 1},
+		{Name: "current", Label: "Current", Offset: 4, Width: 1, Unit: "A"},
+	},
+}
+
+var ChargeStopSchema = &PayloadSchema{
+	Name:     "charge_stop",
+	Commands: []EmCommand{CmdChargeStop},
+	Fields: []SchemaField{
+		{Name: "line_id", Label: "Line ID", Offset: 0, Width: 1},
+		{Name: "user_id", Label: "User ID", Offset: 1, Width: 16, Kind: FieldString},
+	},
+	Length: 47,
+}
+
+var ChargeStopResponseSchema = &PayloadSchema{
+	Name:     "charge_stop_response",
+	Commands: []EmCommand{CmdChargeStopResponse},
+	Fields: []SchemaField{
+		{Name: "line_id", Label: "Line ID", Offset: 0, Width: 1},
+		{Name: "error_reason", Label: "Error reason", Offset: 2, Width: 1},
+	},
+	Length: 5,
+}
+
+var SystemTimeSchema = &PayloadSchema{
+	Name:     "system_time",
+	Commands: []EmCommand{CmdSetAndGetSystemTime, CmdSetAndGetSystemTimeResponse},
+	Fields: []SchemaField{
+		{Name: "action", Label: "Action", Offset: 0, Width: 1},
+		{Name: "time", Label: "Time", Offset: 1, Width: 4, Kind: FieldTimestamp},
+	},
+}
+
+var PasswordSchema = &PayloadSchema{
+	Name:     "password",
+	Commands: []EmCommand{CmdSetPassword, CmdSetPasswordResponse},
+	Fields: []SchemaField{
+		{Name: "action", Label: "Action", Offset: 0, Width: 1},
+		{Name: "password", Label: "New password", Offset: 1, Width: 6, Kind: FieldString},
+	},
+}
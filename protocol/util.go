@@ -0,0 +1,50 @@
+package protocol
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/johnwoo-nl/emproto4go/types"
+)
+
+// ReadString returns the string in a fixed-width string field, without its padding.
+func ReadString(data []byte) string {
+	return string(bytes.Trim(data, "\x00\xFF\x20"))
+}
+
+// TimeToEmTimestamp converts a time to an EVSEMaster timestamp, for an EVSE with the default clock (see
+// ClockToEmTimestamp).
+func TimeToEmTimestamp(time *time.Time) uint32 {
+	return ClockToEmTimestamp(types.EmClock{}, time)
+}
+
+// EmTimestampToTime converts an EVSEMaster timestamp to a time, for an EVSE with the default clock (see
+// EmTimestampToClock).
+func EmTimestampToTime(timestamp uint32) *time.Time {
+	return EmTimestampToClock(types.EmClock{}, timestamp)
+}
+
+// ClockToEmTimestamp converts a time to an EVSEMaster timestamp for an EVSE with the given clock: the wall-clock time
+// at the EVSE's location, as if it were in the firmware's timezone. A nil or zero time yields 0xFFFFFFFF (not set).
+func ClockToEmTimestamp(clock types.EmClock, t *time.Time) uint32 {
+	if t == nil || t.UnixMilli() == 0 {
+		return 0xFFFFFFFF
+	}
+	return uint32(shiftWallClock(*t, clock.EvseLocation(), clock.FirmwareLocation()).Unix())
+}
+
+// EmTimestampToClock converts an EVSEMaster timestamp (see ClockToEmTimestamp) for an EVSE with the given clock to a
+// time at the EVSE's location. Returns nil for 0 and 0xFFFFFFFF (not set).
+func EmTimestampToClock(clock types.EmClock, timestamp uint32) *time.Time {
+	if timestamp == 0 || timestamp == 0xFFFFFFFF {
+		return nil
+	}
+	t := shiftWallClock(time.Unix(int64(timestamp), 0), clock.FirmwareLocation(), clock.EvseLocation())
+	return &t
+}
+
+// shiftWallClock returns the time in to that has the same wall-clock time as t has in from.
+func shiftWallClock(t time.Time, from *time.Location, to *time.Location) time.Time {
+	wall := t.In(from)
+	return time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), wall.Second(), wall.Nanosecond(), to)
+}
@@ -0,0 +1,59 @@
+package emproto4go
+
+import (
+	"github.com/johnwoo-nl/emproto4go/internal"
+	"github.com/johnwoo-nl/emproto4go/types"
+)
+
+// CreateReplayTransport creates a transport that feeds the received datagrams from a capture (see
+// CreateCaptureReader) to a communicator, paced by their timestamps divided by speed (1 is real time, 10 is ten times
+// as fast). A speed of 0 feeds them as fast as the communicator processes them.
+//
+//lint:ignore U1000 exported API
+//goland:noinspection GoUnusedExportedFunction
+func CreateReplayTransport(reader types.EmCaptureReader, speed float64) types.EmReplayTransport {
+	return internal.CreateReplayTransport(reader, speed)
+}
+
+// ReplayCapture replays a capture into a new communicator, and returns the communicator (stopped, with the resulting
+// EVSE data) and all events emitted during the replay. This turns a capture into a deterministic regression test for
+// the datagram handlers.
+//
+// Debouncing is disabled, so every change results in its own event, unless options include WithDebounce. The
+// communicator doesn't log in itself: a replayed login response logs in to the EVSE, like the recorded app did, so
+// login and charge start/stop events are emitted as they were live. The events end with the last replayed datagram,
+// so they don't include those of stopping the communicator (logged out, offline).
+//
+//lint:ignore U1000 exported API
+//goland:noinspection GoUnusedExportedFunction
+func ReplayCapture(reader types.EmCaptureReader, speed float64, options ...Option) (types.EmReplayResult, error) {
+	transport := internal.CreateReplayTransport(reader, speed)
+	options = append([]Option{WithDebounce(0, 0)}, options...)
+	options = append(options, WithTransport(transport), func(options *internal.CommunicatorOptions) {
+		options.ReplayLogins = true
+	})
+	communicator := CreateCommunicatorWithOptions("emproto4go_replay", options...)
+
+	result := types.EmReplayResult{Communicator: communicator}
+	events := make(chan types.EmEvent, 64)
+	collected := make(chan struct{})
+	watcher := communicator.WatchWithOptions(nil, nil, events, types.EmWatchOptions{Policy: types.DeliveryBlock})
+	go func() {
+		defer close(collected)
+		for event := range events {
+			result.Events = append(result.Events, event)
+		}
+	}()
+
+	if err := communicator.Start(); err != nil {
+		return result, err
+	}
+	<-transport.Done()
+	communicator.(*internal.Communicator).FlushEvents()
+	watcher.Stop()
+	<-collected
+	communicator.Stop()
+
+	result.Replayed = transport.Replayed()
+	return result, transport.Err()
+}
@@ -0,0 +1,162 @@
+package emproto4go_test
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/johnwoo-nl/emproto4go"
+	"github.com/johnwoo-nl/emproto4go/emprototest/sim"
+	"github.com/johnwoo-nl/emproto4go/types"
+)
+
+// TestReplayCaptureEvents records a live session with the simulator (login, charge start and stop), replays the
+// capture and checks that the replay emits the same login and charge events.
+func TestReplayCaptureEvents(t *testing.T) {
+	capture := &lockedBuffer{}
+	live := recordSession(t, capture)
+
+	reader, err := emproto4go.CreateCaptureReader(bytes.NewReader(capture.Bytes()))
+	if err != nil {
+		t.Fatalf("CreateCaptureReader: %v", err)
+	}
+	result, err := emproto4go.ReplayCapture(reader, 0)
+	if err != nil {
+		t.Fatalf("ReplayCapture: %v", err)
+	}
+	replayed := eventTypes(result.Events)
+
+	milestones := []types.EmEventType{types.EvseLoggedIn, types.EvseChargeStarted, types.EvseChargeStopped}
+	if got, want := only(replayed, milestones), only(live, milestones); !slices.Equal(got, want) {
+		t.Errorf("replayed milestones %v, live %v", got, want)
+	}
+	if want := milestones; !slices.Equal(only(live, milestones), want) {
+		t.Errorf("live milestones %v, expected %v", only(live, milestones), want)
+	}
+	for _, unexpected := range []types.EmEventType{types.EvseOffline, types.EvseLoggedOut} {
+		if slices.Contains(replayed, unexpected) {
+			t.Errorf("replay emitted %s: %v", unexpected, replayed)
+		}
+	}
+
+	evse := result.Communicator.GetEvse("0123456789abcdef")
+	if evse == nil {
+		t.Fatalf("replayed EVSE not found")
+	}
+	if evse.Info().Model() != "EC311S" {
+		t.Errorf("replayed model %q, expected EC311S", evse.Info().Model())
+	}
+}
+
+// recordSession runs a live session with a simulated charger, writing a capture of it, and returns the event types
+// emitted before stopping the communicator.
+func recordSession(t *testing.T, capture io.Writer) []types.EmEventType {
+	t.Helper()
+	network := emproto4go.CreatePipeNetwork()
+	simulator := sim.CreatePipeSimulator(network, nil)
+	simulator.Interval = 50 * time.Millisecond
+	simulator.Logger.SetOutput(io.Discard)
+	charger, err := simulator.AddCharger(sim.ChargerConfig{Serial: "0123456789abcdef", Password: "123456", PluggedIn: true})
+	if err != nil {
+		t.Fatalf("AddCharger: %v", err)
+	}
+	if err := simulator.Start(); err != nil {
+		t.Fatalf("Start simulator: %v", err)
+	}
+	defer simulator.Stop()
+
+	recorder, err := emproto4go.CreateCaptureRecorder(capture, types.CaptureJsonLines, true)
+	if err != nil {
+		t.Fatalf("CreateCaptureRecorder: %v", err)
+	}
+	communicator := emproto4go.CreateCommunicatorWithOptions("test",
+		emproto4go.WithTransport(network.Endpoint(&net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 28376})),
+		emproto4go.WithRecorder(recorder),
+		emproto4go.WithDebounce(0, 0),
+	)
+	communicator.Logger().SetOutput(io.Discard)
+	events := make(chan types.EmEvent, 1024)
+	watcher := communicator.WatchWithOptions(nil, nil, events, types.EmWatchOptions{Policy: types.DeliveryBlock})
+	if err := communicator.Start(); err != nil {
+		t.Fatalf("Start communicator: %v", err)
+	}
+	defer communicator.Stop()
+
+	evse := communicator.DefineEvse("0123456789abcdef")
+	waitFor(t, "EVSE online", evse.IsOnline)
+	if err := evse.UsePassword("123456"); err != nil {
+		t.Fatalf("UsePassword: %v", err)
+	}
+	waitFor(t, "config fetched", func() bool { return evse.Config().MaxCurrent() != 0 })
+	if _, err := evse.StartCharge(types.ChargeStartParams{}); err != nil {
+		t.Fatalf("StartCharge: %v", err)
+	}
+	waitFor(t, "charging", func() bool { return evse.MetaState() == types.MetaStateCharging })
+	time.Sleep(200 * time.Millisecond)
+	if _, err := evse.StopCharge(types.ChargeStopParams{}); err != nil {
+		t.Fatalf("StopCharge: %v", err)
+	}
+	waitFor(t, "charging stopped", func() bool { return !charger.IsCharging() && evse.MetaState() != types.MetaStateCharging })
+	time.Sleep(200 * time.Millisecond)
+
+	watcher.Stop()
+	var live []types.EmEvent
+	for event := range events {
+		live = append(live, event)
+	}
+	return eventTypes(live)
+}
+
+// lockedBuffer is a buffer that can be written concurrently, as acknowledgements may still be sent (and recorded)
+// while the communicator stops.
+type lockedBuffer struct {
+	mutex  sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (buffer *lockedBuffer) Write(data []byte) (int, error) {
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+	return buffer.buffer.Write(data)
+}
+
+func (buffer *lockedBuffer) Bytes() []byte {
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+	return bytes.Clone(buffer.buffer.Bytes())
+}
+
+func eventTypes(events []types.EmEvent) []types.EmEventType {
+	var result []types.EmEventType
+	for _, event := range events {
+		result = append(result, event.Type)
+	}
+	return result
+}
+
+// only returns the event types that are in filter, in order.
+func only(eventTypes []types.EmEventType, filter []types.EmEventType) []types.EmEventType {
+	var result []types.EmEventType
+	for _, eventType := range eventTypes {
+		if slices.Contains(filter, eventType) {
+			result = append(result, eventType)
+		}
+	}
+	return result
+}
+
+// waitFor polls condition until it is true, failing the test after a few seconds.
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,82 @@
+package types
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/bits"
+	"strings"
+)
+
+// EmCapabilities is a set of capabilities of an EVSE. See EmEvse.Capabilities.
+type EmCapabilities uint32
+
+const (
+	// CapabilityThreePhase means the EVSE can charge on three phases.
+	CapabilityThreePhase EmCapabilities = 1 << iota
+	// CapabilityForceSinglePhase means the EVSE can be told to charge on one phase while connected on three (see
+	// ChargeStartParams.ForceSinglePhase).
+	CapabilityForceSinglePhase
+	// CapabilityNewProtocol means the EVSE sends the longer status and charging datagrams.
+	CapabilityNewProtocol
+)
+
+var capabilityNames = []struct {
+	capability EmCapabilities
+	name       string
+}{
+	{CapabilityThreePhase, "three_phase"},
+	{CapabilityForceSinglePhase, "force_single_phase"},
+	{CapabilityNewProtocol, "new_protocol"},
+}
+
+// ParseCapability returns the capability with the given name (as returned by Names), or false if there is none.
+func ParseCapability(name string) (EmCapabilities, bool) {
+	for _, entry := range capabilityNames {
+		if entry.name == name {
+			return entry.capability, true
+		}
+	}
+	return 0, false
+}
+
+// Has returns true if the set has all the given capabilities.
+func (capabilities EmCapabilities) Has(capability EmCapabilities) bool {
+	return capabilities&capability == capability
+}
+
+// Names returns the names of the capabilities in the set, e.g. "three_phase".
+func (capabilities EmCapabilities) Names() []string {
+	names := make([]string, 0, bits.OnesCount32(uint32(capabilities)))
+	for _, entry := range capabilityNames {
+		if capabilities.Has(entry.capability) {
+			names = append(names, entry.name)
+		}
+	}
+	return names
+}
+
+func (capabilities EmCapabilities) String() string {
+	return strings.Join(capabilities.Names(), "|")
+}
+
+// MarshalJSON marshals the set as an array of capability names.
+func (capabilities EmCapabilities) MarshalJSON() ([]byte, error) {
+	return json.Marshal(capabilities.Names())
+}
+
+// UnmarshalJSON unmarshals an array of capability names.
+func (capabilities *EmCapabilities) UnmarshalJSON(data []byte) error {
+	var names []string
+	if err := json.Unmarshal(data, &names); err != nil {
+		return err
+	}
+	*capabilities = 0
+	for _, name := range names {
+		capability, ok := ParseCapability(name)
+		if !ok {
+			return fmt.Errorf("unknown capability: %s", name)
+		}
+		*capabilities |= capability
+	}
+	return nil
+}
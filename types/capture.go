@@ -0,0 +1,119 @@
+package types
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// EmCaptureDirection tells whether a captured datagram was received or sent by the communicator.
+type EmCaptureDirection string
+
+const (
+	CaptureReceived EmCaptureDirection = "in"
+	CaptureSent     EmCaptureDirection = "out"
+)
+
+// EmCaptureFormat is the file format written by a recorder.
+type EmCaptureFormat string
+
+const (
+	// CaptureJsonLines writes one JSON object per datagram per line, with the datagram as a hex string. Easy to read and
+	// to edit by hand.
+	CaptureJsonLines EmCaptureFormat = "jsonl"
+	// CaptureBinary writes a compact binary format: a header, followed by one record per datagram.
+	CaptureBinary EmCaptureFormat = "binary"
+)
+
+// EmCapturedDatagram is a raw datagram as received or sent by a communicator.
+type EmCapturedDatagram struct {
+	Timestamp time.Time
+	Direction EmCaptureDirection
+	// Addr is the remote address: the sender of a received datagram, or the destination of a sent one.
+	Addr *net.UDPAddr
+	// Data is the raw datagram, including header and checksum.
+	Data []byte
+}
+
+// EmRecorder receives every raw datagram received or sent by a communicator (see WithRecorder). Record is called from
+// the communicator's receiver loop and from any goroutine sending requests, so implementations must be safe for
+// concurrent use, and should return quickly.
+type EmRecorder interface {
+	Record(datagram EmCapturedDatagram) error
+}
+
+// EmCaptureReader reads datagrams from a capture written by a recorder.
+type EmCaptureReader interface {
+	// Next returns the next datagram, or io.EOF after the last one.
+	Next() (EmCapturedDatagram, error)
+}
+
+// EmReplayTransport is a transport that feeds the received datagrams of a capture to a communicator, instead of
+// reading from a socket. Datagrams the communicator sends are discarded, as are the sent datagrams in the capture.
+type EmReplayTransport interface {
+	EmTransport
+
+	// Done is closed once all datagrams of the capture have been processed by the communicator (or reading the capture
+	// failed, see Err).
+	Done() <-chan struct{}
+
+	// Err returns the error that stopped reading the capture, or nil if the whole capture was read.
+	Err() error
+
+	// Replayed returns the number of datagrams fed to the communicator so far.
+	Replayed() int
+}
+
+// EmReplayResult is the outcome of replaying a capture into a communicator.
+type EmReplayResult struct {
+	// Communicator is the (stopped) communicator the capture was replayed into. Its EVSEs hold the data that resulted
+	// from the replay.
+	Communicator EmCommunicator
+
+	// Events are all events emitted during the replay, in order. As the communicator is stopped afterward, this ends
+	// with the events of every EVSE in the capture going offline.
+	Events []EmEvent
+
+	// Replayed is the number of datagrams fed to the communicator.
+	Replayed int
+}
+
+// EmTranscriptEntry is a captured datagram with its header decoded, for analysis of captures. See Transcribe.
+type EmTranscriptEntry struct {
+	Timestamp time.Time          `json:"ts"`
+	Direction EmCaptureDirection `json:"dir"`
+	Addr      string             `json:"addr,omitempty"`
+	Length    int                `json:"length"`
+
+	// The decoded header; only set if the datagram could be decoded (Error is empty).
+	Command     uint16   `json:"command"`
+	CommandName string   `json:"commandName,omitempty"`
+	Serial      EmSerial `json:"serial,omitempty"`
+	Key         byte     `json:"key"`
+	PasswordSet bool     `json:"passwordSet"`
+	Payload     string   `json:"payload,omitempty"`
+
+	// Error tells why the datagram could not be decoded.
+	Error string `json:"error,omitempty"`
+}
+
+func (entry EmTranscriptEntry) String() string {
+	prefix := fmt.Sprintf("%s %-3s %-21s", entry.Timestamp.Format("2006-01-02 15:04:05.000000"), entry.Direction, entry.Addr)
+	if entry.Error != "" {
+		return fmt.Sprintf("%s (%d bytes) %s", prefix, entry.Length, entry.Error)
+	}
+	password := "not set"
+	if entry.PasswordSet {
+		password = "set"
+	}
+	command := fmt.Sprintf("0x%04x", entry.Command)
+	if entry.CommandName != "" {
+		command += ":" + entry.CommandName
+	}
+	payload := entry.Payload
+	if payload == "" {
+		payload = "(empty)"
+	}
+	return fmt.Sprintf("%s %s serial=%s key=%d password=%s payload[%d]=%s",
+		prefix, command, entry.Serial, entry.Key, password, (len(entry.Payload)+1)/3, payload)
+}
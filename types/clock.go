@@ -0,0 +1,99 @@
+package types
+
+import (
+	"encoding/json"
+	"time"
+	_ "time/tzdata" // Fallback for hosts without zoneinfo, such as minimal containers.
+)
+
+// shanghai is the timezone EVSEMaster firmware assumes. It has no daylight saving time, so a fixed offset is a
+// correct fallback in case the location cannot be loaded.
+var shanghai = loadLocation("Asia/Shanghai", time.FixedZone("CST", 8*60*60))
+
+func loadLocation(name string, fallback *time.Location) *time.Location {
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		return fallback
+	}
+	return location
+}
+
+// EmClock describes how an EVSE's clock relates to real time. EVSEs are set to the wall-clock time where they are
+// installed (by the OEM app), but their firmware converts that wall-clock time to timestamps as if it were in the
+// firmware's timezone. Timestamps in datagrams (such as the start time of a charging session) are converted
+// accordingly. The zero value gives the defaults, which match the OEM app.
+type EmClock struct {
+	// Location is the timezone of the EVSE's clock, i.e. where it is installed. Nil means the host's local timezone
+	// (time.Local). Set this if the host's timezone differs, e.g. in a container without a TZ setting.
+	Location *time.Location
+	// Firmware is the timezone the EVSE's firmware assumes for timestamps. Nil means Asia/Shanghai.
+	Firmware *time.Location
+}
+
+// EvseLocation returns Location, or time.Local if not set.
+func (clock EmClock) EvseLocation() *time.Location {
+	if clock.Location == nil {
+		return time.Local
+	}
+	return clock.Location
+}
+
+// FirmwareLocation returns Firmware, or Asia/Shanghai if not set.
+func (clock EmClock) FirmwareLocation() *time.Location {
+	if clock.Firmware == nil {
+		return shanghai
+	}
+	return clock.Firmware
+}
+
+// Or returns the clock, with the timezones that are not set taken from fallback.
+func (clock EmClock) Or(fallback EmClock) EmClock {
+	if clock.Location == nil {
+		clock.Location = fallback.Location
+	}
+	if clock.Firmware == nil {
+		clock.Firmware = fallback.Firmware
+	}
+	return clock
+}
+
+type emClockJson struct {
+	Location string `json:"location,omitempty"`
+	Firmware string `json:"firmware,omitempty"`
+}
+
+// MarshalJSON marshals the clock with the names of its timezones (e.g. "Europe/Amsterdam").
+func (clock EmClock) MarshalJSON() ([]byte, error) {
+	var data emClockJson
+	if clock.Location != nil {
+		data.Location = clock.Location.String()
+	}
+	if clock.Firmware != nil {
+		data.Firmware = clock.Firmware.String()
+	}
+	return json.Marshal(data)
+}
+
+// UnmarshalJSON unmarshals a clock marshalled by MarshalJSON. Timezones are loaded by name, so fixed zones (see
+// time.FixedZone) cannot be unmarshalled.
+func (clock *EmClock) UnmarshalJSON(data []byte) error {
+	var names emClockJson
+	if err := json.Unmarshal(data, &names); err != nil {
+		return err
+	}
+	*clock = EmClock{}
+	for _, field := range []struct {
+		name     string
+		location **time.Location
+	}{{names.Location, &clock.Location}, {names.Firmware, &clock.Firmware}} {
+		if field.name == "" {
+			continue
+		}
+		location, err := time.LoadLocation(field.name)
+		if err != nil {
+			return err
+		}
+		*field.location = location
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package types
+
+import "time"
+
+// EmCompatStatus tells how well the library is known to work with an EVSE's model and firmware, according to the
+// compatibility database bundled with the library.
+type EmCompatStatus string
+
+const (
+	// CompatVerified means the library is known to work with the EVSE's model and firmware.
+	CompatVerified EmCompatStatus = "verified"
+	// CompatDeviates means the EVSE's model or firmware is known to deviate from what the library expects; see the
+	// notes in the compatibility report.
+	CompatDeviates EmCompatStatus = "deviates"
+	// CompatUnverified means the EVSE's model and firmware are not in the compatibility database.
+	CompatUnverified EmCompatStatus = "unverified"
+)
+
+// EmCompatReport describes an EVSE's model, firmware and protocol details, for reporting compatibility issues. It
+// deliberately leaves out the serial and other identifying data, so it can be attached to an issue as-is. See
+// EmEvse.CompatReport.
+type EmCompatReport struct {
+	Timestamp       time.Time      `json:"timestamp"`
+	Brand           string         `json:"brand"`
+	Model           string         `json:"model"`
+	EvseType        byte           `json:"evseType"`
+	HardwareVersion string         `json:"hardwareVersion"`
+	SoftwareVersion string         `json:"softwareVersion"`
+	Phases          EmPhases       `json:"phases"`
+	MaxPower        Watts          `json:"maxPower"`
+	MaxCurrent      Amps           `json:"maxCurrent"`
+	Feature         uint32         `json:"feature"`
+	SupportNew      uint32         `json:"supportNew"`
+	Byte70          byte           `json:"byte70"`
+	NewProtocol     bool           `json:"newProtocol"`
+	Capabilities    EmCapabilities `json:"capabilities"`
+
+	// PayloadLengths are the payload lengths seen per received command, ordered by command.
+	PayloadLengths []EmPayloadLengths `json:"payloadLengths"`
+
+	// Status and Notes are from the compatibility database.
+	Status EmCompatStatus `json:"status"`
+	Notes  []string       `json:"notes,omitempty"`
+}
+
+// EmPayloadLengths are the different payload lengths seen in datagrams with a command received from an EVSE.
+type EmPayloadLengths struct {
+	Command     uint16 `json:"command"`
+	CommandName string `json:"commandName,omitempty"`
+	Lengths     []int  `json:"lengths"`
+}
@@ -0,0 +1,65 @@
+package types
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// EmDiagnostics reports what a communicator received but could not interpret: datagrams with commands that no handler
+// recognized, and enum values and error bits without a known meaning. Collected from many EVSEs, this helps mapping
+// more of the protocol. See EmCommunicator.Diagnostics.
+type EmDiagnostics struct {
+	// Since is when the communicator was created or its diagnostics were last reset.
+	Since time.Time `json:"since"`
+
+	// UnknownCommands has an entry per command and payload length, ordered by command, then payload length.
+	UnknownCommands []EmUnknownCommand `json:"unknownCommands"`
+
+	// UnknownValues has an entry per field and value, ordered by field, then value.
+	UnknownValues []EmUnknownValue `json:"unknownValues"`
+}
+
+// EmUnknownCommand counts the received datagrams with a command that no handler recognized and nothing waited for,
+// with a given payload length.
+type EmUnknownCommand struct {
+	Command uint16 `json:"command"`
+	// CommandName is set if the command is known to the protocol package, but has no handler.
+	CommandName   string `json:"commandName,omitempty"`
+	PayloadLength int    `json:"payloadLength"`
+	Count         uint64 `json:"count"`
+	// Evses is the number of different EVSEs that sent the command with this payload length.
+	Evses     int       `json:"evses"`
+	FirstSeen time.Time `json:"firstSeen"`
+	LastSeen  time.Time `json:"lastSeen"`
+	// Samples are the first few distinct payloads, as space-separated hex bytes.
+	Samples []string `json:"samples"`
+}
+
+func (command EmUnknownCommand) String() string {
+	name := fmt.Sprintf("0x%04x", command.Command)
+	if command.CommandName != "" {
+		name += ":" + command.CommandName
+	}
+	return fmt.Sprintf("%s payload[%d] count=%d evses=%d last=%s samples=[%s]",
+		name, command.PayloadLength, command.Count, command.Evses, command.LastSeen.Format(time.RFC3339),
+		strings.Join(command.Samples, " | "))
+}
+
+// EmUnknownValue counts the occurrences of a value without a known meaning in one of the enum fields of the status and
+// charging datagrams ("current_state", "new_protocol_state", "charge_state", "gun_state", "output_state"), or of a set
+// error bit without a known meaning ("error_bit", with the bit number as value).
+type EmUnknownValue struct {
+	Field string `json:"field"`
+	Value uint32 `json:"value"`
+	Count uint64 `json:"count"`
+	// Evses is the number of different EVSEs that sent the value.
+	Evses     int       `json:"evses"`
+	FirstSeen time.Time `json:"firstSeen"`
+	LastSeen  time.Time `json:"lastSeen"`
+}
+
+func (value EmUnknownValue) String() string {
+	return fmt.Sprintf("%s=%d count=%d evses=%d last=%s",
+		value.Field, value.Value, value.Count, value.Evses, value.LastSeen.Format(time.RFC3339))
+}
@@ -44,6 +44,26 @@ func (err EvseInvalidPasswordError) Error() string {
 	return fmt.Sprintf("Invalid password for EVSE: %s", err.Evse.Label())
 }
 
+// EvsePasswordNotChangedError is returned when the EVSE responds to a password change without having changed its
+// password; the old password still applies.
+type EvsePasswordNotChangedError struct {
+	Evse EmEvse
+}
+
+func (err EvsePasswordNotChangedError) Error() string {
+	return fmt.Sprintf("Password not changed by EVSE: %s", err.Evse.Label())
+}
+
+// PasswordFormatError is returned for a password that is not a string of 6 digits (see EmPassword.IsValid).
+type PasswordFormatError struct {
+	Password EmPassword
+}
+
+func (err PasswordFormatError) Error() string {
+	// Don't include the password itself, in case it is a mistyped real password.
+	return "Password must be 6 digits"
+}
+
 type EvseInvalidDatagramError struct {
 	Evse            EmEvse
 	ResponseCommand uint16
@@ -82,6 +102,26 @@ func (err EvseChargeStopError) Error() string {
 	return fmt.Sprintf("Failed to stop charge for EVSE %s: %s (%d)", err.Evse.Label(), err.ErrorMessage, err.ErrorReason)
 }
 
+type RawCommandsDisabledError struct {
+	Evse EmEvse
+}
+
+func (err RawCommandsDisabledError) Error() string {
+	return fmt.Sprintf("Raw commands are disabled (see WithRawCommands), not sending to EVSE: %s", err.Evse.Label())
+}
+
+// ExperimentalCommandsDisabledError is returned by requests with a command that is not verified with a physical EVSE,
+// unless the communicator was created with the WithExperimentalCommands option.
+type ExperimentalCommandsDisabledError struct {
+	Evse    EmEvse
+	Command uint16
+}
+
+func (err ExperimentalCommandsDisabledError) Error() string {
+	return fmt.Sprintf("Experimental commands are disabled (see WithExperimentalCommands), not sending %04x to EVSE: %s",
+		err.Command, err.Evse.Label())
+}
+
 type EvseUnknownError struct {
 	Serial EmSerial
 }
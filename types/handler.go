@@ -0,0 +1,25 @@
+package types
+
+// EmHandler handles datagrams received from EVSEs, e.g. for experimenting with commands the library doesn't know yet.
+// Register it with EmCommunicator.RegisterHandler.
+type EmHandler interface {
+	// Commands returns the codes of the commands to handle (see the protocol package for the known ones).
+	Commands() []uint16
+
+	// Handle is called for every datagram with one of these commands received from a known EVSE, in the
+	// communicator's receive loop. It must not block; start a goroutine for anything slow, such as sending a request
+	// and waiting for its response. The payload must not be retained after Handle returns.
+	Handle(evse EmEvse, command uint16, payload []byte)
+}
+
+// Names of the built-in handlers, which every communicator starts with (all with priority 0). Register a handler
+// with one of these names to replace the built-in one, or unregister it to disable it.
+const (
+	HandlerChargeStartStop  = "charge_start_stop"
+	HandlerConfig           = "config"
+	HandlerHeading          = "heading"
+	HandlerLoginInfo        = "login_info"
+	HandlerSingleACCharging = "single_ac_charging"
+	HandlerSingleACStatus   = "single_ac_status"
+	HandlerVersion          = "version"
+)
@@ -0,0 +1,96 @@
+package types
+
+import (
+	"net"
+	"time"
+)
+
+// EmStore persists the EVSEs known to a communicator, so they can be restored when the communicator is started again
+// (e.g. after an app restart). The communicator saves an EVSE's record when its info, config or charge data changes,
+// and after logging in to it.
+type EmStore interface {
+	// Load returns all stored EVSE records.
+	Load() ([]EmEvseRecord, error)
+
+	// Save stores the record, replacing any existing record with the same serial.
+	Save(record EmEvseRecord) error
+
+	// Delete removes the record with the given serial, if there is one.
+	Delete(serial EmSerial) error
+}
+
+// EmCredentialProvider supplies EVSE passwords. When a communicator has a credential provider, passwords are asked
+// from the provider (for restored as well as newly discovered EVSEs) and are not written to its EmStore.
+type EmCredentialProvider interface {
+	// Password returns the password for the EVSE with the given serial, or "" if the provider doesn't know it.
+	Password(serial EmSerial) (EmPassword, error)
+
+	// SetPassword is called after successfully logging in to an EVSE with the given password. Read-only providers
+	// ignore this.
+	SetPassword(serial EmSerial, password EmPassword) error
+}
+
+// EmEvseRecord is the persisted data of an EVSE.
+type EmEvseRecord struct {
+	Serial EmSerial `json:"serial"`
+
+	// Password is only set if the communicator has no EmCredentialProvider.
+	Password EmPassword `json:"password,omitempty"`
+
+	// IP and Port are the address the EVSE was last seen at.
+	IP   net.IP `json:"ip,omitempty"`
+	Port int    `json:"port,omitempty"`
+
+	// Clock is the clock set with EmEvse.SetClock. Timezones are stored by name.
+	Clock EmClock `json:"clock,omitzero"`
+
+	Info   EmInfoRecord   `json:"info"`
+	Config EmConfigRecord `json:"config"`
+	Charge EmChargeRecord `json:"charge"`
+}
+
+// EmInfoRecord holds the persisted fields of EmEvseInfo.
+type EmInfoRecord struct {
+	Brand           string   `json:"brand,omitempty"`
+	Model           string   `json:"model,omitempty"`
+	HardwareVersion string   `json:"hardwareVersion,omitempty"`
+	SoftwareVersion string   `json:"softwareVersion,omitempty"`
+	EvseType        byte     `json:"evseType,omitempty"`
+	Phases          EmPhases `json:"phases,omitempty"`
+	MaxPower        Watts    `json:"maxPower,omitempty"`
+	MaxCurrent      Amps     `json:"maxCurrent,omitempty"`
+	Feature         uint32   `json:"feature,omitempty"`
+	SupportNew      uint32   `json:"supportNew,omitempty"`
+	Byte70          byte     `json:"byte70,omitempty"`
+}
+
+// EmConfigRecord holds the persisted fields of EmEvseConfig.
+type EmConfigRecord struct {
+	Name            string            `json:"name,omitempty"`
+	Language        EmLanguage        `json:"language,omitempty"`
+	TemperatureUnit EmTemperatureUnit `json:"temperatureUnit,omitempty"`
+	OfflineCharge   bool              `json:"offlineCharge,omitempty"`
+	MaxCurrent      Amps              `json:"maxCurrent,omitempty"`
+}
+
+// EmChargeRecord holds the persisted fields of EmEvseCharge (the last known charging session).
+type EmChargeRecord struct {
+	Port                 uint8          `json:"port,omitempty"`
+	ChargeState          EmCurrentState `json:"chargeState,omitempty"`
+	ChargeId             ChargeId       `json:"chargeId,omitempty"`
+	StartType            uint8          `json:"startType,omitempty"`
+	ChargeType           uint8          `json:"chargeType,omitempty"`
+	MaxDuration          *time.Duration `json:"maxDuration,omitempty"`
+	MaxEnergy            *KWh           `json:"maxEnergy,omitempty"`
+	ReservationTime      *time.Time     `json:"reservationTime,omitempty"`
+	UserId               UserId         `json:"userId,omitempty"`
+	MaxCurrent           Amps           `json:"maxCurrent,omitempty"`
+	StartTime            *time.Time     `json:"startTime,omitempty"`
+	Duration             time.Duration  `json:"duration,omitempty"`
+	StartEnergyCounter   KWh            `json:"startEnergyCounter,omitempty"`
+	CurrentEnergyCounter KWh            `json:"currentEnergyCounter,omitempty"`
+	ChargedEnergy        KWh            `json:"chargedEnergy,omitempty"`
+	ChargePrice          float32        `json:"chargePrice,omitempty"`
+	FeeType              uint8          `json:"feeType,omitempty"`
+	ChargeFee            float32        `json:"chargeFee,omitempty"`
+}
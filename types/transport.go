@@ -0,0 +1,35 @@
+package types
+
+import (
+	"net"
+)
+
+// EmTransport is the carrier over which a communicator sends and receives EVSEMaster datagrams. By default a
+// communicator uses a UDP transport; an in-memory pipe transport is also available (see EmPipeNetwork), which is
+// useful for tests or for embedding the communicator without binding real ports.
+type EmTransport interface {
+	// Open opens the transport. It is called when the communicator starts, and again when the communicator restarts
+	// after the transport failed unexpectedly.
+	Open() error
+
+	// ReadFrom blocks until a datagram is received and copies it into buf. It returns the number of bytes copied and
+	// the address of the sender. Once the transport is closed, it returns an error wrapping net.ErrClosed.
+	ReadFrom(buf []byte) (int, net.Addr, error)
+
+	// WriteTo sends a datagram to the given address.
+	WriteTo(data []byte, addr net.Addr) (int, error)
+
+	// Close closes the transport, unblocking any pending ReadFrom.
+	Close() error
+
+	// LocalAddr returns the local address of the transport, or nil if it is not open.
+	LocalAddr() net.Addr
+}
+
+// EmPipeNetwork is an in-memory network connecting pipe transports. Datagrams written by one endpoint are delivered to
+// the endpoint with the destination address. Datagrams sent to a broadcast address (255.255.255.255) are delivered to
+// all other endpoints listening on the destination port.
+type EmPipeNetwork interface {
+	// Endpoint returns a transport attached to this network with the given local address.
+	Endpoint(addr *net.UDPAddr) EmTransport
+}
@@ -1,18 +1,39 @@
 package types
 
 import (
+	"context"
 	"net"
+	"strings"
 	"time"
 
 	"github.com/sirupsen/logrus"
 )
 
-// EmSerial represents the serial number of an EVSE. This is a string of 16 hexadecimal characters.
+// EmSerial represents the serial number of an EVSE. This is a string of 16 hexadecimal characters, in lowercase as
+// decoded from datagrams.
 type EmSerial string
 
+// Normalized returns the serial in lowercase, as decoded from datagrams.
+func (serial EmSerial) Normalized() EmSerial {
+	return EmSerial(strings.ToLower(string(serial)))
+}
+
 // EmPassword represents the password of an EVSE. This is a string of 6 digits.
 type EmPassword string
 
+// IsValid returns true if the password is a string of 6 digits.
+func (password EmPassword) IsValid() bool {
+	if len(password) != 6 {
+		return false
+	}
+	for _, c := range []byte(password) {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
 // EmCommunicator represents a communicator with a network presence that can manage multiple EmEvse instances.
 type EmCommunicator interface {
 	// AppName returns the app name as specified in createCommunicator. This is used as default userId in ChargeStartParams.
@@ -54,6 +75,47 @@ type EmCommunicator interface {
 	// Call Stop() on the returned watcher to stop receiving events (this will close the channel as well, unblocking
 	// any goroutines waiting for data from the channel).
 	Watch(evse EmEvse, eventTypes []EmEventType, channel chan<- EmEvent) EmEventWatcher
+
+	// WatchWithOptions is like Watch, but lets the caller choose what happens when the channel is full (see
+	// EmWatchOptions). Watch uses the zero EmWatchOptions, i.e. DeliveryStop.
+	WatchWithOptions(evse EmEvse, eventTypes []EmEventType, channel chan<- EmEvent, options EmWatchOptions) EmEventWatcher
+
+	// Subscribe calls callback for every event matching the filter. Each subscriber has its own goroutine, so calls to
+	// one callback never overlap and a slow callback only delays its own events. Panics in the callback are recovered
+	// and logged. Call the returned function to unsubscribe; events that were already queued may still be delivered
+	// after that. Subscriptions end when the communicator is stopped.
+	Subscribe(filter EmEventFilter, callback func(event EmEvent)) (unsubscribe func())
+
+	// RegisterHandler registers a handler for datagrams received from EVSEs. Handlers run in order of priority (lowest
+	// first; the built-in handlers have priority 0), then in order of registration. Registering a handler with the
+	// name of an existing one, including the built-in handlers (see HandlerLoginInfo etc.), replaces it; the
+	// replacement keeps the original's place among handlers of the same priority. Returns an error if name is empty
+	// or handler is nil.
+	RegisterHandler(name string, priority int, handler EmHandler) error
+
+	// UnregisterHandler removes the handler with the given name, which may be a built-in handler. Returns false if
+	// there is no such handler.
+	UnregisterHandler(name string) bool
+
+	// HandlerNames returns the names of the registered handlers, in the order in which they run.
+	HandlerNames() []string
+
+	// Diagnostics returns what the communicator received but could not interpret since it was created (or since
+	// ResetDiagnostics): datagrams with commands that no handler recognized, and enum values and error bits without a
+	// known meaning.
+	Diagnostics() EmDiagnostics
+
+	// ResetDiagnostics discards the diagnostics collected so far.
+	ResetDiagnostics()
+}
+
+// EmEventFilter selects the events for EmCommunicator.Subscribe. The zero value matches all events.
+type EmEventFilter struct {
+	// Evse restricts events to those of one EVSE, or nil for all EVSEs.
+	Evse EmEvse
+
+	// Types restricts events to these types, or empty for all types.
+	Types []EmEventType
 }
 
 type EmEvse interface {
@@ -80,6 +142,51 @@ type EmEvse interface {
 	// to log in when it comes online.
 	UsePassword(password EmPassword) error
 
+	// UsePasswordContext is like UsePassword, but gives up (returning the context's error) when ctx is done.
+	UsePasswordContext(ctx context.Context, password EmPassword) error
+
+	// ChangePassword changes the EVSE's password from oldPassword to newPassword, logging in with oldPassword first
+	// if needed. On success, the new password is stored (like with UsePassword) and the communicator logs in with it
+	// again (retrying in the background if that fails). Returns a PasswordFormatError if either password is not 6
+	// digits, an EvseInvalidPasswordError if the EVSE rejects oldPassword, or an EvsePasswordNotChangedError if the
+	// EVSE responds without changing its password (in which case oldPassword is kept). Experimental: the command is
+	// not verified with a physical EVSE, so this returns an ExperimentalCommandsDisabledError unless the communicator
+	// was created with the WithExperimentalCommands option.
+	ChangePassword(oldPassword EmPassword, newPassword EmPassword) error
+
+	// ChangePasswordContext is like ChangePassword, but gives up (returning the context's error) when ctx is done.
+	// If ctx is done after the EVSE accepted the new password, the new password is still stored.
+	ChangePasswordContext(ctx context.Context, oldPassword EmPassword, newPassword EmPassword) error
+
+	// Clock returns the EVSE's clock, which is used to convert the timestamps in datagrams (see EmClock). Timezones
+	// that are not set with SetClock are those of the communicator's WithClock option, or the defaults.
+	Clock() EmClock
+
+	// SetClock sets the EVSE's clock, e.g. to the timezone where it is installed if the host's timezone differs. It
+	// is saved to the communicator's store, if any. Times already received are converted again when the EVSE next
+	// sends them.
+	SetClock(clock EmClock)
+
+	// GetSystemTime requests the current time of the EVSE's clock. It also updates ClockDrift. Experimental: the
+	// command is not verified with a physical EVSE, so this returns an ExperimentalCommandsDisabledError unless the
+	// communicator was created with the WithExperimentalCommands option.
+	GetSystemTime() (time.Time, error)
+
+	// GetSystemTimeContext is like GetSystemTime, but gives up (returning the context's error) when ctx is done.
+	GetSystemTimeContext(ctx context.Context) (time.Time, error)
+
+	// SetSystemTime sets the EVSE's clock to the given time, converted with Clock(). The EVSE's clock determines
+	// when a scheduled charge (see ChargeStartParams.StartAt) starts. Experimental, like GetSystemTime.
+	SetSystemTime(t time.Time) error
+
+	// SetSystemTimeContext is like SetSystemTime, but gives up (returning the context's error) when ctx is done.
+	SetSystemTimeContext(ctx context.Context, t time.Time) error
+
+	// ClockDrift returns how far the EVSE's clock is ahead of the host's clock (negative if behind), to the second,
+	// as last measured by GetSystemTime. The communicator measures this after logging in if enabled with the
+	// WithClockSync option; nil if not measured yet.
+	ClockDrift() *time.Duration
+
 	// IsOnline returns true if the EVSE is currently online.
 	IsOnline() bool
 
@@ -88,6 +195,7 @@ type EmEvse interface {
 
 	// Info returns static information about the EVSE, such as brand, model, hardware version, etc.
 	// This info will not change over time.
+	// Like State, Charge and Config, this returns a copy; call it again to see newer data.
 	Info() EmEvseInfo
 
 	// State returns the operational state of the EVSE, such as current power, current charging status, etc.
@@ -97,20 +205,79 @@ type EmEvse interface {
 	Charge() EmEvseCharge
 
 	// Config returns configuration information about the EVSE, such as configured max current, etc.
+	// The setters update the EVSE, not the returned copy.
 	Config() EmEvseConfig
 
+	// Capabilities returns what the EVSE is known to support, determined from its info (type, feature flags, firmware
+	// etc.) and state by a table of rules. Until the EVSE's info is received, only capabilities of all EVSEs are set.
+	Capabilities() EmCapabilities
+
+	// CompatReport returns a report of the EVSE's model, firmware and protocol details, with its status in the
+	// compatibility database bundled with the library. At the first login, the communicator logs a warning if the
+	// EVSE is not verified there. The report can be marshalled to JSON and attached to an issue.
+	CompatReport() EmCompatReport
+
+	// Snapshot returns a consistent copy of the EVSE's info, state, charge, config and meta-state, all taken at the
+	// same instant. Use this instead of separate Info(), State() etc. calls when the values must belong together.
+	Snapshot() EmEvseSnapshot
+
 	// StartCharge starts a charging session with the given parameters. If the EVSE is not online or not
 	// logged in, or the car is not plugged in, this will fail.
 	StartCharge(params ChargeStartParams) (ChargeStartResult, error)
 
+	// StartChargeContext is like StartCharge, but stops waiting for confirmation (returning the context's error)
+	// when ctx is done. Note that the charge may still start if the EVSE already received the command.
+	StartChargeContext(ctx context.Context, params ChargeStartParams) (ChargeStartResult, error)
+
 	// StopCharge stops the current or planned charging session. If the EVSE is not online or not logged in,
 	// or no charging session is active or planned, this will fail.
 	StopCharge(params ChargeStopParams) (ChargeStopResult, error)
 
+	// StopChargeContext is like StopCharge, but stops waiting for confirmation (returning the context's error)
+	// when ctx is done. Note that the charge may still stop if the EVSE already received the command.
+	StopChargeContext(ctx context.Context, params ChargeStopParams) (ChargeStopResult, error)
+
+	// SendRaw sends a datagram with the given command and payload to the EVSE, for experimenting with commands the
+	// library doesn't know yet. The EVSE must be online and logged in; the datagram gets the EVSE's serial and
+	// password like any other. If expect is given, SendRaw waits for a datagram with one of those commands (for the
+	// usual request timeout) and returns its payload; otherwise it returns nil once the datagram is sent. Responses
+	// are also passed to the communicator's handlers (see EmCommunicator.RegisterHandler). Only available if the
+	// communicator was created with the WithRawCommands option; otherwise returns a RawCommandsDisabledError.
+	SendRaw(command uint16, payload []byte, expect ...uint16) ([]byte, error)
+
+	// SendRawContext is like SendRaw, but stops waiting for the response (returning the context's error) when ctx
+	// is done.
+	SendRawContext(ctx context.Context, command uint16, payload []byte, expect ...uint16) ([]byte, error)
+
 	// Watch returns a watcher whose channel will receive events for this EVSE. This is the same as calling
 	// Watch() on the communicator with this EVSE as parameter.
 	// Call Stop() on the returned watcher to stop receiving events (this will close the channel as well).
 	Watch(eventTypes []EmEventType, ch chan<- EmEvent) EmEventWatcher
+
+	// WatchWithOptions is like Watch, but with options for delivering events to the channel (see EmWatchOptions).
+	WatchWithOptions(eventTypes []EmEventType, ch chan<- EmEvent, options EmWatchOptions) EmEventWatcher
+
+	// Subscribe calls callback for events of this EVSE. This is the same as calling Subscribe() on the communicator
+	// with this EVSE in the filter.
+	Subscribe(eventTypes []EmEventType, callback func(event EmEvent)) (unsubscribe func())
+}
+
+// EmEvseSnapshot is an immutable copy of an EVSE's data, as returned by EmEvse.Snapshot(). Fetch and the config
+// setters still work on the contained values, but they act on the EVSE and never change the snapshot itself.
+type EmEvseSnapshot struct {
+	Timestamp    time.Time
+	Serial       EmSerial
+	Label        string
+	IP           net.IP
+	Port         int
+	Online       bool
+	LoggedIn     bool
+	MetaState    EmMetaState
+	Capabilities EmCapabilities
+	Info         EmEvseInfo
+	State        EmEvseState
+	Charge       EmEvseCharge
+	Config       EmEvseConfig
 }
 
 type EmEvseInfo interface {
@@ -128,21 +295,24 @@ type EmEvseInfo interface {
 	EvseType() byte
 	// How many phases the EVSE supports (1 or 3).
 	Phases() EmPhases
-	// Whether the EVSE supports forcing 1P charging when connected on 3P.
+	// Whether the EVSE supports forcing 1P charging when connected on 3P. Same as
+	// EmEvse.Capabilities().Has(CapabilityForceSinglePhase).
 	CanForceSinglePhase() bool
 	// Maximum power the EVSE can deliver, in Watts. Computed from values of EvseType() and Byte70().
 	MaxPower() Watts
 	// Maximum current the EVSE can deliver (on each phase), in Amps.
 	MaxCurrent() Amps
-	// Supported feature flags. Meaning unknown.
+	// Supported feature flags. Meaning unknown; see EmEvse.Capabilities for what is known about an EVSE.
 	Feature() uint32
-	// Supported new feature flags. Meaning unknown.
+	// Supported new feature flags. Meaning unknown; see EmEvse.Capabilities for what is known about an EVSE.
 	SupportNew() uint32
 	// Byte with unknown semantics at offset 70 in the SingleACStatus datagram. CanForceSinglePhase() is computed in part from this.
 	Byte70() byte
 
 	// Fetch fetches the latest info from the EVSE if it wasn't fetched less than maxAge ago.
 	Fetch(maxAge time.Duration) error
+	// FetchContext is like Fetch, but gives up (returning the context's error) when ctx is done.
+	FetchContext(ctx context.Context, maxAge time.Duration) error
 }
 
 type EmPhases int
@@ -194,6 +364,8 @@ type EmEvseCharge interface {
 
 	// Fetch fetches the latest charge info from the EVSE if it wasn't fetched less than maxAge ago.
 	Fetch(maxAge time.Duration) error
+	// FetchContext is like Fetch, but gives up (returning the context's error) when ctx is done.
+	FetchContext(ctx context.Context, maxAge time.Duration) error
 }
 
 type EmEvseConfig interface {
@@ -210,6 +382,8 @@ type EmEvseConfig interface {
 
 	// Fetch fetches the latest config from the EVSE if it wasn't fetched less than maxAge ago.
 	Fetch(maxAge time.Duration) error
+	// FetchContext is like Fetch, but gives up (returning the context's error) when ctx is done.
+	FetchContext(ctx context.Context, maxAge time.Duration) error
 
 	// SetName sets the configured name of the EVSE. The name must be a string of maximum 11 characters (truncated if longer), and the EVSE must be online and logged in.
 	SetName(name string) error
@@ -221,6 +395,17 @@ type EmEvseConfig interface {
 	SetOfflineCharge(offlineCharge bool) error
 	// SetMaxCurrent sets the configured maximum current of the EVSE. The EVSE must be online and logged in.
 	SetMaxCurrent(maxCurrent Amps) error
+
+	// SetNameContext is like SetName, but gives up (returning the context's error) when ctx is done.
+	SetNameContext(ctx context.Context, name string) error
+	// SetLanguageContext is like SetLanguage, but gives up (returning the context's error) when ctx is done.
+	SetLanguageContext(ctx context.Context, language EmLanguage) error
+	// SetTemperatureUnitContext is like SetTemperatureUnit, but gives up (returning the context's error) when ctx is done.
+	SetTemperatureUnitContext(ctx context.Context, unit EmTemperatureUnit) error
+	// SetOfflineChargeContext is like SetOfflineCharge, but gives up (returning the context's error) when ctx is done.
+	SetOfflineChargeContext(ctx context.Context, offlineCharge bool) error
+	// SetMaxCurrentContext is like SetMaxCurrent, but gives up (returning the context's error) when ctx is done.
+	SetMaxCurrentContext(ctx context.Context, maxCurrent Amps) error
 }
 
 type ChargeId string
@@ -353,12 +538,73 @@ type EmEventWatcher interface {
 	IsStopped() bool
 	Evse() EmEvse
 	EventTypes() []EmEventType
+
+	// Dropped returns the number of events that could not be delivered to the watcher's channel.
+	Dropped() uint64
+}
+
+// EmDeliveryPolicy determines what a watcher does with an event when its channel is full.
+type EmDeliveryPolicy int
+
+const (
+	// DeliveryStop stops the watcher (closing its channel) when an event doesn't fit in the channel. This is the
+	// default; it suits consumers that can't handle gaps in the event stream, and will re-read all EVSE data when
+	// they notice their channel was closed.
+	DeliveryStop EmDeliveryPolicy = iota
+
+	// DeliveryBlock waits up to EmWatchOptions.BlockTimeout for room in the channel, then drops the event. Note that
+	// this delays event delivery to other watchers while waiting.
+	DeliveryBlock
+
+	// DeliveryDropOldest buffers events that don't fit in the channel in a ring buffer of EmWatchOptions.BufferSize
+	// events, dropping the oldest buffered event when the buffer is full as well.
+	DeliveryDropOldest
+
+	// DeliveryDropNewest drops events that don't fit in the channel.
+	DeliveryDropNewest
+)
+
+// EmWatchOptions holds the options for EmCommunicator.WatchWithOptions.
+type EmWatchOptions struct {
+	Policy EmDeliveryPolicy
+
+	// BlockTimeout is the time to wait for room in the channel with DeliveryBlock. Defaults to 1 second.
+	BlockTimeout time.Duration
+
+	// BufferSize is the size of the ring buffer with DeliveryDropOldest. Defaults to 64 events.
+	BufferSize int
 }
 
 type EmEvent struct {
 	Type      EmEventType
 	Evse      EmEvse
 	Timestamp time.Time
+
+	// Changes lists the fields whose values changed, for the EvseInfoUpdated, EvseStateUpdated, EvseChargeUpdated and
+	// EvseConfigUpdated events. If several updates were debounced into one event, each field has the oldest Old and
+	// newest New value. May be empty, e.g. for updates that only changed something that is not a field.
+	Changes []EmFieldChange
+}
+
+// Change returns the change of the given field (see EmFieldChange.Field), if this event has one.
+func (event EmEvent) Change(field string) (EmFieldChange, bool) {
+	for _, change := range event.Changes {
+		if change.Field == field {
+			return change, true
+		}
+	}
+	return EmFieldChange{}, false
+}
+
+// EmFieldChange describes the change of a single field in an update event.
+type EmFieldChange struct {
+	// Field is the name of the getter for the field, e.g. "CurrentPower" for EmEvseState.CurrentPower(), or "IP",
+	// "Port", "IsOnline" and "IsLoggedIn" for the corresponding EmEvse getters.
+	Field string
+
+	// Old and New hold the values before and after the change, with the getter's return type.
+	Old any
+	New any
 }
 
 type EmEventType string
@@ -378,6 +624,11 @@ const (
 
 	EvseChargeStarted = EmEventType("EVSE_CHARGE_STARTED")
 	EvseChargeStopped = EmEventType("EVSE_CHARGE_STOPPED")
+
+	// EvseClockDrift is queued when the EVSE's clock drifts more than the threshold of the WithClockSync option after
+	// logging in. Its "ClockDrift" change has the drift found as Old, and EmEvse.ClockDrift() as New (the drift after
+	// syncing, if auto-sync is enabled, or else the same).
+	EvseClockDrift = EmEventType("EVSE_CLOCK_DRIFT")
 )
 
 // EvseChanged returns those EmEventTypes that represent any change in the EVSE. That is, the added/removed